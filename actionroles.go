@@ -0,0 +1,154 @@
+package cypress
+
+import (
+	"net/http"
+	"sort"
+
+	"go.uber.org/zap"
+)
+
+var (
+	// DefaultAuthChallenge the WWW-Authenticate challenge sent with the 401 status for the
+	// actions that require an authenticated user, if none of the user providers is a Challenger
+	DefaultAuthChallenge = "Bearer"
+)
+
+// RoleRequirer a controller that declares the roles required by its actions,
+// it's checked while the controller is registered by RegisterController,
+// AsController or RegisterControllerFactory
+type RoleRequirer interface {
+	// RequiredRoles returns the required roles by action names, the user must
+	// have any of the roles to access the action, an empty list means only an
+	// authenticated user is required, the "*" entry applies to all the actions
+	// that do not have an entry
+	RequiredRoles() map[string][]string
+}
+
+// ActionRequirement the access requirement of a registered action
+type ActionRequirement struct {
+	Controller    string   `json:"controller"`
+	Action        string   `json:"action"`
+	Methods       []string `json:"methods,omitempty"`
+	Authenticated bool     `json:"authenticated"`
+	Roles         []string `json:"roles,omitempty"`
+}
+
+// WithRoles creates a ControllerOption that requires the user to have any of the roles
+// to access the given actions, or all actions of the controller if no action is given,
+// an empty roles list only requires the user to be authenticated
+func WithRoles(roles []string, actions ...string) ControllerOption {
+	return ControllerOption(func(controller string, action Action) Action {
+		if containsAction(actions, action.Name) {
+			action.Authenticated = true
+			action.Roles = roles
+		}
+
+		return action
+	})
+}
+
+// applyRequiredRoles applies the roles declared by c to the actions if c implements
+// RoleRequirer
+func applyRequiredRoles(c interface{}, actions []Action) []Action {
+	requirer, ok := c.(RoleRequirer)
+	if !ok {
+		return actions
+	}
+
+	required := requirer.RequiredRoles()
+	for i := range actions {
+		roles, ok := required[actions[i].Name]
+		if !ok {
+			roles, ok = required["*"]
+		}
+
+		if ok {
+			actions[i].Authenticated = true
+			actions[i].Roles = roles
+		}
+	}
+
+	return actions
+}
+
+// checkActionRoles checks the user against the roles required by the action, sends
+// 401 or redirects to the login URL if the user is not authenticated, or 403 if the
+// user does not have any of the roles, returns true if the request could be handled
+// by the action
+func (server *WebServer) checkActionRoles(writer http.ResponseWriter, request *http.Request, action *Action) bool {
+	if !action.Authenticated && len(action.Roles) == 0 {
+		return true
+	}
+
+	user := server.securityHandler.resolveUser(request)
+	if user == nil {
+		if server.securityHandler.challenge(writer, request) {
+			return false
+		}
+
+		if server.securityHandler.loginURL != "" {
+			http.Redirect(writer, request, server.securityHandler.loginURL, http.StatusTemporaryRedirect)
+		} else {
+			writer.Header().Set("WWW-Authenticate", DefaultAuthChallenge)
+			SendError(writer, http.StatusUnauthorized, Localize(request, AccessDeniedMsgKey, "Access denied"))
+		}
+
+		return false
+	}
+
+	if len(action.Roles) == 0 || server.hasAnyRole(user, action.Roles) {
+		return true
+	}
+
+	zap.L().Warn("actionAccessDenied", zap.String("action", action.Name), zap.String("user", user.ID), zap.Strings("roles", action.Roles), zap.String("activityId", GetTraceID(request.Context())))
+	SendError(writer, http.StatusForbidden, Localize(request, AccessDeniedMsgKey, "Access denied"))
+	return false
+}
+
+// hasAnyRole checks if the user has any of the roles, the role hierarchy is honored
+// if the AuthorizationManager is a RoleBasedAuthz
+func (server *WebServer) hasAnyRole(user *UserPrincipal, roles []string) bool {
+	userRoles := make(map[string]bool)
+	if authz, ok := server.securityHandler.authzMgr.(*RoleBasedAuthz); ok {
+		userRoles = authz.expandRoles(user.Roles)
+	} else {
+		for _, role := range user.Roles {
+			userRoles[role] = true
+		}
+	}
+
+	for _, role := range roles {
+		if userRoles[role] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ActionRequirements reports the access requirements of all the registered actions,
+// sorted by the controller and action names
+func (server *WebServer) ActionRequirements() []*ActionRequirement {
+	result := make([]*ActionRequirement, 0, len(server.registeredActions))
+	for controller, actions := range server.registeredActions {
+		for name, action := range actions {
+			result = append(result, &ActionRequirement{
+				Controller:    controller,
+				Action:        name,
+				Methods:       action.Methods,
+				Authenticated: action.Authenticated || len(action.Roles) > 0,
+				Roles:         action.Roles,
+			})
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Controller != result[j].Controller {
+			return result[i].Controller < result[j].Controller
+		}
+
+		return result[i].Action < result[j].Action
+	})
+
+	return result
+}
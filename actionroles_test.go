@@ -0,0 +1,115 @@
+package cypress
+
+import (
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+type TestReportController struct{}
+
+func (c *TestReportController) RequiredRoles() map[string][]string {
+	return map[string][]string{
+		"audit":   {"auditor"},
+		"summary": {},
+	}
+}
+
+func (c *TestReportController) Audit(request *http.Request, response *Response) {
+	response.DoneWithContent(http.StatusOK, "text/plain", []byte("audit"))
+}
+
+func (c *TestReportController) Summary(request *http.Request, response *Response) {
+	response.DoneWithContent(http.StatusOK, "text/plain", []byte("summary"))
+}
+
+func (c *TestReportController) Public(request *http.Request, response *Response) {
+	response.DoneWithContent(http.StatusOK, "text/plain", []byte("public"))
+}
+
+func TestActionRoles(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "cyrolestest")
+	if err != nil {
+		t.Error("failed to create test dir", err)
+		return
+	}
+
+	defer os.RemoveAll(testDir)
+	tmplMgr := NewTemplateManager(testDir, ".tmpl", time.Minute, func(root *template.Template) {}, nil)
+	defer tmplMgr.Close()
+
+	server := NewWebServer(":8099", NewSkinManager(tmplMgr))
+	server.WithStandardRouting("/web")
+	server.AddUserProvider(&TestRoleUserProvider{})
+	server.WithAuthz(NewRoleBasedAuthz().WithRoleHierarchy("admin", "auditor"))
+	server.RegisterController("reports", AsController(&TestReportController{}))
+	server.RegisterController("users", ControllerFunc(func() []Action {
+		return []Action{{Name: "delete", Handler: func(request *http.Request, response *Response) {
+			response.DoneWithContent(http.StatusOK, "text/plain", []byte("deleted"))
+		}}}
+	}), WithRoles([]string{"admin"}))
+
+	handler := server.router
+	serve := func(url string) int {
+		writer := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, url, nil)
+		handler.ServeHTTP(writer, request.WithContext(extentContext(request.Context())))
+		if writer.Code == http.StatusUnauthorized && writer.Header().Get("WWW-Authenticate") == "" {
+			return 0
+		}
+
+		return writer.Code
+	}
+
+	tests := []struct {
+		url    string
+		status int
+	}{
+		{"/web/reports/public", http.StatusOK},
+		{"/web/reports/summary", http.StatusUnauthorized},
+		{"/web/reports/summary?role=guest", http.StatusOK},
+		{"/web/reports/audit", http.StatusUnauthorized},
+		{"/web/reports/audit?role=guest", http.StatusForbidden},
+		{"/web/reports/audit?role=auditor", http.StatusOK},
+		{"/web/reports/audit?role=admin", http.StatusOK},
+		{"/web/users/delete?role=auditor", http.StatusForbidden},
+		{"/web/users/delete?role=admin", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		if status := serve(test.url); status != test.status {
+			t.Error("expecting", test.status, "for", test.url, "but got", status)
+			return
+		}
+	}
+
+	requirements := server.ActionRequirements()
+	if len(requirements) != 4 {
+		t.Error("expecting four actions but got", len(requirements))
+		return
+	}
+
+	audit := requirements[0]
+	if audit.Controller != "reports" || audit.Action != "audit" || !audit.Authenticated || len(audit.Roles) != 1 || audit.Roles[0] != "auditor" {
+		t.Error("unexpected requirement for Audit", audit)
+		return
+	}
+
+	if public := requirements[1]; public.Action != "public" || public.Authenticated {
+		t.Error("unexpected requirement for Public", public)
+		return
+	}
+
+	if summary := requirements[2]; summary.Action != "summary" || !summary.Authenticated || len(summary.Roles) != 0 {
+		t.Error("unexpected requirement for Summary", summary)
+		return
+	}
+
+	if remove := requirements[3]; remove.Controller != "users" || len(remove.Roles) != 1 || remove.Roles[0] != "admin" {
+		t.Error("unexpected requirement for delete", remove)
+	}
+}
@@ -0,0 +1,378 @@
+package cypress
+
+import (
+	"encoding/hex"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	adminPageTemplate, _ = template.New("adminPageTemplate").Parse(`<!DOCTYPE html>
+	<html>
+		<head>
+			<meta http-equiv="Content-Type" content="text/html; charset=utf-8">
+			<meta name="viewport" content="width=device-width, initial-scale=1">
+			<title>{{.ServerName}} - Admin</title>
+			<style>
+			body {
+				font-size:12px;
+				margin:20px 10% 0 10%;
+			}
+			.section {
+				border-top:solid 1px #c9c9c9;
+				padding:10px 0;
+			}
+			td, th {
+				text-align:left;
+				padding-right:20px;
+			}
+			form {
+				display:inline;
+			}
+			</style>
+		</head>
+		<body>
+			<h1>{{.ServerName}} <small>{{.ServerVersion}}</small></h1>
+			<div class="section">
+				<h3>Sessions</h3>
+				{{if .SessionsEnumerable}}
+				<table>
+					<tr><th>ID</th><th>Expiration</th><th>Values</th><th></th></tr>
+					{{range .Sessions}}<tr><td>{{.ID}}</td><td>{{.Expiration}}</td><td>{{.Values}}</td><td><form method="post" action="{{$.Prefix}}/sessions/invalidate"><input type="hidden" name="csrfToken" value="{{$.CSRFToken}}"><input type="hidden" name="id" value="{{.ID}}"><button>Invalidate</button></form></td></tr>{{end}}
+				</table>
+				{{else}}
+				<p>The session store doesn't support enumeration</p>
+				{{end}}
+			</div>
+			<div class="section">
+				<h3>Web sockets</h3>
+				<table>
+					<tr><th>Endpoint</th><th>ID</th><th>User</th><th>Remote address</th><th>Connected at</th><th></th></tr>
+					{{range $endpoint, $sockets := .WebSockets}}{{range $sockets}}<tr><td>{{$endpoint}}</td><td>{{.ID}}</td><td>{{.User}}</td><td>{{.RemoteAddr}}</td><td>{{.ConnectedAt}}</td><td><form method="post" action="{{$.Prefix}}/sockets/disconnect"><input type="hidden" name="csrfToken" value="{{$.CSRFToken}}"><input type="hidden" name="endpoint" value="{{$endpoint}}"><input type="hidden" name="id" value="{{.ID}}"><button>Disconnect</button></form></td></tr>{{end}}{{end}}
+				</table>
+			</div>
+			<div class="section">
+				<h3>Controllers</h3>
+				<table>
+					<tr><th>Controller</th><th>Actions</th></tr>
+					{{range $controller, $actions := .Controllers}}<tr><td>{{$controller}}</td><td>{{range $actions}}{{.}} {{end}}</td></tr>{{end}}
+				</table>
+			</div>
+			<div class="section">
+				<h3>Skins <form method="post" action="{{.Prefix}}/templates/reload"><input type="hidden" name="csrfToken" value="{{.CSRFToken}}"><button>Reload all</button></form></h3>
+				{{range $skin, $templates := .Skins}}
+				<h4>{{$skin}} <form method="post" action="{{$.Prefix}}/templates/reload"><input type="hidden" name="csrfToken" value="{{$.CSRFToken}}"><input type="hidden" name="skin" value="{{$skin}}"><button>Reload</button></form></h4>
+				<table>
+					<tr><th>Template</th><th>Loaded at</th></tr>
+					{{range $templates}}<tr><td>{{.Name}}</td><td>{{.LoadedAt}}</td></tr>{{end}}
+				</table>
+				{{end}}
+			</div>
+			<div style="text-align:center;color:#999;border-top:solid 1px #c9c9c9;line-height:30px;">
+				Powered by {{.ServerName}} - {{.ServerVersion}}
+			</div>
+		</body>
+	</html>
+	`)
+
+	// AdminCSRFField the form field that carries the CSRF token of the admin console
+	AdminCSRFField = "csrfToken"
+
+	// AdminCSRFHeader the http header that carries the CSRF token of the admin console
+	AdminCSRFHeader = "X-CSRF-Token"
+)
+
+const (
+	adminCSRFSessionKey = "cypress$admin$csrf"
+)
+
+// WebSocketInfo summary of a connected web socket session
+type WebSocketInfo struct {
+	ID          string    `json:"id"`
+	User        string    `json:"user,omitempty"`
+	RemoteAddr  string    `json:"remoteAddr"`
+	ConnectedAt time.Time `json:"connectedAt"`
+}
+
+// AdminSnapshot the state of the web server shown by the admin console
+type AdminSnapshot struct {
+	ServerName         string                      `json:"serverName"`
+	ServerVersion      string                      `json:"serverVersion"`
+	Prefix             string                      `json:"-"`
+	SessionsEnumerable bool                        `json:"sessionsEnumerable"`
+	Sessions           []*SessionInfo              `json:"sessions"`
+	WebSockets         map[string][]*WebSocketInfo `json:"webSockets"`
+	Controllers        map[string][]string         `json:"controllers"`
+	Skins              map[string][]*TemplateInfo  `json:"skins"`
+	CSRFToken          string                      `json:"csrfToken,omitempty"`
+}
+
+type adminConsole struct {
+	server *WebServer
+	prefix string
+	roles  []string
+}
+
+// AddAdminConsole mounts the admin console at the prefix, which shows the active sessions if
+// the session store is an EnumerableSessionStore, the connected web sockets, the registered
+// controllers and the loaded templates, and allows to invalidate sessions, disconnect web
+// sockets and reload templates. Only the users with one of the roles could access it, the
+// page is also available as json for requests that accept application/json. The operations
+// are rejected if they are sent from other origins, or without the CSRF token in the page
+// or snapshot if the client has a session cookie, the clients without a session cookie must
+// send the Origin or Referer header of the admin console, whatever they authenticate by
+func (server *WebServer) AddAdminConsole(prefix string, roles ...string) *WebServer {
+	console := &adminConsole{server, strings.TrimSuffix(prefix, "/"), roles}
+	server.router.PathPrefix(console.prefix + "/").Handler(console)
+	return server
+}
+
+// Snapshot takes a snapshot of the state of the web server for the admin console, the
+// ids of the sessions are replaced by their handles, as the session ids are credentials
+func (server *WebServer) Snapshot() (*AdminSnapshot, error) {
+	snapshot := &AdminSnapshot{
+		ServerName:    ServerName,
+		ServerVersion: ServerVersion,
+		WebSockets:    make(map[string][]*WebSocketInfo),
+		Controllers:   make(map[string][]string),
+		Skins:         make(map[string][]*TemplateInfo),
+	}
+
+	if store, ok := server.sessionStore.(EnumerableSessionStore); ok {
+		sessions, err := store.List()
+		if err != nil {
+			return nil, err
+		}
+
+		sort.Slice(sessions, func(i, j int) bool {
+			return sessions[i].Expiration.After(sessions[j].Expiration)
+		})
+
+		snapshot.SessionsEnumerable = true
+		snapshot.Sessions = make([]*SessionInfo, 0, len(sessions))
+		for _, session := range sessions {
+			snapshot.Sessions = append(snapshot.Sessions, &SessionInfo{adminSessionHandle(session.ID), session.Expiration, session.Values})
+		}
+	}
+
+	for endpoint, handler := range server.wsHandlers {
+		sockets := make([]*WebSocketInfo, 0, 8)
+		for _, session := range handler.Sessions() {
+			info := &WebSocketInfo{ID: session.ID, RemoteAddr: session.RemoteAddr, ConnectedAt: session.ConnectedAt}
+			if session.User != nil {
+				info.User = session.User.ID
+			}
+
+			sockets = append(sockets, info)
+		}
+
+		snapshot.WebSockets[endpoint] = sockets
+	}
+
+	for controller, actions := range server.registeredActions {
+		names := make([]string, 0, len(actions))
+		for name := range actions {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+		snapshot.Controllers[controller] = names
+	}
+
+	if server.skinManager != nil {
+		for name, skin := range server.skinManager.Skins() {
+			snapshot.Skins[name] = skin.Templates()
+		}
+	}
+
+	return snapshot, nil
+}
+
+func (console *adminConsole) isAdmin(request *http.Request) (*UserPrincipal, bool) {
+	user := console.server.securityHandler.resolveUser(request)
+	if user == nil {
+		return nil, false
+	}
+
+	return user, console.server.hasAnyRole(user, console.roles)
+}
+
+// ServeHTTP serves incoming http request
+func (console *adminConsole) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	traceID := GetTraceID(request.Context())
+	user, ok := console.isAdmin(request)
+	if !ok {
+		zap.L().Warn("adminAccessDenied", zap.String("path", request.URL.Path), zap.String("activityId", traceID))
+		SendError(writer, http.StatusForbidden, Localize(request, AccessDeniedMsgKey, "Access denied"))
+		return
+	}
+
+	response := &Response{traceID: traceID, request: request, writer: writer}
+	asJSON := strings.Contains(request.Header.Get("Accept"), "application/json")
+	path := strings.TrimPrefix(request.URL.Path, console.prefix)
+	if path == "/" || path == "/api" {
+		if request.Method != http.MethodGet && request.Method != http.MethodHead {
+			writer.Header().Set("Allow", http.MethodGet)
+			SendError(writer, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		snapshot, err := console.server.Snapshot()
+		if err != nil {
+			zap.L().Error("failedToTakeSnapshot", zap.Error(err), zap.String("activityId", traceID))
+			response.doneWithHTTPError(err, asJSON || path == "/api")
+			return
+		}
+
+		if asJSON || path == "/api" {
+			// cookie-less clients do not need the token, don't create sessions for them
+			if hasSessionCookie(request) {
+				snapshot.CSRFToken = console.csrfToken(request)
+			}
+
+			response.DoneWithJSON(http.StatusOK, snapshot)
+			return
+		}
+
+		snapshot.Prefix = console.prefix
+		snapshot.CSRFToken = console.csrfToken(request)
+		writer.Header().Set("Content-Type", "text/html; charset=UTF-8")
+		err = adminPageTemplate.Execute(writer, snapshot)
+		if err != nil {
+			zap.L().Error("failedToRenderAdminPage", zap.Error(err), zap.String("activityId", traceID))
+		}
+
+		return
+	}
+
+	if path != "/sessions/invalidate" && path != "/sockets/disconnect" && path != "/templates/reload" {
+		SendError(writer, http.StatusNotFound, Localize(request, NotFoundMsgKey, NotFoundMsg))
+		return
+	}
+
+	if request.Method != http.MethodPost {
+		writer.Header().Set("Allow", http.MethodPost)
+		SendError(writer, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if !console.checkCSRF(request) {
+		zap.L().Warn("adminCSRFRejected", zap.String("path", request.URL.Path), zap.String("user", user.ID), zap.String("origin", request.Header.Get("Origin")), zap.String("activityId", traceID))
+		SendError(writer, http.StatusForbidden, Localize(request, AccessDeniedMsgKey, "Access denied"))
+		return
+	}
+
+	var err error
+	switch path {
+	case "/sessions/invalidate":
+		err = console.invalidateSession(request.FormValue("id"))
+	case "/sockets/disconnect":
+		err = console.disconnectSocket(request.FormValue("endpoint"), request.FormValue("id"))
+	case "/templates/reload":
+		err = console.reloadTemplates(request.FormValue("skin"))
+	}
+
+	zap.L().Info("adminOperation", zap.String("operation", path), zap.String("user", user.ID), zap.Bool("success", err == nil), zap.String("activityId", traceID))
+	if err != nil {
+		response.doneWithHTTPError(err, asJSON)
+		return
+	}
+
+	if asJSON {
+		writer.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	http.Redirect(writer, request, console.prefix+"/", http.StatusSeeOther)
+}
+
+// csrfToken gets the CSRF token bound to the session, a new token is created if
+// the session does not have one, returns empty string if there is no session
+func (console *adminConsole) csrfToken(request *http.Request) string {
+	return sessionCSRFToken(request, adminCSRFSessionKey)
+}
+
+// checkCSRF checks the request is sent from the same origin, and it carries the
+// CSRF token bound to the session if the client sends the session cookie, the
+// Origin or Referer header is required if there is no session, as the browsers
+// also send other credentials, e.g. Basic authentication, automatically
+func (console *adminConsole) checkCSRF(request *http.Request) bool {
+	sent, same := checkSameOrigin(request)
+	if sent && !same {
+		return false
+	}
+
+	if !hasSessionCookie(request) {
+		// there is no token without the session, the origin must be verified
+		return sent
+	}
+
+	return checkSessionCSRFToken(request, adminCSRFSessionKey, AdminCSRFHeader, AdminCSRFField)
+}
+
+// adminSessionHandle the handle that identifies the session in the admin console
+func adminSessionHandle(id string) string {
+	return hex.EncodeToString(Sha256([]byte(id))[:16])
+}
+
+// invalidateSession invalidates the session with the given handle
+func (console *adminConsole) invalidateSession(handle string) error {
+	store, ok := console.server.sessionStore.(EnumerableSessionStore)
+	if !ok || handle == "" {
+		return ErrSessionNotFound
+	}
+
+	sessions, err := store.List()
+	if err != nil {
+		return err
+	}
+
+	for _, info := range sessions {
+		if adminSessionHandle(info.ID) != handle {
+			continue
+		}
+
+		session, err := store.Get(info.ID)
+		if err != nil {
+			return err
+		}
+
+		session.IsValid = false
+		return store.Save(session, console.server.sessionTimeout)
+	}
+
+	return ErrSessionNotFound
+}
+
+func (console *adminConsole) disconnectSocket(endpoint, id string) error {
+	handler, ok := console.server.wsHandlers[endpoint]
+	if !ok {
+		return NewHTTPError(http.StatusNotFound, "Endpoint not found", nil)
+	}
+
+	session, ok := handler.GetSession(id)
+	if !ok {
+		return NewHTTPError(http.StatusNotFound, "Web socket not found", nil)
+	}
+
+	return session.Close()
+}
+
+func (console *adminConsole) reloadTemplates(skin string) error {
+	if console.server.skinManager == nil {
+		return NewHTTPError(http.StatusNotFound, "No skins", nil)
+	}
+
+	for name, tmplMgr := range console.server.skinManager.Skins() {
+		if skin == "" || skin == name {
+			tmplMgr.Reload()
+		}
+	}
+
+	return nil
+}
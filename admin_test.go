@@ -0,0 +1,229 @@
+package cypress
+
+import (
+	"encoding/json"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestAdminConsole(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "cyadmintest")
+	if err != nil {
+		t.Error("failed to create test dir", err)
+		return
+	}
+
+	defer os.RemoveAll(testDir)
+	err = ioutil.WriteFile(path.Join(testDir, "page.tmpl"), []byte(`{{define "page"}}page{{end}}`), os.ModePerm)
+	if err != nil {
+		t.Error("failed to setup page.tmpl")
+		return
+	}
+
+	tmplMgr := NewTemplateManager(testDir, ".tmpl", time.Minute, func(root *template.Template) {}, nil)
+	defer tmplMgr.Close()
+	sessionStore := NewInMemorySessionStore()
+	defer sessionStore.Close()
+
+	server := NewWebServer(":8099", NewSkinManager(tmplMgr))
+	server.WithSessionOptions(sessionStore, time.Minute)
+	server.WithStandardRouting("/web")
+	server.AddUserProvider(&TestRoleUserProvider{})
+	server.RegisterController("test", AsController(&TestController{}))
+	server.AddWsEndoint("/ws/echo", &TestWsListener{})
+	server.AddAdminConsole("/admin/", "admin")
+	server.HandleFunc("/touch", func(writer http.ResponseWriter, request *http.Request) {
+		GetSession(request).SetValue("touched", true)
+	})
+
+	front := httptest.NewServer(server.pipeline(true))
+	defer front.Close()
+
+	resp, err := http.Get(front.URL + "/touch")
+	if err != nil {
+		t.Error("failed to touch the session", err)
+		return
+	}
+
+	resp.Body.Close()
+	sessionID := ""
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == sessionIDCookieKey {
+			sessionID = cookie.Value
+		}
+	}
+
+	c, _, err := websocket.DefaultDialer.Dial(strings.Replace(front.URL, "http", "ws", 1)+"/ws/echo", nil)
+	if err != nil {
+		t.Error("dial:", err)
+		return
+	}
+
+	defer c.Close()
+	snapshot := func() *AdminSnapshot {
+		request, _ := http.NewRequest(http.MethodGet, front.URL+"/admin/?role=admin", nil)
+		request.Header.Set("Accept", "application/json")
+		resp, err := http.DefaultClient.Do(request)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			return nil
+		}
+
+		defer resp.Body.Close()
+		result := &AdminSnapshot{}
+		if json.NewDecoder(resp.Body).Decode(result) != nil {
+			return nil
+		}
+
+		return result
+	}
+
+	if resp, err = http.Get(front.URL + "/admin/?role=user"); err != nil || resp.StatusCode != http.StatusForbidden {
+		t.Error("expecting non admin users to be denied")
+		return
+	}
+
+	result := snapshot()
+	if result == nil || len(result.Sessions) != 1 || len(result.WebSockets["/ws/echo"]) != 1 || len(result.Controllers["test"]) != 1 || len(result.Skins[SkinDefault]) != 1 {
+		t.Error("unexpected snapshot", result)
+		return
+	}
+
+	if sessionID == "" || result.Sessions[0].ID == sessionID || result.Sessions[0].ID != adminSessionHandle(sessionID) {
+		t.Error("expecting the session handle instead of the session id", result.Sessions[0].ID)
+		return
+	}
+
+	loadedAt := result.Skins[SkinDefault][0].LoadedAt
+	client := &http.Client{CheckRedirect: func(request *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	post := func(operation string, values url.Values) int {
+		request, _ := http.NewRequest(http.MethodPost, front.URL+"/admin/"+operation+"?role=admin", strings.NewReader(values.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.Header.Set("Origin", front.URL)
+		resp, err := client.Do(request)
+		if err != nil {
+			return 0
+		}
+
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := post("sessions/invalidate", url.Values{"id": []string{sessionID}}); status != http.StatusNotFound {
+		t.Error("expecting the raw session id to be rejected but got", status)
+		return
+	}
+
+	if status := post("sessions/invalidate", url.Values{"id": []string{result.Sessions[0].ID}}); status != http.StatusSeeOther {
+		t.Error("expecting to be redirected to admin page but got", status)
+		return
+	}
+
+	if status := post("sockets/disconnect", url.Values{"endpoint": []string{"/ws/echo"}, "id": []string{result.WebSockets["/ws/echo"][0].ID}}); status != http.StatusSeeOther {
+		t.Error("failed to disconnect the web socket", status)
+		return
+	}
+
+	if status := post("templates/reload", nil); status != http.StatusSeeOther {
+		t.Error("failed to reload the templates", status)
+		return
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	result = snapshot()
+	if result == nil || len(result.Sessions) != 0 || len(result.WebSockets["/ws/echo"]) != 0 || !result.Skins[SkinDefault][0].LoadedAt.After(loadedAt) {
+		t.Error("unexpected snapshot after operations", result)
+		return
+	}
+
+	if resp, err = http.Get(front.URL + "/admin/?role=admin"); err != nil || resp.StatusCode != http.StatusOK {
+		t.Error("failed to render admin page")
+		return
+	}
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "/admin/templates/reload") {
+		t.Error("unexpected admin page", string(body))
+		return
+	}
+
+	request, _ := http.NewRequest(http.MethodPost, front.URL+"/admin/templates/reload?role=admin", nil)
+	request.Header.Set("Origin", "http://evil.example.com")
+	if resp, err = client.Do(request); err != nil || resp.StatusCode != http.StatusForbidden {
+		t.Error("expecting cross origin operations to be rejected")
+		return
+	}
+
+	resp.Body.Close()
+	request, _ = http.NewRequest(http.MethodPost, front.URL+"/admin/templates/reload?role=admin", nil)
+	if resp, err = client.Do(request); err != nil || resp.StatusCode != http.StatusForbidden {
+		t.Error("expecting operations without origin to be rejected for cookie-less clients")
+		return
+	}
+
+	resp.Body.Close()
+	jar, _ := cookiejar.New(nil)
+	client.Jar = jar
+	if status := post("templates/reload", nil); status != http.StatusSeeOther {
+		t.Error("failed to reload the templates with a new session", status)
+		return
+	}
+
+	if status := post("templates/reload", nil); status != http.StatusForbidden {
+		t.Error("expecting operations without csrf token to be rejected for session clients", status)
+		return
+	}
+
+	request, _ = http.NewRequest(http.MethodGet, front.URL+"/admin/api?role=admin", nil)
+	resp, err = client.Do(request)
+	if err != nil {
+		t.Error("failed to get the snapshot", err)
+		return
+	}
+
+	result = &AdminSnapshot{}
+	json.NewDecoder(resp.Body).Decode(result)
+	resp.Body.Close()
+	if result.CSRFToken == "" {
+		t.Error("expecting a csrf token for session clients")
+		return
+	}
+
+	if status := post("templates/reload", url.Values{AdminCSRFField: []string{result.CSRFToken}}); status != http.StatusSeeOther {
+		t.Error("expecting operations with csrf token to be accepted", status)
+		return
+	}
+}
+
+func TestAdminConsoleAuthenticatedOnce(t *testing.T) {
+	provider := &TestCountingUserProvider{}
+	server := NewWebServer(":8099", nil)
+	server.AddUserProvider(provider)
+	server.WithAuthz(NewRoleBasedAuthz().WithRoleHierarchy("owner", "admin"))
+	console := &adminConsole{server, "/admin", []string{"admin"}}
+	request := httptest.NewRequest(http.MethodGet, "/admin/?role=owner", nil)
+	request = request.WithContext(extentContext(request.Context()))
+	for i := 0; i < 2; i++ {
+		if _, ok := console.isAdmin(request); !ok {
+			t.Error("expecting the inherited admin role to be accepted")
+			return
+		}
+	}
+
+	if provider.calls != 1 || GetUser(request) == nil {
+		t.Error("expecting the user to be authenticated once but got", provider.calls)
+	}
+}
@@ -0,0 +1,464 @@
+package cypress
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrAPIKeyNotFound the API key is not found in the store
+	ErrAPIKeyNotFound = errors.New("api key not found")
+
+	// APIKeyHeader the default header to read the API key from
+	APIKeyHeader = "X-API-Key"
+
+	// APIKeyQueryParam the default query parameter to read the API key from
+	APIKeyQueryParam = "api_key"
+)
+
+// APIKey an API key issued to an owner, the key is in "<id>.<secret>" form,
+// only the hash of the secret is stored
+type APIKey struct {
+	ID        string    `json:"id"`
+	Hash      string    `json:"hash"`
+	Domain    string    `json:"domain"`
+	Owner     string    `json:"owner"`
+	Name      string    `json:"name"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	LastUsed  time.Time `json:"lastUsed"`
+	Revoked   bool      `json:"revoked"`
+}
+
+// APIKeyStore a store for API keys
+type APIKeyStore interface {
+	// Get gets the key by id, returns ErrAPIKeyNotFound if the key does not exist
+	Get(id string) (*APIKey, error)
+
+	// Save saves the key
+	Save(key *APIKey) error
+
+	// Touch updates the last used time of the key
+	Touch(id string, lastUsed time.Time) error
+
+	// Revoke revokes the key
+	Revoke(id string) error
+}
+
+// APIKeyUserProvider a UserProvider that authenticates the requests by API keys in a
+// header or a query parameter, the user is the owner of the key and the scopes of the
+// key are the roles of the user
+type APIKeyUserProvider struct {
+	store         APIKeyStore
+	header        string
+	queryParam    string
+	touchInterval time.Duration
+}
+
+type inMemoryAPIKeyStore struct {
+	lock *sync.RWMutex
+	keys map[string]*APIKey
+}
+
+type fileAPIKeyStore struct {
+	*inMemoryAPIKeyStore
+	file string
+}
+
+type sqlAPIKeyStore struct {
+	db    Executable
+	table string
+}
+
+type apiKeyRow struct {
+	ID        string `col:"id"`
+	Hash      string `col:"key_hash"`
+	Domain    string `col:"domain"`
+	Owner     string `col:"owner"`
+	Name      string `col:"name"`
+	Scopes    string `col:"scopes"`
+	CreatedAt int64  `col:"created_at"`
+	ExpiresAt int64  `col:"expires_at"`
+	LastUsed  int64  `col:"last_used"`
+	Revoked   bool   `col:"revoked"`
+}
+
+// IsValid checks if the key is not revoked and not expired
+func (key *APIKey) IsValid() bool {
+	return !key.Revoked && (key.ExpiresAt.IsZero() || time.Now().Before(key.ExpiresAt))
+}
+
+// NewInMemoryAPIKeyStore creates an API key store in memory
+func NewInMemoryAPIKeyStore() APIKeyStore {
+	return newInMemoryAPIKeyStore()
+}
+
+func newInMemoryAPIKeyStore() *inMemoryAPIKeyStore {
+	return &inMemoryAPIKeyStore{&sync.RWMutex{}, make(map[string]*APIKey)}
+}
+
+// Get implements APIKeyStore
+func (store *inMemoryAPIKeyStore) Get(id string) (*APIKey, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	key, ok := store.keys[id]
+	if !ok {
+		return nil, ErrAPIKeyNotFound
+	}
+
+	copied := *key
+	return &copied, nil
+}
+
+// Save implements APIKeyStore
+func (store *inMemoryAPIKeyStore) Save(key *APIKey) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	copied := *key
+	store.keys[key.ID] = &copied
+	return nil
+}
+
+// Touch implements APIKeyStore
+func (store *inMemoryAPIKeyStore) Touch(id string, lastUsed time.Time) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	key, ok := store.keys[id]
+	if !ok {
+		return ErrAPIKeyNotFound
+	}
+
+	key.LastUsed = lastUsed
+	return nil
+}
+
+// Revoke implements APIKeyStore
+func (store *inMemoryAPIKeyStore) Revoke(id string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	key, ok := store.keys[id]
+	if !ok {
+		return ErrAPIKeyNotFound
+	}
+
+	key.Revoked = true
+	return nil
+}
+
+// NewFileAPIKeyStore creates an API key store that persists the keys as json to the file,
+// the file is loaded if it exists
+func NewFileAPIKeyStore(file string) (APIKeyStore, error) {
+	store := &fileAPIKeyStore{newInMemoryAPIKeyStore(), file}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return store, nil
+		}
+
+		return nil, err
+	}
+
+	keys := make([]*APIKey, 0, 10)
+	if err = json.Unmarshal(data, &keys); err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		store.keys[key.ID] = key
+	}
+
+	return store, nil
+}
+
+// flush writes all keys to the file, the caller must hold the lock
+func (store *fileAPIKeyStore) flush() error {
+	keys := make([]*APIKey, 0, len(store.keys))
+	for _, key := range store.keys {
+		keys = append(keys, key)
+	}
+
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tempFile := store.file + ".tmp"
+	if err = ioutil.WriteFile(tempFile, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tempFile, store.file)
+}
+
+// Save implements APIKeyStore
+func (store *fileAPIKeyStore) Save(key *APIKey) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	copied := *key
+	store.keys[key.ID] = &copied
+	return store.flush()
+}
+
+// Touch implements APIKeyStore
+func (store *fileAPIKeyStore) Touch(id string, lastUsed time.Time) error {
+	if err := store.inMemoryAPIKeyStore.Touch(id, lastUsed); err != nil {
+		return err
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	return store.flush()
+}
+
+// Revoke implements APIKeyStore
+func (store *fileAPIKeyStore) Revoke(id string) error {
+	if err := store.inMemoryAPIKeyStore.Revoke(id); err != nil {
+		return err
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	return store.flush()
+}
+
+// NewSQLAPIKeyStore creates an API key store backed by the table, which requires columns
+// id (primary key), key_hash, domain, owner, name, scopes (comma separated), created_at,
+// expires_at, last_used (unix seconds, 0 for none) and revoked (bool)
+func NewSQLAPIKeyStore(db Executable, table string) APIKeyStore {
+	return &sqlAPIKeyStore{db, table}
+}
+
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.Unix()
+}
+
+func timeOrZero(unix int64) time.Time {
+	if unix == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(unix, 0)
+}
+
+// Get implements APIKeyStore
+func (store *sqlAPIKeyStore) Get(id string) (*APIKey, error) {
+	obj, err := QueryOne(context.Background(), store.db, NewSmartMapper(&apiKeyRow{}),
+		"SELECT id, key_hash, domain, owner, name, scopes, created_at, expires_at, last_used, revoked FROM "+store.table+" WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+
+	if obj == nil {
+		return nil, ErrAPIKeyNotFound
+	}
+
+	row := obj.(*apiKeyRow)
+	key := &APIKey{
+		ID:        row.ID,
+		Hash:      row.Hash,
+		Domain:    row.Domain,
+		Owner:     row.Owner,
+		Name:      row.Name,
+		CreatedAt: timeOrZero(row.CreatedAt),
+		ExpiresAt: timeOrZero(row.ExpiresAt),
+		LastUsed:  timeOrZero(row.LastUsed),
+		Revoked:   row.Revoked,
+	}
+
+	if row.Scopes != "" {
+		key.Scopes = strings.Split(row.Scopes, ",")
+	}
+
+	return key, nil
+}
+
+// Save implements APIKeyStore
+func (store *sqlAPIKeyStore) Save(key *APIKey) error {
+	var count int
+	err := store.db.QueryRowContext(context.Background(), "SELECT COUNT(*) FROM "+store.table+" WHERE id = ?", key.ID).Scan(&count)
+	if err != nil {
+		return err
+	}
+
+	args := []interface{}{key.Hash, key.Domain, key.Owner, key.Name, strings.Join(key.Scopes, ","),
+		unixOrZero(key.CreatedAt), unixOrZero(key.ExpiresAt), unixOrZero(key.LastUsed), key.Revoked, key.ID}
+	query := "INSERT INTO " + store.table + " (key_hash, domain, owner, name, scopes, created_at, expires_at, last_used, revoked, id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	if count > 0 {
+		query = "UPDATE " + store.table + " SET key_hash = ?, domain = ?, owner = ?, name = ?, scopes = ?, created_at = ?, expires_at = ?, last_used = ?, revoked = ? WHERE id = ?"
+	}
+
+	_, err = store.db.ExecContext(context.Background(), query, args...)
+	return err
+}
+
+// Touch implements APIKeyStore
+func (store *sqlAPIKeyStore) Touch(id string, lastUsed time.Time) error {
+	_, err := store.db.ExecContext(context.Background(), "UPDATE "+store.table+" SET last_used = ? WHERE id = ?", lastUsed.Unix(), id)
+	return err
+}
+
+// Revoke implements APIKeyStore
+func (store *sqlAPIKeyStore) Revoke(id string) error {
+	_, err := store.db.ExecContext(context.Background(), "UPDATE "+store.table+" SET revoked = ? WHERE id = ?", true, id)
+	return err
+}
+
+// NewAPIKeyUserProvider creates a user provider that reads API keys from the APIKeyHeader
+// header or the APIKeyQueryParam query parameter and verifies them by the store
+func NewAPIKeyUserProvider(store APIKeyStore) *APIKeyUserProvider {
+	return &APIKeyUserProvider{
+		store:         store,
+		header:        APIKeyHeader,
+		queryParam:    APIKeyQueryParam,
+		touchInterval: time.Minute,
+	}
+}
+
+// WithHeader sets the header to read the API key from, empty to disable
+func (provider *APIKeyUserProvider) WithHeader(header string) *APIKeyUserProvider {
+	provider.header = header
+	return provider
+}
+
+// WithQueryParam sets the query parameter to read the API key from, empty to disable
+func (provider *APIKeyUserProvider) WithQueryParam(queryParam string) *APIKeyUserProvider {
+	provider.queryParam = queryParam
+	return provider
+}
+
+// WithTouchInterval sets the minimal interval to update the last used time of a key,
+// to reduce the writes to the store, default to a minute
+func (provider *APIKeyUserProvider) WithTouchInterval(interval time.Duration) *APIKeyUserProvider {
+	provider.touchInterval = interval
+	return provider
+}
+
+// GetName implements UserProvider interface
+func (provider *APIKeyUserProvider) GetName() string {
+	return "apikey"
+}
+
+func hashAPIKeySecret(secret string) string {
+	return hex.EncodeToString(Sha256([]byte(secret)))
+}
+
+// Verify verifies the API key and returns the key info if it's valid
+func (provider *APIKeyUserProvider) Verify(apiKey string) (*APIKey, error) {
+	index := strings.Index(apiKey, ".")
+	if index <= 0 {
+		return nil, ErrAPIKeyNotFound
+	}
+
+	key, err := provider.store.Get(apiKey[:index])
+	if err != nil {
+		return nil, err
+	}
+
+	if !hmac.Equal([]byte(key.Hash), []byte(hashAPIKeySecret(apiKey[index+1:]))) || !key.IsValid() {
+		return nil, ErrAPIKeyNotFound
+	}
+
+	now := time.Now()
+	if now.Sub(key.LastUsed) >= provider.touchInterval {
+		key.LastUsed = now
+		if err = provider.store.Touch(key.ID, now); err != nil {
+			zap.L().Error("failedToTouchAPIKey", zap.String("keyId", key.ID), zap.Error(err))
+		}
+	}
+
+	return key, nil
+}
+
+// Authenticate implements UserProvider interface, the user is the owner of the key,
+// the key is available as UserPrincipal.Self
+func (provider *APIKeyUserProvider) Authenticate(request *http.Request) *UserPrincipal {
+	apiKey := ""
+	if provider.header != "" {
+		apiKey = request.Header.Get(provider.header)
+	}
+
+	if apiKey == "" && provider.queryParam != "" {
+		apiKey = request.URL.Query().Get(provider.queryParam)
+	}
+
+	if apiKey == "" {
+		return nil
+	}
+
+	key, err := provider.Verify(apiKey)
+	if err != nil {
+		zap.L().Warn("badAPIKey", zap.Error(err), zap.String("remoteAddr", request.RemoteAddr), zap.String("activityId", GetTraceID(request.Context())))
+		return nil
+	}
+
+	return &UserPrincipal{
+		ID:     key.Owner,
+		Domain: key.Domain,
+		Name:   key.Owner,
+		Roles:  key.Scopes,
+		Self:   key,
+	}
+}
+
+// Load implements UserProvider interface, API keys could not be loaded as users
+func (provider *APIKeyUserProvider) Load(domain, id string) *UserPrincipal {
+	return nil
+}
+
+// Issue creates a key for the owner with the scopes, the key never expires if ttl is zero,
+// the returned API key is the only copy of the secret and cannot be recovered
+func (provider *APIKeyUserProvider) Issue(domain, owner, name string, scopes []string, ttl time.Duration) (string, *APIKey, error) {
+	id := make([]byte, 8)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", nil, err
+	}
+
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, err
+	}
+
+	secretValue := base64.RawURLEncoding.EncodeToString(secret)
+	key := &APIKey{
+		ID:        hex.EncodeToString(id),
+		Hash:      hashAPIKeySecret(secretValue),
+		Domain:    domain,
+		Owner:     owner,
+		Name:      name,
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+	}
+
+	if ttl > 0 {
+		key.ExpiresAt = key.CreatedAt.Add(ttl)
+	}
+
+	if err := provider.store.Save(key); err != nil {
+		return "", nil, err
+	}
+
+	return key.ID + "." + secretValue, key, nil
+}
+
+// Revoke revokes the key with the id
+func (provider *APIKeyUserProvider) Revoke(id string) error {
+	return provider.store.Revoke(id)
+}
@@ -0,0 +1,113 @@
+package cypress
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestAPIKeyUserProvider(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "cyapikeytest")
+	if err != nil {
+		t.Error("failed to create test dir", err)
+		return
+	}
+
+	defer os.RemoveAll(testDir)
+	db, err := sql.Open("sqlite3", path.Join(testDir, "keys.db"))
+	if err != nil {
+		t.Error("failed to open the database file", err)
+		return
+	}
+
+	defer db.Close()
+	_, err = db.Exec("create table api_key(id varchar(32) primary key, key_hash varchar(64), domain varchar(64), owner varchar(64), name varchar(100), scopes varchar(500), created_at bigint, expires_at bigint, last_used bigint, revoked boolean)")
+	if err != nil {
+		t.Error("failed to create test table", err)
+		return
+	}
+
+	fileStore, err := NewFileAPIKeyStore(path.Join(testDir, "keys.json"))
+	if err != nil {
+		t.Error("failed to create file store", err)
+		return
+	}
+
+	stores := map[string]APIKeyStore{
+		"memory": NewInMemoryAPIKeyStore(),
+		"file":   fileStore,
+		"sql":    NewSQLAPIKeyStore(db, "api_key"),
+	}
+
+	for name, store := range stores {
+		provider := NewAPIKeyUserProvider(store).WithTouchInterval(0)
+		authenticate := func(header, query string) *UserPrincipal {
+			request, _ := http.NewRequest(http.MethodGet, "/api?api_key="+query, nil)
+			if header != "" {
+				request.Header.Set(APIKeyHeader, header)
+			}
+
+			return provider.Authenticate(request)
+		}
+
+		apiKey, key, err := provider.Issue("partner", "acme", "reporting", []string{"report.read", "report.write"}, 0)
+		if err != nil {
+			t.Error("failed to issue key for", name, err)
+			return
+		}
+
+		stored, err := store.Get(key.ID)
+		if err != nil || stored.Hash == "" || stored.Hash == apiKey[len(key.ID)+1:] || !stored.LastUsed.IsZero() {
+			t.Error("unexpected stored key for", name, stored, err)
+			return
+		}
+
+		user := authenticate(apiKey, "")
+		if user == nil || user.ID != "acme" || user.Name != "acme" || user.Self.(*APIKey).ID != key.ID || user.Domain != "partner" || len(user.Roles) != 2 || user.Roles[1] != "report.write" {
+			t.Error("unexpected user by header for", name, user)
+			return
+		}
+
+		if authenticate("", apiKey) == nil {
+			t.Error("expecting key in query to be accepted for", name)
+			return
+		}
+
+		if authenticate(apiKey[:len(apiKey)-1], "") != nil || authenticate("bad", "") != nil {
+			t.Error("expecting bad keys to be rejected for", name)
+			return
+		}
+
+		if stored, err = store.Get(key.ID); err != nil || stored.LastUsed.IsZero() {
+			t.Error("expecting last used time to be tracked for", name, err)
+			return
+		}
+
+		if err = provider.Revoke(key.ID); err != nil || authenticate(apiKey, "") != nil {
+			t.Error("expecting revoked key to be rejected for", name, err)
+			return
+		}
+
+		apiKey, _, err = provider.Issue("partner", "acme", "temp", nil, time.Second)
+		if err != nil || authenticate(apiKey, "") == nil {
+			t.Error("expecting temporary key to be accepted for", name, err)
+			return
+		}
+
+		time.Sleep(1100 * time.Millisecond)
+		if authenticate(apiKey, "") != nil {
+			t.Error("expecting expired key to be rejected for", name)
+			return
+		}
+	}
+
+	reloaded, err := NewFileAPIKeyStore(path.Join(testDir, "keys.json"))
+	if err != nil || len(reloaded.(*fileAPIKeyStore).keys) != 2 {
+		t.Error("failed to reload keys from file", err)
+		return
+	}
+}
@@ -0,0 +1,199 @@
+package cypress
+
+import (
+	"bufio"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrBadUserFile the user file has malformed lines
+	ErrBadUserFile = errors.New("bad user file")
+)
+
+type basicAuthUser struct {
+	passwordHash string
+	roles        []string
+}
+
+// BasicAuthUserProvider a UserProvider that authenticates the requests by HTTP Basic
+// authentication against a user file, each line of the file is in the form of
+// "[domain\]user:password-hash[:role1,role2...]", where the password hash is
+// generated by HashPassword, empty lines and lines start with # are ignored. The
+// file is reloaded when it's changed, clients are challenged by WWW-Authenticate
+// when the anonymous requests are denied by the SecurityHandler
+type BasicAuthUserProvider struct {
+	file             string
+	realm            string
+	redirectBrowsers bool
+	lock             *sync.RWMutex
+	users            map[string]*basicAuthUser
+	lastModified     time.Time
+	refresher        *time.Ticker
+	exitChan         chan bool
+}
+
+// NewBasicAuthUserProvider creates a BasicAuthUserProvider for the user file, the file
+// is checked for changes every refreshInterval
+func NewBasicAuthUserProvider(file, realm string, refreshInterval time.Duration) (*BasicAuthUserProvider, error) {
+	provider := &BasicAuthUserProvider{
+		file:      file,
+		realm:     realm,
+		lock:      &sync.RWMutex{},
+		users:     make(map[string]*basicAuthUser),
+		refresher: time.NewTicker(refreshInterval),
+		exitChan:  make(chan bool),
+	}
+
+	if err := provider.reload(); err != nil {
+		provider.refresher.Stop()
+		return nil, err
+	}
+
+	go func(p *BasicAuthUserProvider) {
+		for {
+			select {
+			case <-p.refresher.C:
+				if err := p.reload(); err != nil {
+					zap.L().Error("failedToReloadUserFile", zap.String("file", p.file), zap.Error(err))
+				}
+			case <-p.exitChan:
+				return
+			}
+		}
+	}(provider)
+
+	return provider, nil
+}
+
+// WithBrowserRedirect lets the browsers, the requests that accept text/html, be
+// redirected to the login URL of the SecurityHandler instead of being challenged
+func (provider *BasicAuthUserProvider) WithBrowserRedirect() *BasicAuthUserProvider {
+	provider.redirectBrowsers = true
+	return provider
+}
+
+// Close stops watching the user file
+func (provider *BasicAuthUserProvider) Close() {
+	provider.exitChan <- true
+	provider.refresher.Stop()
+	close(provider.exitChan)
+}
+
+func basicAuthUserKey(domain, id string) string {
+	return domain + "\\" + id
+}
+
+// reload loads the user file if it's changed since last load
+func (provider *BasicAuthUserProvider) reload() error {
+	stat, err := os.Stat(provider.file)
+	if err != nil {
+		return err
+	}
+
+	if stat.ModTime().Equal(provider.lastModified) {
+		return nil
+	}
+
+	file, err := os.Open(provider.file)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+	users := make(map[string]*basicAuthUser)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) < 2 || fields[0] == "" {
+			return ErrBadUserFile
+		}
+
+		user := &basicAuthUser{passwordHash: fields[1]}
+		if len(fields) == 3 && fields[2] != "" {
+			user.roles = strings.Split(fields[2], ",")
+		}
+
+		domain, id := splitDomainUser(fields[0])
+		users[basicAuthUserKey(domain, id)] = user
+	}
+
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+
+	provider.lock.Lock()
+	defer provider.lock.Unlock()
+	provider.users = users
+	provider.lastModified = stat.ModTime()
+	zap.L().Info("userFileLoaded", zap.String("file", provider.file), zap.Int("users", len(users)))
+	return nil
+}
+
+// splitDomainUser splits the user name in domain\user form
+func splitDomainUser(userName string) (string, string) {
+	if index := strings.Index(userName, "\\"); index >= 0 {
+		return userName[:index], userName[index+1:]
+	}
+
+	return "", userName
+}
+
+func (provider *BasicAuthUserProvider) getUser(domain, id string) *basicAuthUser {
+	provider.lock.RLock()
+	defer provider.lock.RUnlock()
+	return provider.users[basicAuthUserKey(domain, id)]
+}
+
+// GetName implements UserProvider interface
+func (provider *BasicAuthUserProvider) GetName() string {
+	return "basic"
+}
+
+// Authenticate implements UserProvider interface
+func (provider *BasicAuthUserProvider) Authenticate(request *http.Request) *UserPrincipal {
+	userName, password, ok := request.BasicAuth()
+	if !ok {
+		return nil
+	}
+
+	domain, id := splitDomainUser(userName)
+	user := provider.getUser(domain, id)
+	if user == nil || !VerifyPassword(user.passwordHash, password) {
+		zap.L().Warn("basicAuthFailed", zap.String("userName", userName), zap.String("remoteAddr", request.RemoteAddr), zap.String("activityId", GetTraceID(request.Context())))
+		return nil
+	}
+
+	return &UserPrincipal{ID: id, Domain: domain, Name: id, Roles: user.roles}
+}
+
+// Load implements UserProvider interface
+func (provider *BasicAuthUserProvider) Load(domain, id string) *UserPrincipal {
+	user := provider.getUser(domain, id)
+	if user == nil {
+		return nil
+	}
+
+	return &UserPrincipal{ID: id, Domain: domain, Name: id, Roles: user.roles, Provider: provider.GetName()}
+}
+
+func (provider *BasicAuthUserProvider) redirectsBrowser(request *http.Request) bool {
+	return provider.redirectBrowsers && strings.Contains(request.Header.Get("Accept"), "text/html")
+}
+
+// Challenge implements Challenger interface
+func (provider *BasicAuthUserProvider) Challenge(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("WWW-Authenticate", "Basic realm=\""+strings.Replace(provider.realm, "\"", "'", -1)+"\", charset=\"UTF-8\"")
+	SendError(writer, http.StatusUnauthorized, Localize(request, AccessDeniedMsgKey, "Access denied"))
+}
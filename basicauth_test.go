@@ -0,0 +1,118 @@
+package cypress
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestBasicAuthUserProvider(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "cybasictest")
+	if err != nil {
+		t.Error("failed to create test dir", err)
+		return
+	}
+
+	defer os.RemoveAll(testDir)
+	adminHash, _ := HashPassword("secret")
+	userHash, _ := HashPassword("password")
+	userFile := path.Join(testDir, "users")
+	err = ioutil.WriteFile(userFile, []byte("# test users\nadmin:"+adminHash+":admin,user\ncorp\\admin:"+userHash+"\n"), os.ModePerm)
+	if err != nil {
+		t.Error("failed to write user file", err)
+		return
+	}
+
+	provider, err := NewBasicAuthUserProvider(userFile, "test \"realm\"", 10*time.Millisecond)
+	if err != nil {
+		t.Error("failed to create provider", err)
+		return
+	}
+
+	defer provider.Close()
+	security := NewSecurityHandler().WithAuthz(&TestRPCAuthz{}).AddUserProvider(provider).WithLoginURL("/login")
+	security.WithPipeline(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		user := GetUser(request)
+		writer.Write([]byte(user.Domain + "/" + user.ID))
+	}))
+
+	call := func(userName, password string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/admin.secret", nil)
+		request = request.WithContext(extentContext(request.Context()))
+		if userName == "browser" {
+			request.Header.Set("Accept", "text/html,application/xhtml+xml")
+		} else if userName != "" {
+			request.SetBasicAuth(userName, password)
+		}
+
+		recorder := httptest.NewRecorder()
+		security.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	resp := call("", "")
+	if resp.Code != http.StatusUnauthorized || resp.Header().Get("WWW-Authenticate") != "Basic realm=\"test 'realm'\", charset=\"UTF-8\"" {
+		t.Error("expecting anonymous request to be challenged", resp.Code, resp.Header())
+		return
+	}
+
+	if resp = call("browser", ""); resp.Code != http.StatusUnauthorized || resp.Header().Get("WWW-Authenticate") == "" {
+		t.Error("expecting browser to be challenged", resp.Code, resp.Header())
+		return
+	}
+
+	provider.WithBrowserRedirect()
+	if resp = call("browser", ""); resp.Code != http.StatusTemporaryRedirect || resp.Header().Get("Location") != "/login" {
+		t.Error("expecting browser to be redirected to the login page", resp.Code, resp.Header())
+		return
+	}
+
+	if resp = call("", ""); resp.Code != http.StatusUnauthorized {
+		t.Error("expecting non-browser request to be challenged", resp.Code)
+		return
+	}
+
+	if resp = call("admin", "wrong"); resp.Code != http.StatusUnauthorized {
+		t.Error("expecting wrong password to be challenged", resp.Code)
+		return
+	}
+
+	if resp = call("admin", "secret"); resp.Code != http.StatusOK || resp.Body.String() != "/admin" {
+		t.Error("expecting admin to be authenticated", resp.Code, resp.Body.String())
+		return
+	}
+
+	user := provider.Load("corp", "admin")
+	if user == nil || len(user.Roles) != 0 || provider.Load("", "admin").Roles[1] != "user" {
+		t.Error("unexpected users loaded", user)
+		return
+	}
+
+	if resp = call("corp\\admin", "password"); resp.Code != http.StatusOK || resp.Body.String() != "corp/admin" {
+		t.Error("expecting domain user to be authenticated", resp.Code, resp.Body.String())
+		return
+	}
+
+	err = ioutil.WriteFile(userFile, []byte("admin:"+userHash+"\n"), os.ModePerm)
+	if err != nil {
+		t.Error("failed to update user file", err)
+		return
+	}
+
+	later := time.Now().Add(time.Second)
+	os.Chtimes(userFile, later, later)
+	time.Sleep(100 * time.Millisecond)
+	if resp = call("admin", "secret"); resp.Code != http.StatusUnauthorized {
+		t.Error("expecting old password to be rejected after reload", resp.Code)
+		return
+	}
+
+	if resp = call("admin", "password"); resp.Code != http.StatusOK || provider.Load("corp", "admin") != nil {
+		t.Error("expecting user file to be reloaded", resp.Code)
+		return
+	}
+}
@@ -2,6 +2,7 @@ package cypress
 
 import (
 	"context"
+	"net/http"
 	"sync"
 	"time"
 )
@@ -9,10 +10,18 @@ import (
 // TraceActivityIDKey context key for trace activity id
 const (
 	TraceActivityIDKey = "TraceActivityID"
+	CorrelationIDKey   = "CorrelationID"
 	UserPrincipalKey   = "UserPrincipal"
 	SessionKey         = "UserSession"
+	LocaleKey          = "Locale"
+	I18nKey            = "I18n"
+	CSPNonceKey        = "CSPNonce"
 )
 
+// multiValueCtxKey the key that resolves the multiValueCtx itself, so that it could be
+// found from the contexts derived from it, e.g. the one with the route variables
+type multiValueCtxKey struct{}
+
 type multiValueCtx struct {
 	lock   *sync.RWMutex
 	values map[string]interface{}
@@ -48,6 +57,10 @@ func (ctx *multiValueCtx) Err() error {
 
 // Value value for the given key
 func (ctx *multiValueCtx) Value(contextKey interface{}) interface{} {
+	if _, ok := contextKey.(multiValueCtxKey); ok {
+		return ctx
+	}
+
 	ctx.lock.RLock()
 	defer ctx.lock.RUnlock()
 	key, ok := contextKey.(string)
@@ -61,6 +74,13 @@ func (ctx *multiValueCtx) Value(contextKey interface{}) interface{} {
 	return ctx.parent.Value(contextKey)
 }
 
+// getMultiValueCtx gets the multiValueCtx of the request, the context of the request
+// could be derived from it, e.g. by the router
+func getMultiValueCtx(request *http.Request) (*multiValueCtx, bool) {
+	ctx, ok := request.Context().Value(multiValueCtxKey{}).(*multiValueCtx)
+	return ctx, ok
+}
+
 func (ctx *multiValueCtx) withValue(key string, value interface{}) *multiValueCtx {
 	ctx.lock.Lock()
 	defer ctx.lock.Unlock()
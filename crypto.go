@@ -4,10 +4,24 @@ import (
 	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/md5"
+	"crypto/rand"
 	"crypto/sha1"
 	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
 	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// PasswordHashIterations the PBKDF2 iterations used by HashPassword
+	PasswordHashIterations = 10000
+
+	passwordHashPrefix = "$pbkdf2-sha256$"
 )
 
 // Md5 returns the md5 checksum of the data
@@ -28,6 +42,90 @@ func Sha1(data []byte) []byte {
 	return sum[:]
 }
 
+// HmacSha256 returns the HMAC-SHA256 of the data with the given key
+func HmacSha256(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// HmacSha1 returns the HMAC-SHA1 of the data with the given key
+func HmacSha1(key, data []byte) []byte {
+	mac := hmac.New(sha1.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// Pbkdf2Sha256 derives a key with keyLen bytes from the password and salt by PBKDF2
+// with HMAC-SHA256 as defined in RFC 8018
+func Pbkdf2Sha256(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	result := make([]byte, 0, keyLen+prf.Size())
+	block := make([]byte, 4)
+	for index := uint32(1); len(result) < keyLen; index++ {
+		binary.BigEndian.PutUint32(block, index)
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write(block)
+		u := prf.Sum(nil)
+		t := make([]byte, len(u))
+		copy(t, u)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+
+		result = append(result, t...)
+	}
+
+	return result[:keyLen]
+}
+
+// HashPassword hashes the password with a random salt, the result is in
+// $pbkdf2-sha256$<iterations>$<salt>$<hash> form
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	hash := Pbkdf2Sha256([]byte(password), salt, PasswordHashIterations, 32)
+	return fmt.Sprintf("%s%d$%s$%s", passwordHashPrefix, PasswordHashIterations, base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(hash)), nil
+}
+
+// VerifyPassword checks if the password matches the hash generated by HashPassword
+func VerifyPassword(passwordHash, password string) bool {
+	if !strings.HasPrefix(passwordHash, passwordHashPrefix) {
+		return false
+	}
+
+	parts := strings.Split(passwordHash[len(passwordHashPrefix):], "$")
+	if len(parts) != 3 {
+		return false
+	}
+
+	iterations, err := strconv.Atoi(parts[0])
+	if err != nil || iterations <= 0 {
+		return false
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+
+	hash, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil || len(hash) == 0 {
+		return false
+	}
+
+	return hmac.Equal(hash, Pbkdf2Sha256([]byte(password), salt, iterations, len(hash)))
+}
+
 // Aes256Encrypt encrypts the data with given key and iv using AES256/CBC/PKCS5Padding
 func Aes256Encrypt(key, iv, data []byte) ([]byte, error) {
 	if key == nil || len(key) == 0 {
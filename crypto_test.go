@@ -30,6 +30,44 @@ func TestSha256(t *testing.T) {
 	}
 }
 
+func TestHmac(t *testing.T) {
+	s := "text to hash"
+	result := hex.EncodeToString(HmacSha256([]byte("key"), []byte(s)))
+	if result != "ad4c20f56bd7b97384484230226719a8333290fc07fbe4f5014c4cd0b65003d5" {
+		t.Error(result, "but ad4c20f56bd7b97384484230226719a8333290fc07fbe4f5014c4cd0b65003d5 expected")
+	}
+
+	result = hex.EncodeToString(HmacSha1([]byte("key"), []byte(s)))
+	if result != "9551d42bfe426d9084ea82cb5e346adb41d24818" {
+		t.Error(result, "but 9551d42bfe426d9084ea82cb5e346adb41d24818 expected")
+	}
+}
+
+func TestPasswordHash(t *testing.T) {
+	// test vector from RFC 7914
+	result := hex.EncodeToString(Pbkdf2Sha256([]byte("passwd"), []byte("salt"), 1, 64))
+	if result != "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783" {
+		t.Error(result, "is not the expected PBKDF2 result")
+		return
+	}
+
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Error("failed to hash password", err)
+		return
+	}
+
+	if !VerifyPassword(hash, "secret") || VerifyPassword(hash, "Secret") || VerifyPassword("secret", "secret") {
+		t.Error("unexpected password verification result for", hash)
+		return
+	}
+
+	another, _ := HashPassword("secret")
+	if another == hash {
+		t.Error("password hashes must be salted")
+	}
+}
+
 func TestAes256Decrypt(t *testing.T) {
 	s := "jnqPJ_spawkejMUW4FPizG4nqmL8OOjafPaMyDd6ge8"
 	data, err := base64.RawURLEncoding.DecodeString(s)
@@ -14,6 +14,12 @@ type Queryable interface {
 	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
 }
 
+// Executable a Queryable that could also execute statements, e.g. a DB or Tx
+type Executable interface {
+	Queryable
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
 // DataRow data row, which can be used to scan values or get column information
 type DataRow interface {
 	ColumnTypes() ([]*sql.ColumnType, error)
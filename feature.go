@@ -0,0 +1,126 @@
+package cypress
+
+import (
+	"hash/fnv"
+	"html/template"
+	"net/http"
+	"sync"
+)
+
+// FeatureFlag a feature that could be enabled for everyone, or for
+// the given users, roles or a percentage of users
+type FeatureFlag struct {
+	// Name name of the feature
+	Name string
+
+	// Enabled the feature is enabled for everyone
+	Enabled bool
+
+	// Users ids of the users that the feature is enabled for
+	Users []string
+
+	// Roles roles of the users that the feature is enabled for
+	Roles []string
+
+	// Percentage percentage of users, from 0 to 100, that the feature
+	// is enabled for, the users are selected by the hash of user id, or
+	// the session id for anonymous users, so the result is stable
+	Percentage int
+}
+
+// FeatureFlags a registry of feature flags that could be updated at runtime
+type FeatureFlags struct {
+	lock  *sync.RWMutex
+	flags map[string]*FeatureFlag
+}
+
+// NewFeatureFlags creates an empty feature flags registry
+func NewFeatureFlags() *FeatureFlags {
+	return &FeatureFlags{&sync.RWMutex{}, make(map[string]*FeatureFlag)}
+}
+
+// Set adds or replaces the feature flag
+func (flags *FeatureFlags) Set(flag *FeatureFlag) {
+	flags.lock.Lock()
+	defer flags.lock.Unlock()
+	flags.flags[flag.Name] = flag
+}
+
+// Remove removes the feature flag with the given name
+func (flags *FeatureFlags) Remove(name string) {
+	flags.lock.Lock()
+	defer flags.lock.Unlock()
+	delete(flags.flags, name)
+}
+
+// IsEnabled checks if the feature is enabled for the request, a feature that is
+// not registered is always disabled
+func (flags *FeatureFlags) IsEnabled(name string, request *http.Request) bool {
+	flags.lock.RLock()
+	flag, ok := flags.flags[name]
+	flags.lock.RUnlock()
+	if !ok {
+		return false
+	}
+
+	if flag.Enabled {
+		return true
+	}
+
+	key := ""
+	user := GetUser(request)
+	if user != nil {
+		for _, id := range flag.Users {
+			if id == user.ID {
+				return true
+			}
+		}
+
+		for _, role := range flag.Roles {
+			for _, userRole := range user.Roles {
+				if role == userRole {
+					return true
+				}
+			}
+		}
+
+		key = user.Domain + "\\" + user.ID
+	} else if session := GetSession(request); session != nil {
+		key = session.ID
+	}
+
+	if flag.Percentage <= 0 || key == "" {
+		return false
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(name + "$" + key))
+	return int(hash.Sum32()%100) < flag.Percentage
+}
+
+// templateFuncs the template functions that are bound to the request
+func (flags *FeatureFlags) templateFuncs(request *http.Request) template.FuncMap {
+	return template.FuncMap{
+		"feature": func(name string) bool {
+			return flags.IsEnabled(name, request)
+		},
+	}
+}
+
+// WithFeatureFlags sets the feature flags for the web server, the flags could be
+// queried by Response.IsFeatureEnabled in actions and by {{if feature "name"}} in
+// templates that are rendered by Response.DoneWithTemplate
+func (server *WebServer) WithFeatureFlags(flags *FeatureFlags) *WebServer {
+	server.features = flags
+	return server
+}
+
+// IsFeatureEnabled checks if the feature is enabled for the request, always returns
+// false if no feature flags are set to the web server
+func (r *Response) IsFeatureEnabled(name string) bool {
+	if r.features == nil || r.request == nil {
+		return false
+	}
+
+	return r.features.IsEnabled(name, r.request)
+}
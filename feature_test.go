@@ -0,0 +1,98 @@
+package cypress
+
+import (
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestFeatureFlags(t *testing.T) {
+	flags := NewFeatureFlags()
+	flags.Set(&FeatureFlag{Name: "all", Enabled: true})
+	flags.Set(&FeatureFlag{Name: "beta", Users: []string{"alice"}, Roles: []string{"tester"}})
+	flags.Set(&FeatureFlag{Name: "half", Percentage: 50})
+
+	request := func(user *UserPrincipal) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		ctx := extentContext(r.Context())
+		if user != nil {
+			ctx.withValue(UserPrincipalKey, user)
+		}
+
+		return r.WithContext(ctx)
+	}
+
+	anonymous := request(nil)
+	if !flags.IsEnabled("all", anonymous) || flags.IsEnabled("beta", anonymous) || flags.IsEnabled("missing", anonymous) {
+		t.Error("unexpected feature flags for anonymous user")
+		return
+	}
+
+	if !flags.IsEnabled("beta", request(&UserPrincipal{ID: "alice"})) || !flags.IsEnabled("beta", request(&UserPrincipal{ID: "bob", Roles: []string{"tester"}})) {
+		t.Error("beta must be enabled for alice and testers")
+		return
+	}
+
+	enabled := 0
+	for i := 0; i < 1000; i++ {
+		user := &UserPrincipal{ID: fmt.Sprint("user", i)}
+		if flags.IsEnabled("half", request(user)) {
+			enabled++
+		}
+
+		if flags.IsEnabled("half", request(user)) != flags.IsEnabled("half", request(user)) {
+			t.Error("percentage feature must be stable for the same user")
+			return
+		}
+	}
+
+	if enabled < 400 || enabled > 600 {
+		t.Error("expecting about half of the users to have the feature but got", enabled)
+		return
+	}
+
+	testDir, err := ioutil.TempDir("", "cyfeaturetest")
+	if err != nil {
+		t.Error("failed to create test dir", err)
+		return
+	}
+
+	defer os.RemoveAll(testDir)
+	err = ioutil.WriteFile(path.Join(testDir, "page.tmpl"), []byte(`{{define "page"}}{{if feature "beta"}}beta{{else}}stable{{end}}{{end}}`), os.ModePerm)
+	if err != nil {
+		t.Error("failed to setup page.tmpl")
+		return
+	}
+
+	tmplMgr := NewTemplateManager(testDir, ".tmpl", time.Minute, func(root *template.Template) {}, nil)
+	defer tmplMgr.Close()
+
+	// rendering without request bound functions must not execute the shared template
+	writer := httptest.NewRecorder()
+	(&Response{tmplMgr: tmplMgr, request: request(nil), writer: writer}).DoneWithTemplate(http.StatusOK, "page", nil)
+	if writer.Body.String() != "stable" {
+		t.Error("expecting stable but got", writer.Body.String())
+		return
+	}
+
+	for _, user := range []string{"alice", "bob", "alice"} {
+		writer := httptest.NewRecorder()
+		response := &Response{tmplMgr: tmplMgr, features: flags, request: request(&UserPrincipal{ID: user}), writer: writer}
+		response.DoneWithTemplate(http.StatusOK, "page", nil)
+		expected := "stable"
+		if user == "alice" {
+			expected = "beta"
+		}
+
+		if writer.Body.String() != expected || response.IsFeatureEnabled("beta") != (user == "alice") {
+			t.Error("expecting", expected, "but got", writer.Body.String())
+			return
+		}
+	}
+}
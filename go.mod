@@ -1,6 +1,6 @@
 module github.com/bytewayio/cypress
 
-go 1.12
+go 1.13
 
 require (
 	github.com/BurntSushi/toml v0.3.1 // indirect
@@ -8,7 +8,6 @@ require (
 	github.com/go-redis/redis v6.15.2+incompatible
 	github.com/gofrs/uuid v3.2.0+incompatible
 	github.com/golang-collections/collections v0.0.0-20130729185459-604e922904d3
-	github.com/gorilla/handlers v1.4.0
 	github.com/gorilla/mux v1.7.1
 	github.com/gorilla/websocket v1.4.0
 	github.com/mattn/go-sqlite3 v1.10.0
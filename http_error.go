@@ -0,0 +1,85 @@
+package cypress
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+var (
+	// InternalErrorMsg message to be shown when an action fails with an unknown error
+	InternalErrorMsg = "Sorry, something went wrong while processing your request"
+)
+
+// HTTPError an error that carries the http status code, the message and
+// optional details to be sent to the client
+type HTTPError struct {
+	StatusCode int         `json:"statusCode"`
+	Message    string      `json:"message"`
+	Details    interface{} `json:"details,omitempty"`
+}
+
+// NewHTTPError creates a HTTPError with the given status code, message and details
+func NewHTTPError(statusCode int, message string, details interface{}) *HTTPError {
+	return &HTTPError{statusCode, message, details}
+}
+
+// Error implements the error interface
+func (e *HTTPError) Error() string {
+	return http.StatusText(e.StatusCode) + ": " + e.Message
+}
+
+// ToHTTPError converts err to a HTTPError, sql.ErrNoRows and ErrSessionNotFound are
+// mapped to 404, while all other errors that are not HTTPError are mapped to 500, the
+// errors wrapped by err are also checked
+func ToHTTPError(err error) *HTTPError {
+	return toHTTPError(nil, err)
+}
+
+// toHTTPError converts err to a HTTPError as ToHTTPError does, the messages are
+// localized for the request if it's not nil
+func toHTTPError(request *http.Request, err error) *HTTPError {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr
+	}
+
+	if errors.Is(err, sql.ErrNoRows) || errors.Is(err, ErrSessionNotFound) {
+		return NewHTTPError(http.StatusNotFound, Localize(request, NotFoundMsgKey, NotFoundMsg), nil)
+	}
+
+	return NewHTTPError(http.StatusInternalServerError, InternalErrorMsg, nil)
+}
+
+// DoneWithHTTPError converts err to a HTTPError by ToHTTPError and sends it to the client
+// as json if the client accepts json, otherwise, an error page based on errorTemplate
+func (r *Response) DoneWithHTTPError(err error) {
+	r.doneWithHTTPError(err, false)
+}
+
+func (r *Response) doneWithHTTPError(err error, asJSON bool) {
+	httpErr := toHTTPError(r.request, err)
+	if httpErr.StatusCode >= http.StatusInternalServerError {
+		zap.L().Error("actionFailed", zap.Error(err), zap.Int("statusCode", httpErr.StatusCode), zap.String("activityId", r.traceID))
+	}
+
+	if asJSON || (r.request != nil && strings.Contains(r.request.Header.Get("Accept"), "application/json")) {
+		r.DoneWithJSON(httpErr.StatusCode, httpErr)
+		return
+	}
+
+	r.DoneWithError(httpErr.StatusCode, httpErr.Message)
+}
+
+func isNilValue(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Chan, reflect.Func:
+		return value.IsNil()
+	}
+
+	return false
+}
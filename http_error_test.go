@@ -0,0 +1,107 @@
+package cypress
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type TestErrorController struct{}
+
+func (c *TestErrorController) Missing(req *http.Request, resp *Response) error {
+	return sql.ErrNoRows
+}
+
+func (c *TestErrorController) Invalid(req *http.Request, resp *Response) error {
+	return NewHTTPError(http.StatusBadRequest, "invalid input", map[string]string{"name": "required"})
+}
+
+func (c *TestErrorController) Failed(req *http.Request, resp *Response) (*TestObj, error) {
+	return nil, errors.New("database is down")
+}
+
+func (c *TestErrorController) Item(req *http.Request, resp *Response) (*TestObj, error) {
+	return &TestObj{1, "abc"}, nil
+}
+
+func (c *TestErrorController) Ignored(req *http.Request, resp *Response) (*TestObj, int) {
+	return nil, 0
+}
+
+func TestErrorReturningActions(t *testing.T) {
+	actions := make(map[string]ActionHandler)
+	for _, action := range AsController(&TestErrorController{})() {
+		actions[action.Name] = action.Handler
+	}
+
+	if len(actions) != 4 {
+		t.Error("expecting 4 actions but got", len(actions))
+		return
+	}
+
+	serve := func(name, accept string) *httptest.ResponseRecorder {
+		writer := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "/web/test/"+name, nil)
+		if accept != "" {
+			request.Header.Set("Accept", accept)
+		}
+
+		actions[name](request, &Response{request: request, writer: writer})
+		return writer
+	}
+
+	if result := serve("missing", ""); result.Code != http.StatusNotFound {
+		t.Error("expecting 404 but got", result.Code)
+		return
+	}
+
+	result := serve("invalid", "application/json")
+	httpErr := &HTTPError{}
+	if err := json.Unmarshal(result.Body.Bytes(), httpErr); err != nil || result.Code != http.StatusBadRequest {
+		t.Error("expecting 400 with json but got", result.Code, result.Body.String())
+		return
+	}
+
+	if httpErr.Message != "invalid input" || httpErr.Details == nil {
+		t.Error("unexpected error content", result.Body.String())
+		return
+	}
+
+	if result = serve("failed", ""); result.Code != http.StatusInternalServerError || result.Header().Get("Content-Type") != "application/json; charset=UTF-8" {
+		t.Error("expecting 500 with json but got", result.Code, result.Header().Get("Content-Type"))
+		return
+	}
+
+	obj := &TestObj{}
+	result = serve("item", "")
+	if err := json.Unmarshal(result.Body.Bytes(), obj); err != nil || obj.Name != "abc" {
+		t.Error("unexpected result", result.Body.String())
+		return
+	}
+}
+
+func TestToHTTPErrorWithWrappedErrors(t *testing.T) {
+	if httpErr := ToHTTPError(fmt.Errorf("loading user: %w", sql.ErrNoRows)); httpErr.StatusCode != http.StatusNotFound || httpErr.Message != NotFoundMsg {
+		t.Error("expecting 404 for wrapped sql.ErrNoRows but got", httpErr.StatusCode, httpErr.Message)
+		return
+	}
+
+	if httpErr := ToHTTPError(fmt.Errorf("loading session: %w", ErrSessionNotFound)); httpErr.StatusCode != http.StatusNotFound {
+		t.Error("expecting 404 for wrapped ErrSessionNotFound but got", httpErr.StatusCode)
+		return
+	}
+
+	invalid := NewHTTPError(http.StatusBadRequest, "invalid input", nil)
+	if httpErr := ToHTTPError(fmt.Errorf("validating: %w", invalid)); httpErr != invalid {
+		t.Error("expecting the wrapped HTTPError but got", httpErr)
+		return
+	}
+
+	if httpErr := ToHTTPError(errors.New("database is down")); httpErr.StatusCode != http.StatusInternalServerError {
+		t.Error("expecting 500 but got", httpErr.StatusCode)
+	}
+}
@@ -0,0 +1,430 @@
+package cypress
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+var (
+	// LocaleQueryParam query parameter name for selecting the locale
+	LocaleQueryParam = "lang"
+
+	// LocaleCookieName cookie name for remembering the selected locale
+	LocaleCookieName = "_CYPRESS_LANG"
+
+	// LocaleSessionKey session key for the locale of the user
+	LocaleSessionKey = "cypress$locale"
+
+	// NotFoundMsgKey message key for NotFoundMsg
+	NotFoundMsgKey = "cypress.notFound"
+
+	// AccessDeniedMsgKey message key for the access denied message
+	AccessDeniedMsgKey = "cypress.accessDenied"
+
+	// MaintenanceMsgKey message key for MaintenanceMsg
+	MaintenanceMsgKey = "cypress.maintenance"
+
+	// TimeoutMsgKey message key for TimeoutMsg
+	TimeoutMsgKey = "cypress.timeout"
+)
+
+type i18nMessage struct {
+	text    string
+	plurals map[string]string
+}
+
+// I18n message bundles by locales, the messages are loaded from json files
+// named by locales, e.g. en-US.json, each file is an object whose keys are
+// message keys and values are either message texts or objects of plural
+// categories (zero, one, two, few, many, other) to message texts. Message texts
+// could have placeholders like {name}, which are replaced by the arguments.
+type I18n struct {
+	defaultLocale string
+	bundles       map[string]map[string]*i18nMessage
+	locales       []string
+}
+
+// NewI18n creates an I18n object without any message bundles
+func NewI18n(defaultLocale string) *I18n {
+	return &I18n{
+		defaultLocale: defaultLocale,
+		bundles:       make(map[string]map[string]*i18nMessage),
+		locales:       make([]string, 0, 4),
+	}
+}
+
+// LoadI18n creates an I18n object with the message bundles loaded from the json files in dir
+func LoadI18n(dir, defaultLocale string) (*I18n, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	i18n := NewI18n(defaultLocale)
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		locale := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		err = i18n.AddMessages(locale, data)
+		if err != nil {
+			zap.L().Error("failed to load message bundle", zap.Error(err), zap.String("file", file))
+			return nil, err
+		}
+	}
+
+	return i18n, nil
+}
+
+// AddMessages adds the messages in json to the bundle of the locale
+func (i18n *I18n) AddMessages(locale string, data []byte) error {
+	values := make(map[string]interface{})
+	err := json.Unmarshal(data, &values)
+	if err != nil {
+		return err
+	}
+
+	bundle, ok := i18n.bundles[locale]
+	if !ok {
+		bundle = make(map[string]*i18nMessage)
+		i18n.bundles[locale] = bundle
+		i18n.locales = append(i18n.locales, locale)
+	}
+
+	for key, value := range values {
+		switch v := value.(type) {
+		case string:
+			bundle[key] = &i18nMessage{text: v}
+		case map[string]interface{}:
+			message := &i18nMessage{plurals: make(map[string]string)}
+			for category, text := range v {
+				message.plurals[category] = fmt.Sprint(text)
+			}
+
+			message.text = message.plurals["other"]
+			bundle[key] = message
+		}
+	}
+
+	return nil
+}
+
+// Locales the locales that have message bundles
+func (i18n *I18n) Locales() []string {
+	return i18n.locales
+}
+
+// MatchLocale finds the locale that has a message bundle for the given locale, a locale
+// matches exactly or by the language, returns empty string if no one matches
+func (i18n *I18n) MatchLocale(locale string) string {
+	locale = strings.Replace(strings.TrimSpace(locale), "_", "-", -1)
+	if locale == "" {
+		return ""
+	}
+
+	for _, item := range i18n.locales {
+		if strings.EqualFold(item, locale) {
+			return item
+		}
+	}
+
+	language := strings.ToLower(strings.SplitN(locale, "-", 2)[0])
+	for _, item := range i18n.locales {
+		if strings.ToLower(strings.SplitN(item, "-", 2)[0]) == language {
+			return item
+		}
+	}
+
+	return ""
+}
+
+// ResolveLocale resolves the locale for the request from the query parameter, cookie,
+// session and Accept-Language header in order, returns the default locale if none of
+// them matches a message bundle
+func (i18n *I18n) ResolveLocale(request *http.Request) string {
+	if locale := i18n.MatchLocale(request.URL.Query().Get(LocaleQueryParam)); locale != "" {
+		return locale
+	}
+
+	if cookie, err := request.Cookie(LocaleCookieName); err == nil {
+		if locale := i18n.MatchLocale(cookie.Value); locale != "" {
+			return locale
+		}
+	}
+
+	if session := GetSession(request); session != nil {
+		if value, ok := session.GetValue(LocaleSessionKey); ok {
+			if locale := i18n.MatchLocale(fmt.Sprint(value)); locale != "" {
+				return locale
+			}
+		}
+	}
+
+	type weightedLocale struct {
+		locale string
+		weight float64
+	}
+
+	accepted := make([]weightedLocale, 0, 4)
+	for _, item := range strings.Split(request.Header.Get("Accept-Language"), ",") {
+		parts := strings.Split(item, ";")
+		weight := 1.0
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					weight = q
+				}
+			}
+		}
+
+		accepted = append(accepted, weightedLocale{parts[0], weight})
+	}
+
+	sort.SliceStable(accepted, func(i, j int) bool {
+		return accepted[i].weight > accepted[j].weight
+	})
+
+	for _, item := range accepted {
+		if locale := i18n.MatchLocale(item.locale); locale != "" {
+			return locale
+		}
+	}
+
+	return i18n.defaultLocale
+}
+
+// Translate translates the message with the key for the locale, args are name and value
+// pairs or a map[string]interface{} for the placeholders, the value of "count" is used
+// for selecting the plural form. The message is looked up in the bundle of the default
+// locale if it's not found for the locale, if still not found, the key is returned
+func (i18n *I18n) Translate(locale, key string, args ...interface{}) string {
+	message, ok := i18n.bundles[locale][key]
+	if !ok {
+		message, ok = i18n.bundles[i18n.defaultLocale][key]
+		locale = i18n.defaultLocale
+	}
+
+	if !ok {
+		return key
+	}
+
+	values := make(map[string]interface{})
+	if len(args) == 1 {
+		if m, ok := args[0].(map[string]interface{}); ok {
+			values = m
+		}
+	}
+
+	for i := 0; i+1 < len(args); i += 2 {
+		values[fmt.Sprint(args[i])] = args[i+1]
+	}
+
+	text := message.text
+	if count, ok := values["count"]; ok && message.plurals != nil {
+		if plural, ok := message.plurals[PluralCategory(locale, toInt(count))]; ok {
+			text = plural
+		}
+	}
+
+	for name, value := range values {
+		text = strings.Replace(text, "{"+name+"}", fmt.Sprint(value), -1)
+	}
+
+	return text
+}
+
+func (i18n *I18n) hasMessage(locale, key string) bool {
+	if _, ok := i18n.bundles[locale][key]; ok {
+		return true
+	}
+
+	_, ok := i18n.bundles[i18n.defaultLocale][key]
+	return ok
+}
+
+func toInt(value interface{}) int {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return int(v.Float())
+	case reflect.String:
+		n, _ := strconv.Atoi(v.String())
+		return n
+	}
+
+	return 0
+}
+
+// PluralCategory returns the CLDR plural category of count for the language of the locale
+func PluralCategory(locale string, count int) string {
+	n := count
+	if n < 0 {
+		n = -n
+	}
+
+	language := strings.ToLower(strings.SplitN(strings.Replace(locale, "_", "-", -1), "-", 2)[0])
+	switch language {
+	case "zh", "ja", "ko", "th", "vi", "id", "ms":
+		return "other"
+	case "fr":
+		if n == 0 || n == 1 {
+			return "one"
+		}
+	case "ru", "uk", "be", "sr", "hr", "bs":
+		if n%10 == 1 && n%100 != 11 {
+			return "one"
+		}
+
+		if n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14) {
+			return "few"
+		}
+
+		return "many"
+	case "pl":
+		if n == 1 {
+			return "one"
+		}
+
+		if n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14) {
+			return "few"
+		}
+
+		return "many"
+	case "cs", "sk":
+		if n == 1 {
+			return "one"
+		}
+
+		if n >= 2 && n <= 4 {
+			return "few"
+		}
+	case "ar":
+		switch {
+		case n == 0:
+			return "zero"
+		case n == 1:
+			return "one"
+		case n == 2:
+			return "two"
+		case n%100 >= 3 && n%100 <= 10:
+			return "few"
+		case n%100 >= 11:
+			return "many"
+		}
+	default:
+		if n == 1 {
+			return "one"
+		}
+	}
+
+	return "other"
+}
+
+// templateFuncs the template functions that are bound to the locale
+func (i18n *I18n) templateFuncs(locale string) template.FuncMap {
+	return template.FuncMap{
+		"T": func(key string, args ...interface{}) string {
+			return i18n.Translate(locale, key, args...)
+		},
+	}
+}
+
+// PipelineWith implements CustomHandler interface, resolves the locale for the request
+// and remembers the locale selected by query parameter in cookie
+func (i18n *I18n) PipelineWith(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		locale := i18n.ResolveLocale(request)
+		if request.URL.Query().Get(LocaleQueryParam) != "" {
+			http.SetCookie(writer, &http.Cookie{
+				Name:   LocaleCookieName,
+				Value:  locale,
+				MaxAge: 60 * 60 * 24 * 365,
+				Path:   "/",
+			})
+		}
+
+		if ctx, ok := getMultiValueCtx(request); ok {
+			ctx.withValue(LocaleKey, locale).withValue(I18nKey, i18n)
+		}
+
+		handler.ServeHTTP(writer, request)
+	})
+}
+
+// contextHandler makes the I18n object and the locale available to the handlers that
+// run before the custom handlers chain, e.g. the ip filter, the locale is resolved
+// without the session, which is resolved again by PipelineWith
+func (i18n *I18n) contextHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if ctx, ok := getMultiValueCtx(request); ok {
+			ctx.withValue(LocaleKey, i18n.ResolveLocale(request)).withValue(I18nKey, i18n)
+		}
+
+		handler.ServeHTTP(writer, request)
+	})
+}
+
+// WithI18n adds the I18n object to the custom handlers chain, so that the locale is
+// resolved for each request, the messages could be translated by T in actions and by
+// {{T "key" "name" value}} in templates rendered by Response.DoneWithTemplate. The
+// framework messages are localized for all the handlers, while the locale saved in
+// the session is only honored by the custom handlers added after the I18n object
+func (server *WebServer) WithI18n(i18n *I18n) *WebServer {
+	server.i18n = i18n
+	return server.WithCustomHandler(i18n)
+}
+
+// GetLocale gets the locale resolved for the request, returns empty string if
+// I18n is not enabled
+func GetLocale(request *http.Request) string {
+	value, _ := request.Context().Value(LocaleKey).(string)
+	return value
+}
+
+func getI18n(request *http.Request) *I18n {
+	value, _ := request.Context().Value(I18nKey).(*I18n)
+	return value
+}
+
+// T translates the message with the key for the locale of the request, see I18n.Translate,
+// returns the key if I18n is not enabled
+func T(request *http.Request, key string, args ...interface{}) string {
+	i18n := getI18n(request)
+	if i18n == nil {
+		return key
+	}
+
+	return i18n.Translate(GetLocale(request), key, args...)
+}
+
+// Localize translates the framework message with the key for the locale of the
+// request, returns the message if I18n is not enabled or the key is not found
+func Localize(request *http.Request, key, message string) string {
+	if request == nil {
+		return message
+	}
+
+	i18n := getI18n(request)
+	if i18n == nil || !i18n.hasMessage(GetLocale(request), key) {
+		return message
+	}
+
+	return i18n.Translate(GetLocale(request), key)
+}
@@ -0,0 +1,130 @@
+package cypress
+
+import (
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestI18n(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "cyi18ntest")
+	if err != nil {
+		t.Error("failed to create test dir", err)
+		return
+	}
+
+	defer os.RemoveAll(testDir)
+	files := map[string]string{
+		"en-US.json": `{"hello": "Hello, {name}!", "items": {"one": "{count} item", "other": "{count} items"}, "cypress.notFound": "Nothing here"}`,
+		"ru.json":    `{"items": {"one": "{count} файл", "few": "{count} файла", "many": "{count} файлов"}}`,
+		"zh-CN.json": `{"hello": "你好，{name}！"}`,
+	}
+
+	for name, content := range files {
+		err = ioutil.WriteFile(path.Join(testDir, name), []byte(content), os.ModePerm)
+		if err != nil {
+			t.Error("failed to setup", name)
+			return
+		}
+	}
+
+	i18n, err := LoadI18n(testDir, "en-US")
+	if err != nil {
+		t.Error("failed to load message bundles", err)
+		return
+	}
+
+	cases := []struct {
+		locale   string
+		key      string
+		args     []interface{}
+		expected string
+	}{
+		{"en-US", "hello", []interface{}{"name", "Alice"}, "Hello, Alice!"},
+		{"zh-CN", "hello", []interface{}{map[string]interface{}{"name": "Alice"}}, "你好，Alice！"},
+		{"en-US", "items", []interface{}{"count", 1}, "1 item"},
+		{"en-US", "items", []interface{}{"count", 2}, "2 items"},
+		{"ru", "items", []interface{}{"count", 21}, "21 файл"},
+		{"ru", "items", []interface{}{"count", 3}, "3 файла"},
+		{"ru", "items", []interface{}{"count", 11}, "11 файлов"},
+		{"zh-CN", "items", []interface{}{"count", 1}, "1 item"},
+		{"zh-CN", "missing", nil, "missing"},
+	}
+
+	for _, c := range cases {
+		if result := i18n.Translate(c.locale, c.key, c.args...); result != c.expected {
+			t.Error("expecting", c.expected, "for", c.locale, c.key, "but got", result)
+			return
+		}
+	}
+
+	if Localize(nil, NotFoundMsgKey, NotFoundMsg) != NotFoundMsg {
+		t.Error("expecting the message for nil request")
+		return
+	}
+
+	var locale, message, errMessage string
+	handler := i18n.PipelineWith(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		locale = GetLocale(request)
+		message = Localize(request, NotFoundMsgKey, NotFoundMsg)
+		errMessage = toHTTPError(request, ErrSessionNotFound).Message
+	}))
+
+	serve := func(url string, header http.Header) *httptest.ResponseRecorder {
+		writer := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, url, nil)
+		request.Header = header
+		handler.ServeHTTP(writer, request.WithContext(extentContext(request.Context())))
+		return writer
+	}
+
+	serve("/", http.Header{"Accept-Language": []string{"fr-FR, zh-TW;q=0.8, en;q=0.5"}})
+	if locale != "zh-CN" || message != "Nothing here" || errMessage != message {
+		t.Error("expecting zh-CN with message from default locale but got", locale, message, errMessage)
+		return
+	}
+
+	writer := serve("/?lang=ru", http.Header{"Accept-Language": []string{"zh-CN"}})
+	cookies := writer.Result().Cookies()
+	if locale != "ru" || len(cookies) != 1 || cookies[0].Value != "ru" {
+		t.Error("expecting ru to be selected and remembered but got", locale)
+		return
+	}
+
+	serve("/", http.Header{"Accept-Language": []string{"zh-CN"}, "Cookie": []string{LocaleCookieName + "=ru"}})
+	if locale != "ru" {
+		t.Error("expecting ru from cookie but got", locale)
+		return
+	}
+
+	serve("/", http.Header{})
+	if locale != "en-US" {
+		t.Error("expecting default locale but got", locale)
+		return
+	}
+
+	err = ioutil.WriteFile(path.Join(testDir, "page.tmpl"), []byte(`{{define "page"}}{{T "hello" "name" .}}{{end}}`), os.ModePerm)
+	if err != nil {
+		t.Error("failed to setup page.tmpl")
+		return
+	}
+
+	tmplMgr := NewTemplateManager(testDir, ".tmpl", time.Minute, func(root *template.Template) {}, nil)
+	defer tmplMgr.Close()
+	for _, item := range []string{"zh-CN", "en-US"} {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request = request.WithContext(extentContext(request.Context()).withValue(LocaleKey, item).withValue(I18nKey, i18n))
+		writer := httptest.NewRecorder()
+		response := &Response{tmplMgr: tmplMgr, request: request, writer: writer}
+		response.DoneWithTemplate(http.StatusOK, "page", "Bob")
+		if expected := i18n.Translate(item, "hello", "name", "Bob"); writer.Body.String() != expected {
+			t.Error("expecting", expected, "but got", writer.Body.String())
+			return
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package cypress
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// ServiceLifetime tells how long a service instance lives
+type ServiceLifetime int32
+
+const (
+	// ServiceSingleton one service instance is shared by all requests
+	ServiceSingleton ServiceLifetime = 1 + iota
+
+	// ServicePerRequest a new service instance is created for each request
+	ServicePerRequest
+)
+
+const (
+	serviceContextKeyPrefix = "cypress$service$"
+)
+
+var (
+	// ErrServiceNotFound no service could be found for an injected field
+	ErrServiceNotFound = errors.New("service not found")
+
+	// ErrServiceAmbiguous more than one service could be injected into a field without service name
+	ErrServiceAmbiguous = errors.New("more than one service matches")
+
+	// ErrServiceTypeMismatch the service with the given name cannot be assigned to the injected field
+	ErrServiceTypeMismatch = errors.New("service type mismatch")
+
+	// ErrBadControllerType the controller created by ControllerFactory is not a pointer to struct
+	ErrBadControllerType = errors.New("controller must be a pointer to struct")
+
+	sessionStoreType = reflect.TypeOf((*SessionStore)(nil)).Elem()
+)
+
+// ServiceFactory creates a service instance for the request
+type ServiceFactory func(request *http.Request) (interface{}, error)
+
+// ControllerFactory creates a new controller instance, which must be a
+// pointer to struct, the fields of the struct with "inject" tag will be
+// injected with the registered services, the tag value is the service name,
+// if the tag value is empty, the service is resolved by the field type
+type ControllerFactory func() interface{}
+
+type serviceEntry struct {
+	name        string
+	serviceType reflect.Type
+	lifetime    ServiceLifetime
+	factory     ServiceFactory
+	once        *sync.Once
+	instance    interface{}
+	err         error
+}
+
+type fieldInjection struct {
+	index   []int
+	service *serviceEntry
+}
+
+func (entry *serviceEntry) resolve(request *http.Request) (interface{}, error) {
+	if entry.lifetime == ServiceSingleton {
+		entry.once.Do(func() {
+			entry.instance, entry.err = entry.factory(request)
+		})
+
+		return entry.instance, entry.err
+	}
+
+	ctx, ok := getMultiValueCtx(request)
+	if !ok {
+		return entry.factory(request)
+	}
+
+	key := serviceContextKeyPrefix + entry.name
+	if instance := ctx.Value(key); instance != nil {
+		return instance, nil
+	}
+
+	instance, err := entry.factory(request)
+	if err == nil && instance != nil {
+		ctx.withValue(key, instance)
+	}
+
+	return instance, err
+}
+
+// RegisterService registers a singleton service with the given name, the service could
+// be injected into controllers that are registered by RegisterControllerFactory
+func (server *WebServer) RegisterService(name string, service interface{}) *WebServer {
+	return server.RegisterServiceFactory(name, reflect.TypeOf(service), ServiceSingleton, ServiceFactory(func(request *http.Request) (interface{}, error) {
+		return service, nil
+	}))
+}
+
+// RegisterServiceFactory registers a service of serviceType with the given name and lifetime,
+// the factory is called once for singleton services or once per request for per-request services,
+// the instance of a per-request service is shared by all the injections of the same request
+func (server *WebServer) RegisterServiceFactory(name string, serviceType reflect.Type, lifetime ServiceLifetime, factory ServiceFactory) *WebServer {
+	server.services[name] = &serviceEntry{
+		name:        name,
+		serviceType: serviceType,
+		lifetime:    lifetime,
+		factory:     factory,
+		once:        &sync.Once{},
+	}
+
+	return server
+}
+
+// registerBuiltinServices registers services provided by the web server, which are
+// "sessionStore", "session", "user", "templateManager" and "logger", the logger is a
+// *zap.Logger with the activity id of the request
+func (server *WebServer) registerBuiltinServices() {
+	server.RegisterServiceFactory("sessionStore", sessionStoreType, ServicePerRequest, ServiceFactory(func(request *http.Request) (interface{}, error) {
+		return server.sessionStore, nil
+	}))
+	server.RegisterServiceFactory("session", reflect.TypeOf(&Session{}), ServicePerRequest, ServiceFactory(func(request *http.Request) (interface{}, error) {
+		return GetSession(request), nil
+	}))
+	server.RegisterServiceFactory("user", reflect.TypeOf(&UserPrincipal{}), ServicePerRequest, ServiceFactory(func(request *http.Request) (interface{}, error) {
+		return GetUser(request), nil
+	}))
+	server.RegisterServiceFactory("templateManager", reflect.TypeOf(&TemplateManager{}), ServicePerRequest, ServiceFactory(func(request *http.Request) (interface{}, error) {
+		if server.skinManager == nil {
+			return nil, nil
+		}
+
+		tmplMgr, _ := server.skinManager.ApplySelector(request)
+		return tmplMgr, nil
+	}))
+	server.RegisterServiceFactory("logger", reflect.TypeOf(&zap.Logger{}), ServicePerRequest, ServiceFactory(func(request *http.Request) (interface{}, error) {
+		return zap.L().With(zap.String("activityId", GetTraceID(request.Context()))), nil
+	}))
+}
+
+func (server *WebServer) findService(name string, fieldType reflect.Type) (*serviceEntry, error) {
+	if name != "" {
+		entry, ok := server.services[name]
+		if !ok {
+			return nil, ErrServiceNotFound
+		}
+
+		if entry.serviceType == nil || !entry.serviceType.AssignableTo(fieldType) {
+			return nil, ErrServiceTypeMismatch
+		}
+
+		return entry, nil
+	}
+
+	var result *serviceEntry
+	for _, entry := range server.services {
+		if entry.serviceType != nil && entry.serviceType.AssignableTo(fieldType) {
+			if result != nil {
+				return nil, ErrServiceAmbiguous
+			}
+
+			result = entry
+		}
+	}
+
+	if result == nil {
+		return nil, ErrServiceNotFound
+	}
+
+	return result, nil
+}
+
+// RegisterControllerFactory registers a controller for the standard routing, a new controller
+// instance is created by the factory for each request and the registered services are injected
+// into the fields tagged with "inject", the actions are enumerated in the same way as AsController.
+// An error is returned if any of the injected fields cannot be resolved
+func (server *WebServer) RegisterControllerFactory(name string, factory ControllerFactory, options ...ControllerOption) error {
+	sample := factory()
+	t := reflect.TypeOf(sample)
+	if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		return ErrBadControllerType
+	}
+
+	injections := make([]*fieldInjection, 0, 4)
+	for i := 0; i < t.Elem().NumField(); i++ {
+		field := t.Elem().Field(i)
+		serviceName, ok := field.Tag.Lookup("inject")
+		if !ok {
+			continue
+		}
+
+		if field.PkgPath != "" {
+			zap.L().Error("unexported field cannot be injected", zap.String("controller", name), zap.String("field", field.Name))
+			return ErrServiceTypeMismatch
+		}
+
+		service, err := server.findService(serviceName, field.Type)
+		if err != nil {
+			zap.L().Error("failed to resolve service for field", zap.Error(err), zap.String("controller", name), zap.String("field", field.Name), zap.String("service", serviceName))
+			return err
+		}
+
+		injections = append(injections, &fieldInjection{field.Index, service})
+	}
+
+	actions := applyRequiredRoles(sample, controllerActions(t, func(request *http.Request, response *Response) (reflect.Value, error) {
+		c := reflect.ValueOf(factory())
+		for _, injection := range injections {
+			service, err := injection.service.resolve(request)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+
+			if service != nil {
+				c.Elem().FieldByIndex(injection.index).Set(reflect.ValueOf(service))
+			}
+		}
+
+		return c, nil
+	}))
+
+	return server.RegisterController(name, ControllerFunc(func() []Action { return actions }), options...)
+}
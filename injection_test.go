@@ -0,0 +1,91 @@
+package cypress
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type TestGreeter struct {
+	Greeting string
+}
+
+type TestRequestScope struct {
+	Path string
+}
+
+type TestInjectedController struct {
+	Greeter *TestGreeter      `inject:""`
+	Scope   *TestRequestScope `inject:"scope"`
+	Shared  *TestRequestScope `inject:"scope"`
+	Logger  *zap.Logger       `inject:"logger"`
+	Store   SessionStore      `inject:""`
+	calls   int
+}
+
+func (c *TestInjectedController) Greeting(req *http.Request, resp *Response) {
+	c.calls++
+	resp.DoneWithContent(http.StatusOK, "text/plain", []byte(fmt.Sprintf("%s %s %d", c.Greeter.Greeting, c.Scope.Path, c.calls)))
+}
+
+type TestBadInjectedController struct {
+	Greeter *TestGreeter `inject:"missing"`
+}
+
+func TestRegisterControllerFactory(t *testing.T) {
+	store := NewInMemorySessionStore()
+	defer store.Close()
+
+	server := NewWebServer(":8099", nil)
+	server.WithSessionOptions(store, time.Minute)
+	server.RegisterService("greeter", &TestGreeter{"hello"})
+	scopes := 0
+	server.RegisterServiceFactory("scope", reflect.TypeOf(&TestRequestScope{}), ServicePerRequest, ServiceFactory(func(request *http.Request) (interface{}, error) {
+		scopes++
+		return &TestRequestScope{request.URL.Path}, nil
+	}))
+
+	var lastController *TestInjectedController
+	err := server.RegisterControllerFactory("test", ControllerFactory(func() interface{} {
+		lastController = &TestInjectedController{}
+		return lastController
+	}))
+	if err != nil {
+		t.Error("failed to register controller factory", err)
+		return
+	}
+
+	for i := 0; i < 2; i++ {
+		writer := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "/web/test/greeting", nil)
+		request = request.WithContext(extentContext(request.Context()))
+		server.registeredActions["test"]["greeting"].Handler(request, &Response{request: request, writer: writer})
+		if writer.Body.String() != "hello /web/test/greeting 1" {
+			t.Error("unexpected response", writer.Body.String())
+			return
+		}
+
+		if lastController.Shared != lastController.Scope || scopes != i+1 {
+			t.Error("expecting one scope instance per request but got", scopes)
+			return
+		}
+	}
+
+	if lastController.Logger == nil || lastController.Store != store {
+		t.Error("built-in services are not injected")
+		return
+	}
+
+	err = server.RegisterControllerFactory("bad", ControllerFactory(func() interface{} {
+		return &TestBadInjectedController{}
+	}))
+	if err != ErrServiceNotFound {
+		t.Error("expecting ErrServiceNotFound but got", err)
+		return
+	}
+}
@@ -0,0 +1,181 @@
+package cypress
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+var (
+	// ForwardedForHeader http header name for the forwarding chain of client addresses
+	ForwardedForHeader = http.CanonicalHeaderKey("x-forwarded-for")
+
+	// RealIPHeader http header name for the client address set by a proxy
+	RealIPHeader = http.CanonicalHeaderKey("x-real-ip")
+
+	// ForwardedProtoHeader http header name for the scheme used by the client
+	ForwardedProtoHeader = http.CanonicalHeaderKey("x-forwarded-proto")
+
+	// ForwardedHostHeader http header name for the host requested by the client
+	ForwardedHostHeader = http.CanonicalHeaderKey("x-forwarded-host")
+)
+
+// IPRule allow and deny lists for the paths with the given prefix, a request
+// is denied if the client address is in Deny, or Allow is not empty and the
+// client address is not in Allow
+type IPRule struct {
+	Prefix string
+	Allow  []*net.IPNet
+	Deny   []*net.IPNet
+}
+
+type proxyHeadersHandler struct {
+	trustedProxies []*net.IPNet
+	pipeline       http.Handler
+}
+
+type ipFilterHandler struct {
+	rules    []*IPRule
+	pipeline http.Handler
+}
+
+// ParseCIDRs parses the CIDRs, a single IP address is treated as a CIDR with
+// only that address
+func ParseCIDRs(cidrs ...string) ([]*net.IPNet, error) {
+	result := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			if strings.Contains(cidr, ":") {
+				cidr = cidr + "/128"
+			} else {
+				cidr = cidr + "/32"
+			}
+		}
+
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, ipNet)
+	}
+
+	return result, nil
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetClientIP gets the client address of the request without port, the
+// address is resolved from the forwarding chain if the request is sent
+// through the trusted proxies
+func GetClientIP(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+
+	return host
+}
+
+// ServeHTTP serves incoming http request
+func (handler *proxyHeadersHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	ip := net.ParseIP(GetClientIP(request))
+	if containsIP(handler.trustedProxies, ip) {
+		// walk through the chain from the nearest proxy, the first address that
+		// is not a trusted proxy is the client address
+		chain := make([]string, 0, 4)
+		for _, value := range request.Header[ForwardedForHeader] {
+			chain = append(chain, strings.Split(value, ",")...)
+		}
+
+		if len(chain) == 0 && request.Header.Get(RealIPHeader) != "" {
+			chain = append(chain, request.Header.Get(RealIPHeader))
+		}
+
+		for i := len(chain) - 1; i >= 0; i-- {
+			forwardedIP := net.ParseIP(strings.TrimSpace(chain[i]))
+			if forwardedIP == nil {
+				break
+			}
+
+			ip = forwardedIP
+			if !containsIP(handler.trustedProxies, forwardedIP) {
+				break
+			}
+		}
+
+		request.RemoteAddr = ip.String()
+		if scheme := strings.ToLower(request.Header.Get(ForwardedProtoHeader)); scheme == "http" || scheme == "https" {
+			request.URL.Scheme = scheme
+		}
+
+		if host := request.Header.Get(ForwardedHostHeader); host != "" {
+			request.Host = host
+		}
+	}
+
+	handler.pipeline.ServeHTTP(writer, request)
+}
+
+// NewProxyHeadersHandler creates a handler that resolves the client address, scheme and
+// host from the forwarding headers only if the request is sent by one of the trusted proxies,
+// the headers from other clients are ignored, so that the client address cannot be spoofed
+func NewProxyHeadersHandler(pipeline http.Handler, trustedProxies []*net.IPNet) http.Handler {
+	return &proxyHeadersHandler{trustedProxies, pipeline}
+}
+
+// ServeHTTP serves incoming http request
+func (handler *ipFilterHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	var rule *IPRule
+	for _, item := range handler.rules {
+		if strings.HasPrefix(request.URL.Path, item.Prefix) && (rule == nil || len(item.Prefix) > len(rule.Prefix)) {
+			rule = item
+		}
+	}
+
+	if rule != nil {
+		ip := net.ParseIP(GetClientIP(request))
+		if containsIP(rule.Deny, ip) || (len(rule.Allow) > 0 && !containsIP(rule.Allow, ip)) {
+			zap.L().Warn("clientAddressDenied", zap.String("remoteAddr", request.RemoteAddr), zap.String("path", request.URL.Path), zap.String("activityId", GetTraceID(request.Context())))
+			SendError(writer, http.StatusForbidden, Localize(request, AccessDeniedMsgKey, "Access denied"))
+			return
+		}
+	}
+
+	handler.pipeline.ServeHTTP(writer, request)
+}
+
+// NewIPFilterHandler creates a handler that rejects the requests by the IPRule with the
+// longest matched prefix with a 403 status, the message is localized if the server is
+// set up by WithI18n
+func NewIPFilterHandler(pipeline http.Handler, rules []*IPRule) http.Handler {
+	return &ipFilterHandler{rules, pipeline}
+}
+
+// WithTrustedProxies sets the trusted proxies, the forwarding headers are only
+// respected for requests sent by these proxies
+func (server *WebServer) WithTrustedProxies(proxies []*net.IPNet) *WebServer {
+	server.trustedProxies = proxies
+	return server
+}
+
+// WithIPRule adds allow and deny lists for the paths with the given prefix, if more
+// than one prefix matches a request, the rule with the longest prefix is applied
+func (server *WebServer) WithIPRule(prefix string, allow, deny []*net.IPNet) *WebServer {
+	server.ipRules = append(server.ipRules, &IPRule{prefix, allow, deny})
+	return server
+}
@@ -0,0 +1,111 @@
+package cypress
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProxyHeadersHandler(t *testing.T) {
+	proxies, err := ParseCIDRs("10.0.0.0/8", "192.168.1.1")
+	if err != nil {
+		t.Error("failed to parse CIDRs", err)
+		return
+	}
+
+	var clientIP, scheme string
+	handler := NewProxyHeadersHandler(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		clientIP = GetClientIP(request)
+		scheme = request.URL.Scheme
+	}), proxies)
+
+	serve := func(remoteAddr string, header http.Header) {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.RemoteAddr = remoteAddr
+		request.Header = header
+		handler.ServeHTTP(httptest.NewRecorder(), request)
+	}
+
+	serve("1.2.3.4:5678", http.Header{ForwardedForHeader: []string{"5.6.7.8"}})
+	if clientIP != "1.2.3.4" {
+		t.Error("forwarding headers from untrusted clients must be ignored, but got", clientIP)
+		return
+	}
+
+	serve("10.0.0.1:5678", http.Header{ForwardedForHeader: []string{"6.6.6.6, 5.6.7.8, 192.168.1.1"}, ForwardedProtoHeader: []string{"https"}})
+	if clientIP != "5.6.7.8" || scheme != "https" {
+		t.Error("expecting 5.6.7.8 over https but got", clientIP, scheme)
+		return
+	}
+
+	serve("10.0.0.1:5678", http.Header{RealIPHeader: []string{"5.6.7.8"}})
+	if clientIP != "5.6.7.8" {
+		t.Error("expecting 5.6.7.8 but got", clientIP)
+		return
+	}
+
+	serve("10.0.0.1:5678", http.Header{ForwardedForHeader: []string{"10.0.0.2, 10.0.0.3"}})
+	if clientIP != "10.0.0.2" {
+		t.Error("expecting 10.0.0.2 but got", clientIP)
+		return
+	}
+}
+
+func TestIPFilterHandler(t *testing.T) {
+	office, _ := ParseCIDRs("192.168.0.0/16")
+	blocked, _ := ParseCIDRs("192.168.100.0/24", "6.6.6.6")
+	handler := NewIPFilterHandler(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusOK)
+	}), []*IPRule{
+		{"/", nil, blocked[1:]},
+		{"/admin/", office, blocked[:1]},
+	})
+
+	serve := func(remoteAddr, path string) int {
+		writer := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		request.RemoteAddr = remoteAddr
+		handler.ServeHTTP(writer, request)
+		return writer.Code
+	}
+
+	cases := []struct {
+		remoteAddr string
+		path       string
+		status     int
+	}{
+		{"1.2.3.4:80", "/web/home/index", http.StatusOK},
+		{"6.6.6.6:80", "/web/home/index", http.StatusForbidden},
+		{"1.2.3.4:80", "/admin/users", http.StatusForbidden},
+		{"192.168.1.10:80", "/admin/users", http.StatusOK},
+		{"192.168.100.10:80", "/admin/users", http.StatusForbidden},
+	}
+
+	for _, c := range cases {
+		if status := serve(c.remoteAddr, c.path); status != c.status {
+			t.Error("expecting", c.status, "for", c.remoteAddr, c.path, "but got", status)
+			return
+		}
+	}
+}
+
+func TestIPFilterMessageLocalized(t *testing.T) {
+	i18n := NewI18n("en-US")
+	if err := i18n.AddMessages("zh-CN", []byte(`{"cypress.accessDenied": "拒绝访问"}`)); err != nil {
+		t.Error("failed to add messages", err)
+		return
+	}
+
+	blocked, _ := ParseCIDRs("6.6.6.6")
+	server := NewWebServer(":8099", nil)
+	server.WithIPRule("/", nil, blocked).WithI18n(i18n)
+	writer := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/web/home/index", nil)
+	request.RemoteAddr = "6.6.6.6:80"
+	request.Header.Set("Accept-Language", "zh-CN")
+	server.pipeline(false).ServeHTTP(writer, request)
+	if writer.Code != http.StatusForbidden || !strings.Contains(writer.Body.String(), "拒绝访问") {
+		t.Error("expecting localized 403 but got", writer.Code, writer.Body.String())
+	}
+}
@@ -0,0 +1,327 @@
+package cypress
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// JSONRPCParseError invalid JSON was received by the server
+	JSONRPCParseError = -32700
+
+	// JSONRPCInvalidRequest the JSON sent is not a valid request object
+	JSONRPCInvalidRequest = -32600
+
+	// JSONRPCMethodNotFound the method does not exist / is not available
+	JSONRPCMethodNotFound = -32601
+
+	// JSONRPCInvalidParams invalid method parameters
+	JSONRPCInvalidParams = -32602
+
+	// JSONRPCInternalError internal JSON-RPC error
+	JSONRPCInternalError = -32603
+
+	// JSONRPCServerError the method returns an error, the data of the
+	// error object is the HTTPError converted from the error
+	JSONRPCServerError = -32000
+
+	// JSONRPCAccessDenied the user is not allowed to call the method
+	JSONRPCAccessDenied = -32001
+)
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	jsonNull    = json.RawMessage("null")
+)
+
+// JSONRPCError the error object of JSON-RPC 2.0
+type JSONRPCError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+type jsonRPCRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type jsonRPCProcedure struct {
+	receiver   reflect.Value
+	method     reflect.Method
+	paramsType reflect.Type
+}
+
+// JSONRPCServer a JSON-RPC 2.0 server that exposes controller methods as
+// "controller.method" procedures, it serves the calls over HTTP POST as a
+// http.Handler and over web socket as a WebSocketListener
+type JSONRPCServer struct {
+	path       string
+	security   *SecurityHandler
+	procedures map[string]*jsonRPCProcedure
+}
+
+// NewJSONRPCServer creates a JSON-RPC server, each call is authorized by the security handler
+// as a POST request to path + "/" + procedure, security could be nil to skip the authorization
+func NewJSONRPCServer(path string, security *SecurityHandler) *JSONRPCServer {
+	return &JSONRPCServer{
+		path:       strings.TrimSuffix(path, "/"),
+		security:   security,
+		procedures: make(map[string]*jsonRPCProcedure),
+	}
+}
+
+// AddJSONRPCEndpoint adds a JSON-RPC endpoint at the given path, the returned server could also
+// be added as a web socket endpoint by AddWsEndoint to serve the calls over web socket
+func (server *WebServer) AddJSONRPCEndpoint(path string) *JSONRPCServer {
+	rpc := NewJSONRPCServer(path, server.securityHandler)
+	server.router.Handle(path, rpc)
+	return rpc
+}
+
+// RegisterController registers all accessible member functions of c which has
+// context.Context as the first parameter, an optional params parameter, and returns
+// a result and an error as procedures with name "name.method"
+func (rpc *JSONRPCServer) RegisterController(name string, c interface{}) error {
+	t := reflect.TypeOf(c)
+	for i := 0; i < t.NumMethod(); i = i + 1 {
+		m := t.Method(i)
+		methodType := m.Func.Type()
+		if methodType.NumIn() < 2 || methodType.NumIn() > 3 || methodType.In(1) != contextType {
+			continue
+		}
+
+		if methodType.NumOut() != 2 || methodType.Out(1) != errorType {
+			continue
+		}
+
+		procedure := &jsonRPCProcedure{receiver: reflect.ValueOf(c), method: m}
+		if methodType.NumIn() == 3 {
+			procedure.paramsType = methodType.In(2)
+		}
+
+		procedureName := name + "." + strings.ToLower(m.Name[0:1]) + m.Name[1:]
+		if _, ok := rpc.procedures[procedureName]; ok {
+			return ErrDupActionName
+		}
+
+		rpc.procedures[procedureName] = procedure
+	}
+
+	return nil
+}
+
+// ServeHTTP implements the http.Handler interface
+func (rpc *JSONRPCServer) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		writer.Header().Set("Allow", http.MethodPost)
+		SendError(writer, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	data, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		zap.L().Error("failedToReadRequestBody", zap.Error(err), zap.String("activityId", GetTraceID(request.Context())))
+		SendError(writer, http.StatusBadRequest, "Bad request")
+		return
+	}
+
+	user := GetUser(request)
+	if user == nil && rpc.security != nil {
+		user = rpc.security.Authenticate(request)
+		if ctx, ok := getMultiValueCtx(request); ok && user != nil {
+			ctx.withValue(UserPrincipalKey, user)
+		}
+	}
+
+	result := rpc.handle(request.Context(), user, data)
+	if result == nil {
+		writer.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	writer.Write(result)
+}
+
+// OnConnect implements WebSocketListener
+func (rpc *JSONRPCServer) OnConnect(session *WebSocketSession) {
+}
+
+// OnTextMessage implements WebSocketListener, handles the message as a JSON-RPC call or batch
+func (rpc *JSONRPCServer) OnTextMessage(session *WebSocketSession, text string) {
+	result := rpc.handle(rpc.webSocketContext(session), session.User, []byte(text))
+	if result != nil {
+		if err := session.SendTextMessage(string(result)); err != nil {
+			zap.L().Error("failedToSendJSONRPCResponse", zap.Error(err))
+		}
+	}
+}
+
+// OnBinaryMessage implements WebSocketListener, handles the message as a JSON-RPC call or batch
+func (rpc *JSONRPCServer) OnBinaryMessage(session *WebSocketSession, data []byte) {
+	result := rpc.handle(rpc.webSocketContext(session), session.User, data)
+	if result != nil {
+		if err := session.SendBinaryMessage(result); err != nil {
+			zap.L().Error("failedToSendJSONRPCResponse", zap.Error(err))
+		}
+	}
+}
+
+// OnClose implements WebSocketListener
+func (rpc *JSONRPCServer) OnClose(session *WebSocketSession, reason int) {
+}
+
+func (rpc *JSONRPCServer) webSocketContext(session *WebSocketSession) context.Context {
+	ctx := extentContext(context.Background())
+	if session.User != nil {
+		ctx.withValue(UserPrincipalKey, session.User)
+	}
+
+	if session.Session != nil {
+		ctx.withValue(SessionKey, session.Session)
+	}
+
+	return ctx
+}
+
+// handle handles a call or a batch of calls, returns nil if there is nothing to response
+func (rpc *JSONRPCServer) handle(ctx context.Context, user *UserPrincipal, data []byte) []byte {
+	data = bytes.TrimSpace(data)
+	if !json.Valid(data) {
+		return marshalJSONRPCResponse(newJSONRPCErrorResponse(jsonNull, JSONRPCParseError, "Parse error"))
+	}
+
+	if len(data) == 0 || data[0] != '[' {
+		response := rpc.call(ctx, user, data)
+		if response == nil {
+			return nil
+		}
+
+		return marshalJSONRPCResponse(response)
+	}
+
+	var batch []json.RawMessage
+	if err := json.Unmarshal(data, &batch); err != nil || len(batch) == 0 {
+		return marshalJSONRPCResponse(newJSONRPCErrorResponse(jsonNull, JSONRPCInvalidRequest, "Invalid Request"))
+	}
+
+	responses := make([]map[string]interface{}, 0, len(batch))
+	for _, item := range batch {
+		if response := rpc.call(ctx, user, item); response != nil {
+			responses = append(responses, response)
+		}
+	}
+
+	if len(responses) == 0 {
+		return nil
+	}
+
+	return marshalJSONRPCResponse(responses)
+}
+
+// call executes a single call, returns nil for notifications
+func (rpc *JSONRPCServer) call(ctx context.Context, user *UserPrincipal, data []byte) (response map[string]interface{}) {
+	request := &jsonRPCRequest{}
+	if err := json.Unmarshal(data, request); err != nil || request.JSONRPC != "2.0" || request.Method == "" {
+		return newJSONRPCErrorResponse(jsonNull, JSONRPCInvalidRequest, "Invalid Request")
+	}
+
+	id := request.ID
+	if id == nil {
+		id = jsonNull
+	}
+
+	procedure, ok := rpc.procedures[request.Method]
+	if !ok {
+		return rpc.reply(request, newJSONRPCErrorResponse(id, JSONRPCMethodNotFound, "Method not found"))
+	}
+
+	if rpc.security != nil && !rpc.security.CheckAccess(user, http.MethodPost, rpc.path+"/"+request.Method) {
+		return rpc.reply(request, newJSONRPCErrorResponse(id, JSONRPCAccessDenied, "Access denied"))
+	}
+
+	args := []reflect.Value{procedure.receiver, reflect.ValueOf(ctx)}
+	if procedure.paramsType != nil {
+		paramsType := procedure.paramsType
+		isPtr := paramsType.Kind() == reflect.Ptr
+		if isPtr {
+			paramsType = paramsType.Elem()
+		}
+
+		params := reflect.New(paramsType)
+		if len(request.Params) > 0 {
+			if err := json.Unmarshal(request.Params, params.Interface()); err != nil {
+				return rpc.reply(request, newJSONRPCErrorResponse(id, JSONRPCInvalidParams, "Invalid params"))
+			}
+		}
+
+		if !isPtr {
+			params = params.Elem()
+		}
+
+		args = append(args, params)
+	}
+
+	defer func() {
+		if err := recover(); err != nil {
+			zap.L().Error(fmt.Sprint(err), zap.String("procedure", request.Method), zap.Stack("source"), zap.String("activityId", GetTraceID(ctx)))
+			response = rpc.reply(request, newJSONRPCErrorResponse(id, JSONRPCInternalError, "Internal error"))
+		}
+	}()
+
+	results := procedure.method.Func.Call(args)
+	if err, ok := results[1].Interface().(error); ok && err != nil {
+		httpErr := ToHTTPError(err)
+		if httpErr.StatusCode >= http.StatusInternalServerError {
+			zap.L().Error("procedureFailed", zap.Error(err), zap.String("procedure", request.Method), zap.String("activityId", GetTraceID(ctx)))
+		}
+
+		response = newJSONRPCErrorResponse(id, JSONRPCServerError, httpErr.Message)
+		response["error"].(*JSONRPCError).Data = httpErr
+		return rpc.reply(request, response)
+	}
+
+	return rpc.reply(request, map[string]interface{}{
+		"jsonrpc": "2.0",
+		"result":  results[0].Interface(),
+		"id":      id,
+	})
+}
+
+// reply returns nil for notifications, otherwise the response
+func (rpc *JSONRPCServer) reply(request *jsonRPCRequest, response map[string]interface{}) map[string]interface{} {
+	if request.ID == nil {
+		return nil
+	}
+
+	return response
+}
+
+func newJSONRPCErrorResponse(id json.RawMessage, code int, message string) map[string]interface{} {
+	return map[string]interface{}{
+		"jsonrpc": "2.0",
+		"error":   &JSONRPCError{Code: code, Message: message},
+		"id":      id,
+	}
+}
+
+func marshalJSONRPCResponse(response interface{}) []byte {
+	data, err := json.Marshal(response)
+	if err != nil {
+		zap.L().Error("failedToEncodeJSONRPCResponse", zap.Error(err))
+		data, _ = json.Marshal(newJSONRPCErrorResponse(jsonNull, JSONRPCInternalError, "Internal error"))
+	}
+
+	return data
+}
@@ -0,0 +1,120 @@
+package cypress
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type TestMathController struct{}
+
+type TestAddParams struct {
+	A int `json:"a"`
+	B int `json:"b"`
+}
+
+func (c *TestMathController) Add(ctx context.Context, params *TestAddParams) (int, error) {
+	return params.A + params.B, nil
+}
+
+func (c *TestMathController) Fail(ctx context.Context) (interface{}, error) {
+	return nil, errors.New("failed")
+}
+
+func (c *TestMathController) Secret(ctx context.Context) (string, error) {
+	return "secret", nil
+}
+
+type TestRPCAuthz struct{}
+
+func (a *TestRPCAuthz) CheckAccess(user *UserPrincipal, method, path string) bool {
+	return user.ID == "admin"
+}
+
+func (a *TestRPCAuthz) CheckAnonymousAccessible(method, path string) bool {
+	return !strings.HasSuffix(path, ".secret")
+}
+
+func TestJSONRPCServer(t *testing.T) {
+	security := NewSecurityHandler().WithAuthz(&TestRPCAuthz{}).AddUserProvider(&TestUserProvider{})
+	rpc := NewJSONRPCServer("/rpc", security)
+	if err := rpc.RegisterController("math", &TestMathController{}); err != nil {
+		t.Error("failed to register controller", err)
+		return
+	}
+
+	call := func(url, body string) (int, []map[string]interface{}) {
+		writer := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, url, strings.NewReader(body))
+		request = request.WithContext(extentContext(request.Context()))
+		rpc.ServeHTTP(writer, request)
+		results := make([]map[string]interface{}, 0)
+		if strings.HasPrefix(writer.Body.String(), "[") {
+			json.Unmarshal(writer.Body.Bytes(), &results)
+		} else if writer.Body.Len() > 0 {
+			result := make(map[string]interface{})
+			json.Unmarshal(writer.Body.Bytes(), &result)
+			results = append(results, result)
+		}
+
+		return writer.Code, results
+	}
+
+	errorCode := func(result map[string]interface{}) int {
+		if e, ok := result["error"].(map[string]interface{}); ok {
+			return int(e["code"].(float64))
+		}
+
+		return 0
+	}
+
+	_, results := call("/rpc", `{"jsonrpc":"2.0","method":"math.add","params":{"a":1,"b":2},"id":1}`)
+	if len(results) != 1 || results[0]["result"] != float64(3) || results[0]["id"] != float64(1) {
+		t.Error("unexpected result", results)
+		return
+	}
+
+	_, results = call("/rpc", `[
+		{"jsonrpc":"2.0","method":"math.add","params":{"a":1,"b":2},"id":"a"},
+		{"jsonrpc":"2.0","method":"math.add","params":{"a":1,"b":2}},
+		{"jsonrpc":"2.0","method":"math.missing","id":"b"},
+		{"jsonrpc":"2.0","method":"math.add","params":[1],"id":"c"},
+		{"jsonrpc":"2.0","method":"math.fail","id":"d"},
+		{"jsonrpc":"2.0","method":"math.secret","id":"e"},
+		1
+	]`)
+	if len(results) != 6 {
+		t.Error("expecting 6 responses but got", len(results))
+		return
+	}
+
+	expected := []int{0, JSONRPCMethodNotFound, JSONRPCInvalidParams, JSONRPCServerError, JSONRPCAccessDenied, JSONRPCInvalidRequest}
+	for i, code := range expected {
+		if errorCode(results[i]) != code {
+			t.Error("expecting error code", code, "but got", results[i])
+			return
+		}
+	}
+
+	_, results = call("/rpc?ticket=admin", `{"jsonrpc":"2.0","method":"math.secret","id":1}`)
+	if len(results) != 1 || results[0]["result"] != "secret" {
+		t.Error("expecting secret but got", results)
+		return
+	}
+
+	_, results = call("/rpc", `{"jsonrpc":"2.0","method"`)
+	if len(results) != 1 || errorCode(results[0]) != JSONRPCParseError {
+		t.Error("expecting parse error but got", results)
+		return
+	}
+
+	code, results := call("/rpc", `{"jsonrpc":"2.0","method":"math.add","params":{"a":1,"b":2}}`)
+	if code != http.StatusNoContent || len(results) != 0 {
+		t.Error("expecting no content for notification but got", code)
+		return
+	}
+}
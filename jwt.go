@@ -0,0 +1,446 @@
+package cypress
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// JWTAlgHS256 HMAC with SHA-256
+	JWTAlgHS256 = "HS256"
+
+	// JWTAlgRS256 RSASSA-PKCS1-v1_5 with SHA-256
+	JWTAlgRS256 = "RS256"
+
+	// JWTAlgES256 ECDSA with P-256 and SHA-256
+	JWTAlgES256 = "ES256"
+)
+
+var (
+	// ErrBadToken the token is malformed
+	ErrBadToken = errors.New("bad token")
+
+	// ErrBadSignature the signature of the token cannot be verified
+	ErrBadSignature = errors.New("bad token signature")
+
+	// ErrTokenExpired the token is expired
+	ErrTokenExpired = errors.New("token expired")
+
+	// ErrNoExpiration the token has no exp claim
+	ErrNoExpiration = errors.New("token has no expiration")
+
+	// ErrTokenNotYetValid the token is not valid yet
+	ErrTokenNotYetValid = errors.New("token not yet valid")
+
+	// ErrBadIssuer the token is not issued by the expected issuer
+	ErrBadIssuer = errors.New("bad token issuer")
+
+	// ErrBadAudience the token is not issued for the expected audience
+	ErrBadAudience = errors.New("bad token audience")
+
+	// ErrBadKey the key is not able to sign or verify tokens with the algorithm
+	ErrBadKey = errors.New("bad key for the algorithm")
+)
+
+// JWTAudience the audience claim, which could be a string or an array of strings
+type JWTAudience []string
+
+// UnmarshalJSON implements json.Unmarshaler
+func (audience *JWTAudience) UnmarshalJSON(data []byte) error {
+	var value string
+	if json.Unmarshal(data, &value) == nil {
+		*audience = JWTAudience{value}
+		return nil
+	}
+
+	values := make([]string, 0, 2)
+	err := json.Unmarshal(data, &values)
+	*audience = JWTAudience(values)
+	return err
+}
+
+// MarshalJSON implements json.Marshaler
+func (audience JWTAudience) MarshalJSON() ([]byte, error) {
+	if len(audience) == 1 {
+		return json.Marshal(audience[0])
+	}
+
+	return json.Marshal([]string(audience))
+}
+
+// JWTClaims the registered claims and the claims for UserPrincipal
+type JWTClaims struct {
+	Subject   string      `json:"sub,omitempty"`
+	Issuer    string      `json:"iss,omitempty"`
+	Audience  JWTAudience `json:"aud,omitempty"`
+	ExpiresAt int64       `json:"exp,omitempty"`
+	NotBefore int64       `json:"nbf,omitempty"`
+	IssuedAt  int64       `json:"iat,omitempty"`
+	ID        string      `json:"jti,omitempty"`
+	Nonce     string      `json:"nonce,omitempty"`
+	Domain    string      `json:"domain,omitempty"`
+	Name      string      `json:"name,omitempty"`
+	Roles     []string    `json:"roles,omitempty"`
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+}
+
+// JWTKey a key for signing or verifying tokens with an algorithm, the key is a []byte
+// for HS256, a *rsa.PrivateKey or *rsa.PublicKey for RS256 and a *ecdsa.PrivateKey or
+// *ecdsa.PublicKey for ES256, the public keys could only verify tokens
+type JWTKey struct {
+	ID        string
+	Algorithm string
+	Key       interface{}
+}
+
+// JWTKeySet the keys for verifying tokens, keys could be added and removed at runtime
+// for key rotation, the key is selected by the kid in the token header
+type JWTKeySet struct {
+	lock *sync.RWMutex
+	keys []*JWTKey
+}
+
+// JWTUserProvider a UserProvider that authenticates the requests by the bearer tokens
+type JWTUserProvider struct {
+	keys      *JWTKeySet
+	issuer    string
+	audience  string
+	clockSkew time.Duration
+	loader    UserLoader
+}
+
+// JWTIssuer mints tokens for users
+type JWTIssuer struct {
+	key      *JWTKey
+	issuer   string
+	audience JWTAudience
+	ttl      time.Duration
+}
+
+// NewJWTKeySet creates a key set with the keys
+func NewJWTKeySet(keys ...*JWTKey) *JWTKeySet {
+	return &JWTKeySet{&sync.RWMutex{}, keys}
+}
+
+// Add adds a key to the key set
+func (keySet *JWTKeySet) Add(key *JWTKey) {
+	keySet.lock.Lock()
+	defer keySet.lock.Unlock()
+	keySet.keys = append(keySet.keys, key)
+}
+
+// Remove removes the key with the id from the key set
+func (keySet *JWTKeySet) Remove(id string) {
+	keySet.lock.Lock()
+	defer keySet.lock.Unlock()
+	keys := make([]*JWTKey, 0, len(keySet.keys))
+	for _, key := range keySet.keys {
+		if key.ID != id {
+			keys = append(keys, key)
+		}
+	}
+
+	keySet.keys = keys
+}
+
+// candidates returns the keys that could verify the token with the header
+func (keySet *JWTKeySet) candidates(header *jwtHeader) []*JWTKey {
+	keySet.lock.RLock()
+	defer keySet.lock.RUnlock()
+	result := make([]*JWTKey, 0, 2)
+	for _, key := range keySet.keys {
+		if key.Algorithm == header.Algorithm && (header.KeyID == "" || key.ID == header.KeyID) {
+			result = append(result, key)
+		}
+	}
+
+	return result
+}
+
+func (key *JWTKey) sign(data []byte) ([]byte, error) {
+	switch key.Algorithm {
+	case JWTAlgHS256:
+		if secret, ok := key.Key.([]byte); ok {
+			return HmacSha256(secret, data), nil
+		}
+	case JWTAlgRS256:
+		if privateKey, ok := key.Key.(*rsa.PrivateKey); ok {
+			return rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, Sha256(data))
+		}
+	case JWTAlgES256:
+		if privateKey, ok := key.Key.(*ecdsa.PrivateKey); ok && privateKey.Curve == elliptic.P256() {
+			r, s, err := ecdsa.Sign(rand.Reader, privateKey, Sha256(data))
+			if err != nil {
+				return nil, err
+			}
+
+			signature := make([]byte, 64)
+			rBytes, sBytes := r.Bytes(), s.Bytes()
+			copy(signature[32-len(rBytes):32], rBytes)
+			copy(signature[64-len(sBytes):], sBytes)
+			return signature, nil
+		}
+	}
+
+	return nil, ErrBadKey
+}
+
+func (key *JWTKey) verify(data, signature []byte) bool {
+	switch key.Algorithm {
+	case JWTAlgHS256:
+		if secret, ok := key.Key.([]byte); ok {
+			return hmac.Equal(HmacSha256(secret, data), signature)
+		}
+	case JWTAlgRS256:
+		publicKey, ok := key.Key.(*rsa.PublicKey)
+		if privateKey, isPrivate := key.Key.(*rsa.PrivateKey); isPrivate {
+			publicKey, ok = &privateKey.PublicKey, true
+		}
+
+		if ok {
+			return rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, Sha256(data), signature) == nil
+		}
+	case JWTAlgES256:
+		publicKey, ok := key.Key.(*ecdsa.PublicKey)
+		if privateKey, isPrivate := key.Key.(*ecdsa.PrivateKey); isPrivate {
+			publicKey, ok = &privateKey.PublicKey, true
+		}
+
+		if ok && len(signature) == 64 {
+			r := new(big.Int).SetBytes(signature[:32])
+			s := new(big.Int).SetBytes(signature[32:])
+			return ecdsa.Verify(publicKey, Sha256(data), r, s)
+		}
+	}
+
+	return false
+}
+
+// SignJWT signs the claims with the key into a token
+func SignJWT(key *JWTKey, claims *JWTClaims) (string, error) {
+	header, err := json.Marshal(&jwtHeader{key.Algorithm, "JWT", key.ID})
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	data := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	signature, err := key.sign([]byte(data))
+	if err != nil {
+		return "", err
+	}
+
+	return data + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// ParseJWT verifies the signature of the token by the key set and returns the claims,
+// the claims are not validated
+func ParseJWT(keys *JWTKeySet, token string) (*JWTClaims, error) {
+	payload, err := verifyJWT(keys, token)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &JWTClaims{}
+	if json.Unmarshal(payload, claims) != nil {
+		return nil, ErrBadToken
+	}
+
+	return claims, nil
+}
+
+// verifyJWT verifies the signature of the token by the key set and returns the payload
+func verifyJWT(keys *JWTKeySet, token string) ([]byte, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrBadToken
+	}
+
+	headerData, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrBadToken
+	}
+
+	header := &jwtHeader{}
+	if json.Unmarshal(headerData, header) != nil {
+		return nil, ErrBadToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrBadToken
+	}
+
+	verified := false
+	data := []byte(parts[0] + "." + parts[1])
+	for _, key := range keys.candidates(header) {
+		if key.verify(data, signature) {
+			verified = true
+			break
+		}
+	}
+
+	if !verified {
+		return nil, ErrBadSignature
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrBadToken
+	}
+
+	return payload, nil
+}
+
+// Validate validates the time, issuer and audience of the claims, the issuer and audience
+// are not checked if they are empty, the time is compared with the clock skew allowed,
+// tokens without exp are rejected as they could never be expired
+func (claims *JWTClaims) Validate(issuer, audience string, clockSkew time.Duration) error {
+	now := time.Now()
+	if claims.ExpiresAt <= 0 {
+		return ErrNoExpiration
+	}
+
+	if now.Add(-clockSkew).After(time.Unix(claims.ExpiresAt, 0)) {
+		return ErrTokenExpired
+	}
+
+	if claims.NotBefore > 0 && now.Add(clockSkew).Before(time.Unix(claims.NotBefore, 0)) {
+		return ErrTokenNotYetValid
+	}
+
+	if issuer != "" && claims.Issuer != issuer {
+		return ErrBadIssuer
+	}
+
+	if audience != "" {
+		for _, item := range claims.Audience {
+			if item == audience {
+				return nil
+			}
+		}
+
+		return ErrBadAudience
+	}
+
+	return nil
+}
+
+// NewJWTUserProvider creates a user provider that accepts the tokens signed by the keys
+// in the key set and issued by the issuer for the audience, the issuer and audience are
+// not checked if they are empty
+func NewJWTUserProvider(keys *JWTKeySet, issuer, audience string) *JWTUserProvider {
+	return &JWTUserProvider{
+		keys:      keys,
+		issuer:    issuer,
+		audience:  audience,
+		clockSkew: time.Minute,
+	}
+}
+
+// WithClockSkew sets the clock skew allowed for validating exp and nbf, default to a minute
+func (provider *JWTUserProvider) WithClockSkew(clockSkew time.Duration) *JWTUserProvider {
+	provider.clockSkew = clockSkew
+	return provider
+}
+
+// WithLoader sets the UserLoader for loading users by Load
+func (provider *JWTUserProvider) WithLoader(loader UserLoader) *JWTUserProvider {
+	provider.loader = loader
+	return provider
+}
+
+// GetName implements UserProvider interface
+func (provider *JWTUserProvider) GetName() string {
+	return "jwt"
+}
+
+// Authenticate implements UserProvider interface, the UserPrincipal is built from the
+// claims, sub for ID, domain for Domain, name for Name and roles for Roles, while
+// the claims are set to Self
+func (provider *JWTUserProvider) Authenticate(request *http.Request) *UserPrincipal {
+	authorization := request.Header.Get("Authorization")
+	if len(authorization) < 7 || !strings.EqualFold(authorization[:7], "Bearer ") {
+		return nil
+	}
+
+	claims, err := ParseJWT(provider.keys, strings.TrimSpace(authorization[7:]))
+	if err == nil {
+		err = claims.Validate(provider.issuer, provider.audience, provider.clockSkew)
+	}
+
+	if err != nil {
+		zap.L().Warn("badBearerToken", zap.Error(err), zap.String("activityId", GetTraceID(request.Context())))
+		return nil
+	}
+
+	return &UserPrincipal{
+		ID:     claims.Subject,
+		Domain: claims.Domain,
+		Name:   claims.Name,
+		Roles:  claims.Roles,
+		Self:   claims,
+	}
+}
+
+// Load implements UserProvider interface, returns nil if no UserLoader is set
+func (provider *JWTUserProvider) Load(domain, id string) *UserPrincipal {
+	if provider.loader == nil {
+		return nil
+	}
+
+	return provider.loader(domain, id)
+}
+
+// NewJWTIssuer creates an issuer that signs tokens with the key, the tokens expire after ttl
+func NewJWTIssuer(key *JWTKey, issuer string, ttl time.Duration) *JWTIssuer {
+	return &JWTIssuer{key: key, issuer: issuer, ttl: ttl}
+}
+
+// WithAudience sets the audience of the tokens
+func (issuer *JWTIssuer) WithAudience(audience ...string) *JWTIssuer {
+	issuer.audience = JWTAudience(audience)
+	return issuer
+}
+
+// Issue mints a token for the user
+func (issuer *JWTIssuer) Issue(user *UserPrincipal) (string, error) {
+	now := time.Now()
+	claims := &JWTClaims{
+		Subject:   user.ID,
+		Issuer:    issuer.issuer,
+		Audience:  issuer.audience,
+		ExpiresAt: now.Add(issuer.ttl).Unix(),
+		NotBefore: now.Unix(),
+		IssuedAt:  now.Unix(),
+		ID:        NewSessionID(),
+		Domain:    user.Domain,
+		Name:      user.Name,
+		Roles:     user.Roles,
+	}
+
+	return SignJWT(issuer.key, claims)
+}
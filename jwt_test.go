@@ -0,0 +1,127 @@
+package cypress
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestJWTUserProvider(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Error("failed to generate rsa key", err)
+		return
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Error("failed to generate ec key", err)
+		return
+	}
+
+	hsKey := &JWTKey{ID: "hs1", Algorithm: JWTAlgHS256, Key: []byte("secret")}
+	rsKey := &JWTKey{ID: "rs1", Algorithm: JWTAlgRS256, Key: rsaKey}
+	esKey := &JWTKey{ID: "es1", Algorithm: JWTAlgES256, Key: ecKey}
+	keySet := NewJWTKeySet(
+		hsKey,
+		&JWTKey{ID: "rs1", Algorithm: JWTAlgRS256, Key: &rsaKey.PublicKey},
+		&JWTKey{ID: "es1", Algorithm: JWTAlgES256, Key: &ecKey.PublicKey})
+	provider := NewJWTUserProvider(keySet, "cypress", "app")
+	user := &UserPrincipal{ID: "1001", Domain: "test", Name: "Alice", Roles: []string{"admin"}}
+
+	authenticate := func(token string) *UserPrincipal {
+		request, _ := http.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("Authorization", "Bearer "+token)
+		return provider.Authenticate(request)
+	}
+
+	for _, key := range []*JWTKey{hsKey, rsKey, esKey} {
+		token, err := NewJWTIssuer(key, "cypress", time.Minute).WithAudience("app").Issue(user)
+		if err != nil {
+			t.Error("failed to issue token with", key.Algorithm, err)
+			return
+		}
+
+		result := authenticate(token)
+		if result == nil || result.ID != "1001" || result.Domain != "test" || result.Name != "Alice" || len(result.Roles) != 1 || result.Roles[0] != "admin" {
+			t.Error("unexpected user for", key.Algorithm, result)
+			return
+		}
+
+		if authenticate(token[:len(token)-2]+"AA") != nil {
+			t.Error("tampered token must be rejected for", key.Algorithm)
+			return
+		}
+	}
+
+	token, _ := NewJWTIssuer(hsKey, "cypress", time.Minute).WithAudience("other").Issue(user)
+	if authenticate(token) != nil {
+		t.Error("token for other audience must be rejected")
+		return
+	}
+
+	token, _ = NewJWTIssuer(hsKey, "evil", time.Minute).WithAudience("app").Issue(user)
+	if authenticate(token) != nil {
+		t.Error("token from other issuer must be rejected")
+		return
+	}
+
+	claims := &JWTClaims{Subject: "1001", Issuer: "cypress", Audience: JWTAudience{"app"}, ExpiresAt: time.Now().Add(-30 * time.Second).Unix()}
+	token, _ = SignJWT(hsKey, claims)
+	if authenticate(token) == nil {
+		t.Error("token expired within clock skew must be accepted")
+		return
+	}
+
+	claims.ExpiresAt = time.Now().Add(-2 * time.Minute).Unix()
+	token, _ = SignJWT(hsKey, claims)
+	if authenticate(token) != nil {
+		t.Error("expired token must be rejected")
+		return
+	}
+
+	claims.ExpiresAt = 0
+	token, _ = SignJWT(hsKey, claims)
+	if authenticate(token) != nil {
+		t.Error("token without expiration must be rejected")
+		return
+	}
+
+	claims.ExpiresAt = time.Now().Add(time.Hour).Unix()
+	claims.NotBefore = time.Now().Add(2 * time.Minute).Unix()
+	token, _ = SignJWT(hsKey, claims)
+	if _, err = ParseJWT(keySet, token); err != nil {
+		t.Error("failed to parse token", err)
+		return
+	}
+
+	if authenticate(token) != nil {
+		t.Error("token not yet valid must be rejected")
+		return
+	}
+
+	// rsa public key must not be used as hmac secret
+	token, _ = SignJWT(&JWTKey{ID: "rs1", Algorithm: JWTAlgHS256, Key: []byte("secret")}, &JWTClaims{Subject: "1001", Issuer: "cypress", Audience: JWTAudience{"app"}})
+	if _, err = ParseJWT(NewJWTKeySet(keySet.candidates(&jwtHeader{Algorithm: JWTAlgRS256, KeyID: "rs1"})...), token); err != ErrBadSignature {
+		t.Error("algorithm confusion must be rejected", err)
+		return
+	}
+
+	keySet.Remove("hs1")
+	token, _ = NewJWTIssuer(hsKey, "cypress", time.Minute).WithAudience("app").Issue(user)
+	if authenticate(token) != nil {
+		t.Error("token signed by removed key must be rejected")
+		return
+	}
+
+	keySet.Add(&JWTKey{ID: "hs2", Algorithm: JWTAlgHS256, Key: []byte("secret2")})
+	token, _ = NewJWTIssuer(&JWTKey{ID: "hs2", Algorithm: JWTAlgHS256, Key: []byte("secret2")}, "cypress", time.Minute).WithAudience("app").Issue(user)
+	if authenticate(token) == nil {
+		t.Error("token signed by rotated key must be accepted")
+		return
+	}
+}
@@ -83,7 +83,8 @@ func NewRollingLogWriter(fileName string, maxSizeInMegaBytes, maxRotationFiles i
 }
 
 // SetupLogger setup the global logger with specified log writer and log level
-// this must be called before the logging can actually work
+// this must be called before the logging can actually work, the writer is
+// guarded by a lock, so it does not need to be safe for concurrent use
 func SetupLogger(level LogLevel, writer io.Writer) {
 	logLevel := zap.DebugLevel
 	switch level {
@@ -98,7 +99,7 @@ func SetupLogger(level LogLevel, writer io.Writer) {
 	}
 
 	jsonEncoder := zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
-	writeSyncer := zapcore.AddSync(writer)
+	writeSyncer := zapcore.Lock(zapcore.AddSync(writer))
 	zapCore := zapcore.NewCore(jsonEncoder, writeSyncer, logLevel)
 	logger := zap.New(zapCore)
 	zap.ReplaceGlobals(logger)
@@ -116,6 +117,18 @@ func GetTraceID(ctx context.Context) string {
 	return ""
 }
 
+// GetCorrelationID get the correlation ID related to the context
+func GetCorrelationID(ctx context.Context) string {
+	value := ctx.Value(CorrelationIDKey)
+	if value != nil {
+		if correlationID, ok := value.(string); ok {
+			return correlationID
+		}
+	}
+
+	return ""
+}
+
 // LoggingHandler http incoming logging handler
 func LoggingHandler(handler http.Handler) http.Handler {
 	handlerFunction := func(writer http.ResponseWriter, request *http.Request) {
@@ -161,7 +174,7 @@ func LoggingHandler(handler http.Handler) http.Handler {
 			contentLength: 0,
 			writer:        writer,
 		}
-		newRequest := request.WithContext(extentContext(request.Context()).withValue(TraceActivityIDKey, activityID))
+		newRequest := request.WithContext(extentContext(request.Context()).withValue(TraceActivityIDKey, activityID).withValue(CorrelationIDKey, correlationID))
 		handler.ServeHTTP(tw, newRequest)
 
 		elapsed := time.Since(timeNow)
@@ -0,0 +1,311 @@
+package cypress
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// UserIDSessionKey session key for the id of the logged in user
+	UserIDSessionKey = "cypress$user$id"
+
+	// UserDomainSessionKey session key for the domain of the logged in user
+	UserDomainSessionKey = "cypress$user$domain"
+
+	loginCSRFSessionKey = "cypress$login$csrf"
+)
+
+var (
+	// ErrInvalidCredential the user name or password is incorrect
+	ErrInvalidCredential = errors.New("invalid credential")
+
+	// LoginUserNameField form field name for the user name
+	LoginUserNameField = "username"
+
+	// LoginPasswordField form field name for the password
+	LoginPasswordField = "password"
+
+	// LoginReturnURLField form field name for the URL to return after login
+	LoginReturnURLField = "returnUrl"
+
+	// LoginCSRFField form field name for the CSRF token of the login form, see LoginCSRFToken
+	LoginCSRFField = "csrfToken"
+
+	// LoginCSRFHeader http header name for the CSRF token of the login requests
+	LoginCSRFHeader = "X-CSRF-Token"
+)
+
+// CredentialVerifier verifies the user name and password
+type CredentialVerifier interface {
+	// Verify verifies the user name and password, returns the domain and id of the user,
+	// or ErrInvalidCredential if the credential is incorrect
+	Verify(userName, password string) (domain, id string, err error)
+}
+
+// CredentialVerifierFunc a function that implements CredentialVerifier
+type CredentialVerifierFunc func(userName, password string) (string, string, error)
+
+// Verify implements CredentialVerifier interface
+func (f CredentialVerifierFunc) Verify(userName, password string) (string, string, error) {
+	return f(userName, password)
+}
+
+// UserLoader loads the user by the specified domain and id, returns nil if the
+// user does not exist or is disabled
+type UserLoader func(domain, id string) *UserPrincipal
+
+// SessionUserProvider a UserProvider that resolves the user by the domain and id stored in
+// the session after the user logs in, the user is reloaded for each request, so that the
+// changes to the user, e.g. roles, take effect immediately
+type SessionUserProvider struct {
+	server     *WebServer
+	verifier   CredentialVerifier
+	loader     UserLoader
+	successURL string
+	failureURL string
+
+	rememberMeStore RememberMeStore
+	rememberMeTTL   time.Duration
+	throttle        *LoginThrottle
+}
+
+// AddFormLogin adds a SessionUserProvider to the web server and handles the form posts
+// to loginPath and logoutPath. The login form has username, password and an optional
+// returnUrl fields, the user is redirected to the returnUrl or the success URL after login,
+// or the failure URL if the credential is incorrect. Requests that accept application/json
+// get a json response instead of redirects. The login requests from other origins are
+// rejected, and the clients that have a session must send the token of LoginCSRFToken
+func (server *WebServer) AddFormLogin(loginPath, logoutPath string, verifier CredentialVerifier, loader UserLoader) *SessionUserProvider {
+	provider := &SessionUserProvider{
+		server:     server,
+		verifier:   verifier,
+		loader:     loader,
+		successURL: "/",
+		failureURL: loginPath + "?error=invalid_credential",
+	}
+
+	server.AddUserProvider(provider)
+	server.router.HandleFunc(loginPath, provider.handleLogin).Methods(http.MethodPost)
+	server.router.HandleFunc(logoutPath, provider.handleLogout).Methods(http.MethodPost)
+	return provider
+}
+
+// WithRedirects sets the URLs that the user is redirected to after login succeeds or fails
+func (provider *SessionUserProvider) WithRedirects(successURL, failureURL string) *SessionUserProvider {
+	provider.successURL = successURL
+	provider.failureURL = failureURL
+	return provider
+}
+
+// GetName implements UserProvider interface
+func (provider *SessionUserProvider) GetName() string {
+	return "session"
+}
+
+// Authenticate implements UserProvider interface
+func (provider *SessionUserProvider) Authenticate(request *http.Request) *UserPrincipal {
+	session := GetSession(request)
+	if session == nil {
+		return nil
+	}
+
+	id, ok := session.GetValue(UserIDSessionKey)
+	if !ok {
+		return nil
+	}
+
+	domain, _ := session.GetValue(UserDomainSessionKey)
+	domainValue, _ := domain.(string)
+	idValue, _ := id.(string)
+	return provider.Load(domainValue, idValue)
+}
+
+// Load implements UserProvider interface
+func (provider *SessionUserProvider) Load(domain, id string) *UserPrincipal {
+	return provider.loader(domain, id)
+}
+
+// Login verifies the credential and stores the user in a new session, the
+// old session is invalidated to prevent session fixation
+func (provider *SessionUserProvider) Login(writer http.ResponseWriter, request *http.Request, userName, password string) (*UserPrincipal, error) {
+	if provider.throttle != nil {
+		if err := provider.checkThrottle(request, userName); err != nil {
+			return nil, err
+		}
+	}
+
+	domain, id, err := provider.verifier.Verify(userName, password)
+	if err != nil {
+		if err == ErrInvalidCredential && provider.throttle != nil {
+			if throttleErr := provider.throttle.Fail(userName, GetClientIP(request)); throttleErr != nil {
+				zap.L().Error("failedToRecordLoginFailure", zap.Error(throttleErr), zap.String("activityId", GetTraceID(request.Context())))
+			}
+		}
+
+		return nil, err
+	}
+
+	if provider.throttle != nil {
+		if err = provider.throttle.Reset(userName); err != nil {
+			zap.L().Error("failedToResetLoginFailures", zap.Error(err), zap.String("activityId", GetTraceID(request.Context())))
+		}
+	}
+
+	user := provider.Load(domain, id)
+	if user == nil {
+		return nil, ErrInvalidCredential
+	}
+
+	user.Provider = provider.GetName()
+	session := provider.server.renewSession(writer, request)
+	session.SetValue(UserDomainSessionKey, domain)
+	session.SetValue(UserIDSessionKey, id)
+	err = provider.server.sessionStore.Save(session, provider.server.sessionTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if ctx, ok := getMultiValueCtx(request); ok {
+		ctx.withValue(UserPrincipalKey, user)
+	}
+
+	return user, nil
+}
+
+// Logout invalidates the session and the remember-me token of the request
+func (provider *SessionUserProvider) Logout(writer http.ResponseWriter, request *http.Request) {
+	provider.forget(writer, request)
+	if session := GetSession(request); session != nil {
+		session.IsValid = false
+		session.isDirty = true
+	}
+
+	http.SetCookie(writer, &http.Cookie{
+		Name:   sessionIDCookieKey,
+		Value:  "",
+		MaxAge: -1,
+		Path:   "/",
+	})
+}
+
+// LoginCSRFToken gets the CSRF token of the login form for the session of the request, which
+// is sent by the LoginCSRFField form field or the LoginCSRFHeader header, a new token is
+// created if the session does not have one
+func LoginCSRFToken(request *http.Request) string {
+	return sessionCSRFToken(request, loginCSRFSessionKey)
+}
+
+// checkLoginCSRF checks the login request is sent from the same origin and carries the
+// CSRF token if the client has a session, so that the user could not be logged in to
+// the account of others by a forged request
+func checkLoginCSRF(request *http.Request) bool {
+	if sent, same := checkSameOrigin(request); sent && !same {
+		return false
+	}
+
+	return !hasSessionCookie(request) || checkSessionCSRFToken(request, loginCSRFSessionKey, LoginCSRFHeader, LoginCSRFField)
+}
+
+func (provider *SessionUserProvider) handleLogin(writer http.ResponseWriter, request *http.Request) {
+	response := &Response{traceID: GetTraceID(request.Context()), request: request, writer: writer}
+	asJSON := strings.Contains(request.Header.Get("Accept"), "application/json")
+	if !checkLoginCSRF(request) {
+		zap.L().Warn("loginCSRFRejected", zap.String("origin", request.Header.Get("Origin")), zap.String("remoteAddr", request.RemoteAddr), zap.String("activityId", response.traceID))
+		SendError(writer, http.StatusForbidden, Localize(request, AccessDeniedMsgKey, "Access denied"))
+		return
+	}
+
+	userName := request.FormValue(LoginUserNameField)
+	user, err := provider.Login(writer, request, userName, request.FormValue(LoginPasswordField))
+	if err != nil {
+		zap.L().Warn("loginFailed", zap.String("userName", userName), zap.Error(err), zap.String("remoteAddr", request.RemoteAddr), zap.String("activityId", response.traceID))
+		if isThrottled(err) {
+			provider.sendThrottled(response, userName, err, asJSON)
+			return
+		}
+
+		if err != ErrInvalidCredential {
+			response.doneWithHTTPError(err, asJSON)
+			return
+		}
+
+		if asJSON {
+			response.DoneWithJSON(http.StatusUnauthorized, NewHTTPError(http.StatusUnauthorized, err.Error(), nil))
+			return
+		}
+
+		http.Redirect(writer, request, provider.failureURL, http.StatusSeeOther)
+		return
+	}
+
+	zap.L().Info("loggedIn", zap.String("user", user.ID), zap.String("domain", user.Domain), zap.String("activityId", response.traceID))
+	if provider.rememberMeStore != nil && request.FormValue(LoginRememberMeField) != "" {
+		if err = provider.Remember(writer, user); err != nil {
+			zap.L().Error("failedToIssueRememberMeToken", zap.Error(err), zap.String("activityId", response.traceID))
+		}
+	}
+
+	if asJSON {
+		response.DoneWithJSON(http.StatusOK, user)
+		return
+	}
+
+	http.Redirect(writer, request, safeReturnURL(request.FormValue(LoginReturnURLField), provider.successURL), http.StatusSeeOther)
+}
+
+func (provider *SessionUserProvider) handleLogout(writer http.ResponseWriter, request *http.Request) {
+	provider.Logout(writer, request)
+	if strings.Contains(request.Header.Get("Accept"), "application/json") {
+		writer.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	http.Redirect(writer, request, safeReturnURL(request.FormValue(LoginReturnURLField), "/"), http.StatusSeeOther)
+}
+
+// safeReturnURL returns the URL if it's a path on this site, otherwise the default URL,
+// to prevent open redirects
+func safeReturnURL(returnURL, defaultURL string) string {
+	if !strings.HasPrefix(returnURL, "/") || strings.HasPrefix(returnURL, "//") || strings.HasPrefix(returnURL, "/\\") {
+		return defaultURL
+	}
+
+	return returnURL
+}
+
+// renewSession invalidates the session of the request and replaces it with a new
+// session that has the same values
+func (server *WebServer) renewSession(writer http.ResponseWriter, request *http.Request) *Session {
+	session := NewSession(NewSessionID())
+	if old := GetSession(request); old != nil {
+		func() {
+			old.lock.RLock()
+			defer old.lock.RUnlock()
+			for key, value := range old.data {
+				session.data[key] = value
+			}
+		}()
+
+		old.IsValid = false
+		if err := server.sessionStore.Save(old, server.sessionTimeout); err != nil {
+			zap.L().Error("failedToInvalidateSession", zap.Error(err), zap.String("activityId", GetTraceID(request.Context())))
+		}
+	}
+
+	http.SetCookie(writer, &http.Cookie{
+		Name:   sessionIDCookieKey,
+		Value:  session.ID,
+		MaxAge: 60 * 60 * 24, // for one day
+		Path:   "/",
+	})
+
+	if ctx, ok := getMultiValueCtx(request); ok {
+		ctx.withValue(SessionKey, session)
+	}
+
+	return session
+}
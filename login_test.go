@@ -0,0 +1,153 @@
+package cypress
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestFormLogin(t *testing.T) {
+	sessionStore := NewInMemorySessionStore()
+	defer sessionStore.Close()
+
+	server := NewWebServer(":8099", nil)
+	server.WithSessionOptions(sessionStore, time.Minute)
+	provider := server.AddFormLogin("/login", "/logout", CredentialVerifierFunc(func(userName, password string) (string, string, error) {
+		if userName == "alice" && password == "secret" {
+			return "test", "1001", nil
+		}
+
+		return "", "", ErrInvalidCredential
+	}), func(domain, id string) *UserPrincipal {
+		return &UserPrincipal{ID: id, Domain: domain, Name: "Alice", Roles: []string{"user"}}
+	}).WithRedirects("/home", "/login?failed")
+
+	server.HandleFunc("/me", func(writer http.ResponseWriter, request *http.Request) {
+		if user := provider.Authenticate(request); user != nil {
+			writer.Write([]byte(user.Domain + "/" + user.ID))
+		}
+	})
+
+	server.HandleFunc("/login", func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write([]byte(LoginCSRFToken(request)))
+	})
+	server.HandleFunc("/api/login", func(writer http.ResponseWriter, request *http.Request) {
+		if _, err := provider.Login(writer, request, "alice", "secret"); err == nil {
+			GetSession(request).SetValue("cart", "1")
+		}
+	})
+	server.HandleFunc("/cart", func(writer http.ResponseWriter, request *http.Request) {
+		if value, ok := GetSession(request).GetValue("cart"); ok {
+			writer.Write([]byte(value.(string)))
+		}
+	})
+
+	front := httptest.NewServer(server.pipeline(true))
+	defer front.Close()
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar, CheckRedirect: func(request *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	me := func() string {
+		resp, err := client.Get(front.URL + "/me")
+		if err != nil {
+			return ""
+		}
+
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	csrfToken := func() string {
+		resp, err := client.Get(front.URL + "/login")
+		if err != nil {
+			return ""
+		}
+
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	post := func(path string, values url.Values) *http.Response {
+		if path == "/login" && values.Get(LoginCSRFField) == "" {
+			values.Set(LoginCSRFField, csrfToken())
+		}
+
+		resp, err := client.PostForm(front.URL+path, values)
+		if err != nil {
+			return nil
+		}
+
+		resp.Body.Close()
+		return resp
+	}
+
+	me()
+	frontURL, _ := url.Parse(front.URL)
+	sessionID := jar.Cookies(frontURL)[0].Value
+	resp := post("/login", url.Values{"username": []string{"alice"}, "password": []string{"secret"}, LoginCSRFField: []string{"forged"}})
+	if resp == nil || resp.StatusCode != http.StatusForbidden || me() != "" {
+		t.Error("expecting login without the csrf token to be rejected")
+		return
+	}
+
+	resp = post("/login", url.Values{"username": []string{"alice"}, "password": []string{"wrong"}})
+	if resp == nil || resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "/login?failed" || me() != "" {
+		t.Error("expecting to be redirected to failure URL")
+		return
+	}
+
+	resp = post("/login", url.Values{"username": []string{"alice"}, "password": []string{"secret"}, "returnUrl": []string{"//evil.com"}})
+	if resp == nil || resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "/home" {
+		t.Error("expecting to be redirected to success URL")
+		return
+	}
+
+	if me() != "test/1001" {
+		t.Error("expecting the user to be logged in")
+		return
+	}
+
+	if jar.Cookies(frontURL)[0].Value == sessionID {
+		t.Error("session id must be changed after login")
+		return
+	}
+
+	if _, err := sessionStore.Get(sessionID); err != ErrSessionNotFound {
+		t.Error("the session before login must be invalidated")
+		return
+	}
+
+	sessionID = jar.Cookies(frontURL)[0].Value
+	resp = post("/logout", url.Values{"returnUrl": []string{"/bye"}})
+	if resp == nil || resp.Header.Get("Location") != "/bye" || me() != "" {
+		t.Error("expecting the user to be logged out")
+		return
+	}
+
+	if _, err := sessionStore.Get(sessionID); err != ErrSessionNotFound {
+		t.Error("the session must be invalidated after logout")
+		return
+	}
+
+	client.Get(front.URL + "/api/login")
+	resp, err := client.Get(front.URL + "/cart")
+	if err != nil {
+		t.Error("failed to get the cart", err)
+		return
+	}
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "1" || me() != "test/1001" {
+		t.Error("expecting the values set after login to be saved but got", string(body))
+	}
+}
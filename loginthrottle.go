@@ -0,0 +1,384 @@
+package cypress
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"go.uber.org/zap"
+)
+
+const (
+	redisLoginAttemptKeyPrefix = "cypress$login$"
+)
+
+var (
+	// ErrTooManyAttempts the login is rejected as there are too many failed attempts recently
+	ErrTooManyAttempts = errors.New("too many login attempts")
+
+	// ErrAccountLocked the account is temporarily locked out due to too many failed attempts
+	ErrAccountLocked = errors.New("account locked")
+
+	// ErrCaptchaRequired a correct captcha is required to login
+	ErrCaptchaRequired = errors.New("captcha required")
+
+	// LoginCaptchaField form field name for the captcha answer
+	LoginCaptchaField = "captcha"
+)
+
+// LoginAttempts the failed login attempts of a key
+type LoginAttempts struct {
+	Failures    int
+	LastFailure time.Time
+}
+
+// LoginAttemptStore a store that tracks the failed login attempts by keys
+type LoginAttemptStore interface {
+	// Get gets the failed attempts of the key, returns zero attempts if there is none
+	Get(key string) (*LoginAttempts, error)
+
+	// Fail records a failed attempt for the key, the attempts expire after ttl since
+	// the last failure, returns the updated attempts
+	Fail(key string, ttl time.Duration) (*LoginAttempts, error)
+
+	// Reset clears the failed attempts of the key
+	Reset(key string) error
+}
+
+// LoginCheck the result of LoginThrottle.Check
+type LoginCheck struct {
+	Locked          bool
+	RetryAfter      time.Duration
+	CaptchaRequired bool
+}
+
+// LoginThrottle tracks the failed login attempts by account and by client IP, each key
+// gets free attempts, after that each failure doubles the delay before the next attempt
+// is allowed, a captcha is required after captchaAfter failures, and the key is locked
+// out for lockoutDuration after lockoutAfter failures
+type LoginThrottle struct {
+	store           LoginAttemptStore
+	freeAttempts    int
+	baseDelay       time.Duration
+	maxDelay        time.Duration
+	captchaAfter    int
+	lockoutAfter    int
+	lockoutDuration time.Duration
+	normalize       func(account string) string
+}
+
+type inMemoryLoginAttemptStore struct {
+	lock     *sync.Mutex
+	attempts map[string]*LoginAttempts
+	expires  map[string]time.Time
+}
+
+type redisLoginAttemptStore struct {
+	redisDb *redis.Client
+}
+
+// NewInMemoryLoginAttemptStore creates a login attempt store in memory
+func NewInMemoryLoginAttemptStore() LoginAttemptStore {
+	return &inMemoryLoginAttemptStore{&sync.Mutex{}, make(map[string]*LoginAttempts), make(map[string]time.Time)}
+}
+
+// sweep removes the expired attempts, the caller must hold the lock
+func (store *inMemoryLoginAttemptStore) sweep(now time.Time) {
+	for key, expiry := range store.expires {
+		if now.After(expiry) {
+			delete(store.attempts, key)
+			delete(store.expires, key)
+		}
+	}
+}
+
+// Get implements LoginAttemptStore
+func (store *inMemoryLoginAttemptStore) Get(key string) (*LoginAttempts, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.sweep(time.Now())
+	if attempts, ok := store.attempts[key]; ok {
+		copied := *attempts
+		return &copied, nil
+	}
+
+	return &LoginAttempts{}, nil
+}
+
+// Fail implements LoginAttemptStore
+func (store *inMemoryLoginAttemptStore) Fail(key string, ttl time.Duration) (*LoginAttempts, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	now := time.Now()
+	store.sweep(now)
+	attempts, ok := store.attempts[key]
+	if !ok {
+		attempts = &LoginAttempts{}
+		store.attempts[key] = attempts
+	}
+
+	attempts.Failures++
+	attempts.LastFailure = now
+	store.expires[key] = now.Add(ttl)
+	copied := *attempts
+	return &copied, nil
+}
+
+// Reset implements LoginAttemptStore
+func (store *inMemoryLoginAttemptStore) Reset(key string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	delete(store.attempts, key)
+	delete(store.expires, key)
+	return nil
+}
+
+// NewRedisLoginAttemptStore creates a redis based login attempt store, which could be
+// shared by multiple servers
+func NewRedisLoginAttemptStore(cli *redis.Client) LoginAttemptStore {
+	return &redisLoginAttemptStore{cli}
+}
+
+// Get implements LoginAttemptStore
+func (store *redisLoginAttemptStore) Get(key string) (*LoginAttempts, error) {
+	values, err := store.redisDb.HGetAll(redisLoginAttemptKeyPrefix + key).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	attempts := &LoginAttempts{}
+	attempts.Failures, _ = strconv.Atoi(values["failures"])
+	if last, err := strconv.ParseInt(values["last"], 10, 64); err == nil {
+		attempts.LastFailure = time.Unix(0, last)
+	}
+
+	return attempts, nil
+}
+
+// Fail implements LoginAttemptStore
+func (store *redisLoginAttemptStore) Fail(key string, ttl time.Duration) (*LoginAttempts, error) {
+	now := time.Now()
+	pipeline := store.redisDb.TxPipeline()
+	failures := pipeline.HIncrBy(redisLoginAttemptKeyPrefix+key, "failures", 1)
+	pipeline.HSet(redisLoginAttemptKeyPrefix+key, "last", now.UnixNano())
+	pipeline.Expire(redisLoginAttemptKeyPrefix+key, ttl)
+	if _, err := pipeline.Exec(); err != nil {
+		return nil, err
+	}
+
+	return &LoginAttempts{int(failures.Val()), now}, nil
+}
+
+// Reset implements LoginAttemptStore
+func (store *redisLoginAttemptStore) Reset(key string) error {
+	return store.redisDb.Del(redisLoginAttemptKeyPrefix + key).Err()
+}
+
+// NewLoginThrottle creates a LoginThrottle with the store, by default, there are three
+// free attempts, the delay starts from one second up to five minutes, a captcha is
+// required after three failures, and the key is locked out for fifteen minutes after
+// ten failures
+func NewLoginThrottle(store LoginAttemptStore) *LoginThrottle {
+	return &LoginThrottle{
+		store:           store,
+		freeAttempts:    3,
+		baseDelay:       time.Second,
+		maxDelay:        5 * time.Minute,
+		captchaAfter:    3,
+		lockoutAfter:    10,
+		lockoutDuration: 15 * time.Minute,
+		normalize:       normalizeLoginAccount,
+	}
+}
+
+// WithBackoff sets the free attempts and the range of the exponential backoff delay
+func (throttle *LoginThrottle) WithBackoff(freeAttempts int, baseDelay, maxDelay time.Duration) *LoginThrottle {
+	throttle.freeAttempts = freeAttempts
+	throttle.baseDelay = baseDelay
+	throttle.maxDelay = maxDelay
+	return throttle
+}
+
+// WithCaptchaAfter sets the failures after which a captcha is required, zero to disable
+func (throttle *LoginThrottle) WithCaptchaAfter(failures int) *LoginThrottle {
+	throttle.captchaAfter = failures
+	return throttle
+}
+
+// WithLockout sets the failures after which the key is locked out for the duration, zero to disable
+func (throttle *LoginThrottle) WithLockout(failures int, duration time.Duration) *LoginThrottle {
+	throttle.lockoutAfter = failures
+	throttle.lockoutDuration = duration
+	return throttle
+}
+
+// WithAccountNormalizer sets the function that normalizes the account names the same way
+// as the CredentialVerifier does, so that the variants of an account share the same
+// failures, by default, the account names are trimmed and converted to lower case
+func (throttle *LoginThrottle) WithAccountNormalizer(normalize func(account string) string) *LoginThrottle {
+	throttle.normalize = normalize
+	return throttle
+}
+
+func normalizeLoginAccount(account string) string {
+	return strings.ToLower(strings.TrimSpace(account))
+}
+
+func (throttle *LoginThrottle) loginAttemptKeys(account, ip string) []string {
+	return []string{"account$" + throttle.normalize(account), "ip$" + ip}
+}
+
+// evaluate evaluates the attempts of a key
+func (throttle *LoginThrottle) evaluate(attempts *LoginAttempts, now time.Time) *LoginCheck {
+	check := &LoginCheck{}
+	if attempts.Failures == 0 {
+		return check
+	}
+
+	if throttle.lockoutAfter > 0 && attempts.Failures >= throttle.lockoutAfter {
+		if wait := attempts.LastFailure.Add(throttle.lockoutDuration).Sub(now); wait > 0 {
+			check.Locked = true
+			check.RetryAfter = wait
+			return check
+		}
+	}
+
+	if excess := attempts.Failures - throttle.freeAttempts; excess > 0 {
+		delay := throttle.maxDelay
+		if excess <= 30 && throttle.baseDelay<<uint(excess-1) < throttle.maxDelay {
+			delay = throttle.baseDelay << uint(excess-1)
+		}
+
+		if wait := attempts.LastFailure.Add(delay).Sub(now); wait > 0 {
+			check.RetryAfter = wait
+		}
+	}
+
+	check.CaptchaRequired = throttle.captchaAfter > 0 && attempts.Failures >= throttle.captchaAfter
+	return check
+}
+
+// Check checks if the login for the account from the client IP is allowed, the most
+// restrictive result of the account and the client IP is returned
+func (throttle *LoginThrottle) Check(account, ip string) (*LoginCheck, error) {
+	result := &LoginCheck{}
+	now := time.Now()
+	for _, key := range throttle.loginAttemptKeys(account, ip) {
+		attempts, err := throttle.store.Get(key)
+		if err != nil {
+			return nil, err
+		}
+
+		check := throttle.evaluate(attempts, now)
+		result.Locked = result.Locked || check.Locked
+		result.CaptchaRequired = result.CaptchaRequired || check.CaptchaRequired
+		if check.RetryAfter > result.RetryAfter {
+			result.RetryAfter = check.RetryAfter
+		}
+	}
+
+	return result, nil
+}
+
+// Fail records a failed login for the account from the client IP
+func (throttle *LoginThrottle) Fail(account, ip string) error {
+	ttl := throttle.maxDelay
+	if throttle.lockoutDuration > ttl {
+		ttl = throttle.lockoutDuration
+	}
+
+	ttl *= 2
+	for _, key := range throttle.loginAttemptKeys(account, ip) {
+		attempts, err := throttle.store.Fail(key, ttl)
+		if err != nil {
+			return err
+		}
+
+		if throttle.lockoutAfter > 0 && attempts.Failures == throttle.lockoutAfter {
+			zap.L().Warn("loginLockedOut", zap.String("key", key), zap.String("account", account), zap.String("clientIp", ip), zap.Int("failures", attempts.Failures), zap.Duration("duration", throttle.lockoutDuration))
+		}
+	}
+
+	return nil
+}
+
+// Reset clears the failed logins of the account after a successful login, the failures
+// of the client IP are kept, so that a valid account could not be used to reset them
+func (throttle *LoginThrottle) Reset(account string) error {
+	return throttle.store.Reset(throttle.loginAttemptKeys(account, "")[0])
+}
+
+// WithLoginThrottle protects the login form by the throttle, when a captcha is required,
+// the login form must have the captcha field answered with the captcha generated by the
+// WithCaptcha path
+func (provider *SessionUserProvider) WithLoginThrottle(throttle *LoginThrottle) *SessionUserProvider {
+	provider.throttle = throttle
+	return provider
+}
+
+// checkThrottle checks the throttle before verifying the credential
+func (provider *SessionUserProvider) checkThrottle(request *http.Request, userName string) error {
+	check, err := provider.throttle.Check(userName, GetClientIP(request))
+	if err != nil {
+		return err
+	}
+
+	if check.Locked {
+		return ErrAccountLocked
+	}
+
+	if check.RetryAfter > 0 {
+		return ErrTooManyAttempts
+	}
+
+	if check.CaptchaRequired && !VerifyCaptcha(request, request.FormValue(LoginCaptchaField)) {
+		return ErrCaptchaRequired
+	}
+
+	return nil
+}
+
+// isThrottled checks if the error is returned by the throttle
+func isThrottled(err error) bool {
+	return err == ErrAccountLocked || err == ErrTooManyAttempts || err == ErrCaptchaRequired
+}
+
+// sendThrottled sends the response for a login rejected by the throttle
+func (provider *SessionUserProvider) sendThrottled(response *Response, userName string, err error, asJSON bool) {
+	check, checkErr := provider.throttle.Check(userName, GetClientIP(response.request))
+	if checkErr == nil && check.RetryAfter > 0 {
+		setRetryAfter(response.writer, check.RetryAfter)
+	}
+
+	code := "captcha_required"
+	if err == ErrAccountLocked {
+		code = "account_locked"
+	} else if err == ErrTooManyAttempts {
+		code = "too_many_attempts"
+	}
+
+	if asJSON {
+		status := http.StatusTooManyRequests
+		if err == ErrCaptchaRequired {
+			status = http.StatusUnauthorized
+		}
+
+		response.DoneWithJSON(status, NewHTTPError(status, err.Error(), map[string]string{"error": code}))
+		return
+	}
+
+	failureURL := provider.failureURL
+	if u, parseErr := url.Parse(failureURL); parseErr == nil {
+		query := u.Query()
+		query.Set("error", code)
+		u.RawQuery = query.Encode()
+		failureURL = u.String()
+	}
+
+	http.Redirect(response.writer, response.request, failureURL, http.StatusSeeOther)
+}
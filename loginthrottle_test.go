@@ -0,0 +1,177 @@
+package cypress
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestLoginThrottle(t *testing.T) {
+	throttle := NewLoginThrottle(NewInMemoryLoginAttemptStore()).
+		WithBackoff(2, 50*time.Millisecond, 100*time.Millisecond).
+		WithCaptchaAfter(3).
+		WithLockout(5, 200*time.Millisecond)
+
+	check := func(account, ip string) *LoginCheck {
+		result, err := throttle.Check(account, ip)
+		if err != nil {
+			return nil
+		}
+
+		return result
+	}
+
+	for i := 0; i < 2; i++ {
+		throttle.Fail("alice", "10.0.0.1")
+	}
+
+	if result := check("alice", "10.0.0.1"); result == nil || result.RetryAfter > 0 || result.CaptchaRequired {
+		t.Error("expecting free attempts without delay", result)
+		return
+	}
+
+	throttle.Fail(" Alice", "10.0.0.1")
+	result := check("ALICE ", "10.0.0.2")
+	if result == nil || result.RetryAfter <= 0 || result.RetryAfter > 50*time.Millisecond || !result.CaptchaRequired || result.Locked {
+		t.Error("expecting backoff and captcha by account", result)
+		return
+	}
+
+	if result = check("bob", "10.0.0.1"); result == nil || result.RetryAfter <= 0 || !result.CaptchaRequired {
+		t.Error("expecting backoff and captcha by client ip", result)
+		return
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if result = check("alice", "10.0.0.1"); result == nil || result.RetryAfter > 0 {
+		t.Error("expecting backoff to be over", result)
+		return
+	}
+
+	throttle.Fail("alice", "10.0.0.1")
+	if result = check("alice", "10.0.0.1"); result == nil || result.RetryAfter <= 50*time.Millisecond {
+		t.Error("expecting backoff to be doubled", result)
+		return
+	}
+
+	throttle.Fail("alice", "10.0.0.1")
+	if result = check("alice", "10.0.0.3"); result == nil || !result.Locked {
+		t.Error("expecting account to be locked out", result)
+		return
+	}
+
+	time.Sleep(210 * time.Millisecond)
+	if result = check("alice", "10.0.0.3"); result == nil || result.Locked || result.RetryAfter > 0 || !result.CaptchaRequired {
+		t.Error("expecting lockout to be over but captcha is still required", result)
+		return
+	}
+
+	throttle.Reset("alice")
+	if result = check("alice", "10.0.0.3"); result == nil || result.CaptchaRequired {
+		t.Error("expecting account failures to be reset", result)
+		return
+	}
+
+	if result = check("bob", "10.0.0.1"); result == nil || !result.CaptchaRequired {
+		t.Error("expecting client ip failures to be kept", result)
+	}
+}
+
+func TestFormLoginThrottle(t *testing.T) {
+	sessionStore := NewInMemorySessionStore()
+	defer sessionStore.Close()
+
+	server := NewWebServer(":8099", nil)
+	server.WithSessionOptions(sessionStore, time.Minute)
+	server.AddFormLogin("/login", "/logout", CredentialVerifierFunc(func(userName, password string) (string, string, error) {
+		if userName == "alice" && password == "secret" {
+			return "test", "1001", nil
+		}
+
+		return "", "", ErrInvalidCredential
+	}), func(domain, id string) *UserPrincipal {
+		return &UserPrincipal{ID: id, Domain: domain}
+	}).WithRedirects("/home", "/login?failed").
+		WithLoginThrottle(NewLoginThrottle(NewInMemoryLoginAttemptStore()).WithBackoff(1, time.Millisecond, time.Millisecond).WithCaptchaAfter(2))
+
+	server.HandleFunc("/captcha", func(writer http.ResponseWriter, request *http.Request) {
+		GetSession(request).SetValue(CaptchaKey, "123456")
+	})
+	server.HandleFunc("/token", func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write([]byte(LoginCSRFToken(request)))
+	})
+
+	front := httptest.NewServer(server.pipeline(true))
+	defer front.Close()
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar, CheckRedirect: func(request *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	resp, err := client.Get(front.URL + "/token")
+	if err != nil {
+		t.Error("failed to get the csrf token", err)
+		return
+	}
+
+	token, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	csrfToken := string(token)
+	login := func(password, captcha string) string {
+		resp, err := client.PostForm(front.URL+"/login", url.Values{"username": []string{"alice"}, "password": []string{password}, "captcha": []string{captcha}, LoginCSRFField: []string{csrfToken}})
+		if err != nil {
+			return ""
+		}
+
+		resp.Body.Close()
+		return resp.Header.Get("Location")
+	}
+
+	login("wrong", "")
+	login("wrong", "")
+	time.Sleep(5 * time.Millisecond)
+	if location := login("secret", ""); location != "/login?error=captcha_required&failed=" {
+		t.Error("expecting captcha to be required", location)
+		return
+	}
+
+	client.Get(front.URL + "/captcha")
+	if location := login("secret", "654321"); location != "/login?error=captcha_required&failed=" {
+		t.Error("expecting wrong captcha to be rejected", location)
+		return
+	}
+
+	client.Get(front.URL + "/captcha")
+	if location := login("secret", "123456"); location != "/home" {
+		t.Error("expecting login with captcha to succeed", location)
+		return
+	}
+
+	server.AddFormLogin("/api/login", "/api/logout", CredentialVerifierFunc(func(userName, password string) (string, string, error) {
+		return "", "", ErrInvalidCredential
+	}), func(domain, id string) *UserPrincipal {
+		return nil
+	}).WithLoginThrottle(NewLoginThrottle(NewInMemoryLoginAttemptStore()).WithLockout(1, time.Minute))
+
+	for i := 0; i < 2; i++ {
+		request, _ := http.NewRequest(http.MethodPost, front.URL+"/api/login?username=bob&password=x", nil)
+		request.Header.Set("Accept", "application/json")
+		request.Header.Set(LoginCSRFHeader, csrfToken)
+		resp, err := client.Do(request)
+		if err != nil {
+			t.Error("failed to login", err)
+			return
+		}
+
+		resp.Body.Close()
+		if i == 1 && (resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "") {
+			t.Error("expecting account to be locked out", resp.StatusCode)
+			return
+		}
+	}
+}
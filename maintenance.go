@@ -0,0 +1,172 @@
+package cypress
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaintenanceMode maintenance mode of the site or a path
+type MaintenanceMode int32
+
+const (
+	// MaintenanceOff the requests are served as usual
+	MaintenanceOff MaintenanceMode = iota
+
+	// MaintenanceReadOnly only GET, HEAD and OPTIONS requests are served
+	MaintenanceReadOnly
+
+	// MaintenanceFull no request is served
+	MaintenanceFull
+)
+
+var (
+	// MaintenanceMsg message to be shown when the requested resource is under maintenance
+	MaintenanceMsg = "Sorry, the service is under maintenance, please try again later"
+)
+
+// MaintenanceModel the model for maintenance page template
+type MaintenanceModel struct {
+	Message    string
+	RetryAfter int
+}
+
+// MaintenanceHandler a CustomHandler that rejects requests to the site or paths that
+// are under maintenance with a 503 status and a Retry-After header, while users with
+// the admin roles are always let through. The modes could be changed at runtime
+type MaintenanceHandler struct {
+	lock         *sync.RWMutex
+	mode         MaintenanceMode
+	paths        map[string]MaintenanceMode
+	retryAfter   time.Duration
+	templateName string
+	adminRoles   []string
+	server       *WebServer
+}
+
+// AddMaintenanceHandler adds a maintenance handler to the custom handlers chain and returns
+// it for changing the maintenance modes at runtime, the maintenance is off initially. The
+// maintenance message is localized whenever WithI18n is used, call WithI18n before this to
+// honor the locale saved in the session as well
+func (server *WebServer) AddMaintenanceHandler(retryAfter time.Duration, adminRoles ...string) *MaintenanceHandler {
+	handler := &MaintenanceHandler{
+		lock:       &sync.RWMutex{},
+		mode:       MaintenanceOff,
+		paths:      make(map[string]MaintenanceMode),
+		retryAfter: retryAfter,
+		adminRoles: adminRoles,
+		server:     server,
+	}
+
+	server.WithCustomHandler(handler)
+	return handler
+}
+
+// WithTemplate renders the template with the given name from the skin selected for the
+// request with a MaintenanceModel when a request is rejected, instead of the error page
+func (handler *MaintenanceHandler) WithTemplate(name string) *MaintenanceHandler {
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
+	handler.templateName = name
+	return handler
+}
+
+// SetMode sets the maintenance mode for the whole site
+func (handler *MaintenanceHandler) SetMode(mode MaintenanceMode) {
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
+	handler.mode = mode
+}
+
+// SetPathMode sets the maintenance mode for the paths with the given prefix
+func (handler *MaintenanceHandler) SetPathMode(prefix string, mode MaintenanceMode) {
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
+	if mode == MaintenanceOff {
+		delete(handler.paths, prefix)
+	} else {
+		handler.paths[prefix] = mode
+	}
+}
+
+// SetControllerMode sets the maintenance mode for the controller registered for
+// the standard routing
+func (handler *MaintenanceHandler) SetControllerMode(controller string, mode MaintenanceMode) {
+	handler.SetPathMode(handler.server.routingPrefix+"/"+controller+"/", mode)
+}
+
+// GetMode gets the effective maintenance mode for the path, which is the stricter
+// one of the site's mode and the mode of the longest matched prefix
+func (handler *MaintenanceHandler) GetMode(path string) MaintenanceMode {
+	handler.lock.RLock()
+	defer handler.lock.RUnlock()
+	mode := handler.mode
+	matched := ""
+	pathMode := MaintenanceOff
+	for prefix, value := range handler.paths {
+		if strings.HasPrefix(path, prefix) && len(prefix) > len(matched) {
+			matched = prefix
+			pathMode = value
+		}
+	}
+
+	if pathMode > mode {
+		return pathMode
+	}
+
+	return mode
+}
+
+// PipelineWith implements CustomHandler interface
+func (handler *MaintenanceHandler) PipelineWith(pipeline http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		mode := handler.GetMode(request.URL.Path)
+		if mode == MaintenanceOff ||
+			(mode == MaintenanceReadOnly && (request.Method == http.MethodGet || request.Method == http.MethodHead || request.Method == http.MethodOptions)) ||
+			handler.isAdmin(request) {
+			pipeline.ServeHTTP(writer, request)
+			return
+		}
+
+		handler.reject(writer, request)
+	})
+}
+
+func (handler *MaintenanceHandler) isAdmin(request *http.Request) bool {
+	if len(handler.adminRoles) == 0 {
+		return false
+	}
+
+	user := handler.server.securityHandler.resolveUser(request)
+	return user != nil && handler.server.hasAnyRole(user, handler.adminRoles)
+}
+
+func (handler *MaintenanceHandler) reject(writer http.ResponseWriter, request *http.Request) {
+	retryAfter := setRetryAfter(writer, handler.retryAfter)
+	message := Localize(request, MaintenanceMsgKey, MaintenanceMsg)
+	response := &Response{
+		traceID:  GetTraceID(request.Context()),
+		features: handler.server.features,
+		request:  request,
+		writer:   writer,
+	}
+
+	if strings.Contains(request.Header.Get("Accept"), "application/json") {
+		response.DoneWithJSON(http.StatusServiceUnavailable, NewHTTPError(http.StatusServiceUnavailable, message, nil))
+		return
+	}
+
+	handler.lock.RLock()
+	templateName := handler.templateName
+	handler.lock.RUnlock()
+	if templateName != "" && handler.server.skinManager != nil {
+		response.tmplMgr, response.skin = handler.server.skinManager.ApplySelector(request)
+		if response.tmplMgr != nil {
+			response.DoneWithTemplate(http.StatusServiceUnavailable, templateName, &MaintenanceModel{message, retryAfter})
+			return
+		}
+	}
+
+	SendError(writer, http.StatusServiceUnavailable, message)
+}
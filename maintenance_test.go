@@ -0,0 +1,145 @@
+package cypress
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type TestRoleUserProvider struct{}
+
+func (p *TestRoleUserProvider) GetName() string {
+	return "testRoleProvider"
+}
+
+func (p *TestRoleUserProvider) Authenticate(r *http.Request) *UserPrincipal {
+	role := r.URL.Query().Get("role")
+	if role != "" {
+		return &UserPrincipal{ID: role, Roles: []string{role}}
+	}
+
+	return nil
+}
+
+func (p *TestRoleUserProvider) Load(domain, id string) *UserPrincipal {
+	return nil
+}
+
+func TestMaintenanceHandler(t *testing.T) {
+	server := NewWebServer(":8099", nil)
+	server.WithStandardRouting("/web")
+	server.AddUserProvider(&TestRoleUserProvider{})
+	maintenance := server.AddMaintenanceHandler(time.Minute, "admin")
+	handler := maintenance.PipelineWith(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(method, url string) *httptest.ResponseRecorder {
+		writer := httptest.NewRecorder()
+		handler.ServeHTTP(writer, httptest.NewRequest(method, url, nil))
+		return writer
+	}
+
+	if result := serve(http.MethodPost, "/web/orders/create"); result.Code != http.StatusOK {
+		t.Error("expecting 200 but got", result.Code)
+		return
+	}
+
+	maintenance.SetControllerMode("orders", MaintenanceReadOnly)
+	if result := serve(http.MethodPost, "/web/orders/create"); result.Code != http.StatusServiceUnavailable || result.Header().Get("Retry-After") != "60" {
+		t.Error("expecting 503 with Retry-After but got", result.Code)
+		return
+	}
+
+	if result := serve(http.MethodGet, "/web/orders/list"); result.Code != http.StatusOK {
+		t.Error("expecting 200 for read only mode but got", result.Code)
+		return
+	}
+
+	if result := serve(http.MethodPost, "/web/users/create"); result.Code != http.StatusOK {
+		t.Error("expecting 200 for other controllers but got", result.Code)
+		return
+	}
+
+	maintenance.SetMode(MaintenanceFull)
+	if result := serve(http.MethodGet, "/web/users/list"); result.Code != http.StatusServiceUnavailable {
+		t.Error("expecting 503 but got", result.Code)
+		return
+	}
+
+	if result := serve(http.MethodPost, "/web/orders/create?role=admin"); result.Code != http.StatusOK {
+		t.Error("expecting admin to be let through but got", result.Code)
+		return
+	}
+
+	maintenance.SetMode(MaintenanceOff)
+	maintenance.SetControllerMode("orders", MaintenanceOff)
+	if result := serve(http.MethodPost, "/web/orders/create"); result.Code != http.StatusOK {
+		t.Error("expecting 200 but got", result.Code)
+		return
+	}
+}
+
+func TestMaintenanceMessageLocalized(t *testing.T) {
+	i18n := NewI18n("en-US")
+	if err := i18n.AddMessages("zh-CN", []byte(`{"cypress.maintenance": "维护中", "cypress.notFound": "找不到"}`)); err != nil {
+		t.Error("failed to add messages", err)
+		return
+	}
+
+	server := NewWebServer(":8099", nil)
+	server.WithStandardRouting("/web")
+	maintenance := server.AddMaintenanceHandler(time.Minute)
+	server.WithI18n(i18n)
+	handler := server.pipeline(false)
+	serve := func(url string) *httptest.ResponseRecorder {
+		writer := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, url, nil)
+		request.Header.Set("Accept-Language", "zh-CN")
+		handler.ServeHTTP(writer, request)
+		return writer
+	}
+
+	if result := serve("/missing"); result.Code != http.StatusNotFound || !strings.Contains(result.Body.String(), "找不到") {
+		t.Error("expecting localized 404 but got", result.Code, result.Body.String())
+		return
+	}
+
+	maintenance.SetMode(MaintenanceFull)
+	if result := serve("/web/orders/list"); result.Code != http.StatusServiceUnavailable || !strings.Contains(result.Body.String(), "维护中") {
+		t.Error("expecting localized maintenance message but got", result.Code, result.Body.String())
+	}
+}
+
+type TestCountingUserProvider struct {
+	TestRoleUserProvider
+	calls int
+}
+
+func (p *TestCountingUserProvider) Authenticate(r *http.Request) *UserPrincipal {
+	p.calls++
+	return p.TestRoleUserProvider.Authenticate(r)
+}
+
+func TestMaintenanceAdminAuthenticatedOnce(t *testing.T) {
+	provider := &TestCountingUserProvider{}
+	authz := NewRoleBasedAuthz().WithRoleHierarchy("owner", "admin")
+	authz.AddRule(&AccessRule{Path: "/web/**"})
+	server := NewWebServer(":8099", nil)
+	server.AddUserProvider(provider)
+	server.WithAuthz(authz)
+	maintenance := server.AddMaintenanceHandler(time.Minute, "admin")
+	maintenance.SetMode(MaintenanceFull)
+	handler := maintenance.PipelineWith(server.securityHandler.WithPipeline(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusOK)
+	})))
+
+	writer := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/web/orders/create?role=owner", nil)
+	handler.ServeHTTP(writer, request.WithContext(extentContext(request.Context())))
+	if writer.Code != http.StatusOK || provider.calls != 1 {
+		t.Error("expecting the inherited admin role to be let through with one authentication but got", writer.Code, provider.calls)
+	}
+}
@@ -0,0 +1,545 @@
+package cypress
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	oidcStateSessionKey     = "cypress$oidc$state"
+	oidcNonceSessionKey     = "cypress$oidc$nonce"
+	oidcVerifierSessionKey  = "cypress$oidc$verifier"
+	oidcReturnURLSessionKey = "cypress$oidc$returnUrl"
+	oidcIDSessionKey        = "cypress$oidc$id"
+	oidcDomainSessionKey    = "cypress$oidc$domain"
+	oidcNameSessionKey      = "cypress$oidc$name"
+	oidcRolesSessionKey     = "cypress$oidc$roles"
+)
+
+var (
+	// ErrBadOIDCState the state of the callback does not match the login request
+	ErrBadOIDCState = errors.New("bad oidc state")
+
+	// ErrBadNonce the nonce of the id token does not match the login request
+	ErrBadNonce = errors.New("bad id token nonce")
+
+	// JWKSMinRefreshInterval the minimal interval to refetch the JWKS when a token is
+	// signed by an unknown key
+	JWKSMinRefreshInterval = time.Minute
+)
+
+// OIDCConfig the configuration of an OpenID Connect provider, the endpoints are
+// discovered from the issuer if they are not set
+type OIDCConfig struct {
+	Issuer                string
+	ClientID              string
+	ClientSecret          string
+	RedirectURL           string
+	Scopes                []string
+	AuthorizationEndpoint string
+	TokenEndpoint         string
+	JWKSURI               string
+	Domain                string
+	HTTPClient            *http.Client
+}
+
+// OIDCClaimsMapper maps the claims of the id token to UserPrincipal, returns nil if
+// the user is not allowed to sign in
+type OIDCClaimsMapper func(claims map[string]interface{}) *UserPrincipal
+
+// OIDCUserProvider a UserProvider that serves the identity signed in by an OpenID Connect
+// provider through the authorization code flow with PKCE
+type OIDCUserProvider struct {
+	server     *WebServer
+	config     *OIDCConfig
+	keys       *jwks
+	mapper     OIDCClaimsMapper
+	successURL string
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+	Error       string `json:"error"`
+}
+
+type jsonWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	Y         string `json:"y"`
+}
+
+// jwks a JWTKeySet fetched from a JWKS URI, which is refetched when a token
+// is signed by an unknown key for key rotation
+type jwks struct {
+	uri       string
+	client    *http.Client
+	lock      *sync.Mutex
+	keys      *JWTKeySet
+	fetchedAt time.Time
+}
+
+func getJSON(client *http.Client, uri string, result interface{}) error {
+	resp, err := client.Get(uri)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return NewHTTPError(http.StatusBadGateway, "unexpected status "+resp.Status+" from "+uri, nil)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(data), nil
+}
+
+// toJWTKey converts the json web key to JWTKey, returns nil if the key is not supported
+func (key *jsonWebKey) toJWTKey() *JWTKey {
+	if key.Use != "" && key.Use != "sig" {
+		return nil
+	}
+
+	switch {
+	case key.KeyType == "RSA" && (key.Algorithm == "" || key.Algorithm == JWTAlgRS256):
+		n, err := decodeBigInt(key.N)
+		if err != nil {
+			return nil
+		}
+
+		e, err := decodeBigInt(key.E)
+		if err != nil || !e.IsInt64() {
+			return nil
+		}
+
+		return &JWTKey{ID: key.KeyID, Algorithm: JWTAlgRS256, Key: &rsa.PublicKey{N: n, E: int(e.Int64())}}
+	case key.KeyType == "EC" && key.Curve == "P-256" && (key.Algorithm == "" || key.Algorithm == JWTAlgES256):
+		x, err := decodeBigInt(key.X)
+		if err != nil {
+			return nil
+		}
+
+		y, err := decodeBigInt(key.Y)
+		if err != nil {
+			return nil
+		}
+
+		return &JWTKey{ID: key.KeyID, Algorithm: JWTAlgES256, Key: &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}}
+	}
+
+	return nil
+}
+
+func newJWKS(uri string, client *http.Client) (*jwks, error) {
+	keys := &jwks{uri: uri, client: client, lock: &sync.Mutex{}}
+	if err := keys.fetch(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// fetch fetches the keys, the caller must hold the lock if it's shared
+func (keys *jwks) fetch() error {
+	result := &struct {
+		Keys []*jsonWebKey `json:"keys"`
+	}{}
+	if err := getJSON(keys.client, keys.uri, result); err != nil {
+		return err
+	}
+
+	keySet := NewJWTKeySet()
+	for _, key := range result.Keys {
+		if jwtKey := key.toJWTKey(); jwtKey != nil {
+			keySet.Add(jwtKey)
+		}
+	}
+
+	keys.keys = keySet
+	keys.fetchedAt = time.Now()
+	return nil
+}
+
+// verify verifies the token and returns the payload, the keys are refetched if the
+// token is not signed by any known key
+func (keys *jwks) verify(token string) ([]byte, error) {
+	keys.lock.Lock()
+	keySet := keys.keys
+	keys.lock.Unlock()
+	payload, err := verifyJWT(keySet, token)
+	if err != ErrBadSignature {
+		return payload, err
+	}
+
+	refetched := func() bool {
+		keys.lock.Lock()
+		defer keys.lock.Unlock()
+		if time.Since(keys.fetchedAt) < JWKSMinRefreshInterval {
+			return false
+		}
+
+		if err := keys.fetch(); err != nil {
+			zap.L().Error("failedToFetchJWKS", zap.String("uri", keys.uri), zap.Error(err))
+			return false
+		}
+
+		keySet = keys.keys
+		return true
+	}()
+
+	if !refetched {
+		return nil, err
+	}
+
+	return verifyJWT(keySet, token)
+}
+
+// DefaultOIDCClaimsMapper maps sub to ID, name, preferred_username or email to Name,
+// and roles or groups to Roles
+func DefaultOIDCClaimsMapper(claims map[string]interface{}) *UserPrincipal {
+	user := &UserPrincipal{Self: claims}
+	user.ID, _ = claims["sub"].(string)
+	for _, name := range []string{"name", "preferred_username", "email"} {
+		if value, ok := claims[name].(string); ok && value != "" {
+			user.Name = value
+			break
+		}
+	}
+
+	for _, name := range []string{"roles", "groups"} {
+		if values, ok := claims[name].([]interface{}); ok {
+			for _, value := range values {
+				if role, ok := value.(string); ok {
+					user.Roles = append(user.Roles, role)
+				}
+			}
+
+			break
+		}
+	}
+
+	return user
+}
+
+// AddOIDCLogin adds an OIDCUserProvider to the web server, which redirects the users to
+// the authorization endpoint of the provider on loginPath, and signs the users in on
+// callbackPath, which must be the path of config.RedirectURL. The state, nonce and
+// PKCE code verifier are kept in the session during the flow
+func (server *WebServer) AddOIDCLogin(loginPath, callbackPath string, config *OIDCConfig) (*OIDCUserProvider, error) {
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "profile", "email"}
+	}
+
+	if config.AuthorizationEndpoint == "" || config.TokenEndpoint == "" || config.JWKSURI == "" {
+		discovery := &oidcDiscovery{}
+		err := getJSON(config.HTTPClient, strings.TrimSuffix(config.Issuer, "/")+"/.well-known/openid-configuration", discovery)
+		if err != nil {
+			return nil, err
+		}
+
+		if discovery.Issuer != config.Issuer {
+			return nil, ErrBadIssuer
+		}
+
+		if config.AuthorizationEndpoint == "" {
+			config.AuthorizationEndpoint = discovery.AuthorizationEndpoint
+		}
+
+		if config.TokenEndpoint == "" {
+			config.TokenEndpoint = discovery.TokenEndpoint
+		}
+
+		if config.JWKSURI == "" {
+			config.JWKSURI = discovery.JWKSURI
+		}
+	}
+
+	keys, err := newJWKS(config.JWKSURI, config.HTTPClient)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := &OIDCUserProvider{
+		server:     server,
+		config:     config,
+		keys:       keys,
+		mapper:     DefaultOIDCClaimsMapper,
+		successURL: "/",
+	}
+
+	server.AddUserProvider(provider)
+	server.router.HandleFunc(loginPath, provider.handleLogin).Methods(http.MethodGet)
+	server.router.HandleFunc(callbackPath, provider.handleCallback).Methods(http.MethodGet)
+	return provider, nil
+}
+
+// WithClaimsMapper sets the mapper that maps the claims of the id token to UserPrincipal
+func (provider *OIDCUserProvider) WithClaimsMapper(mapper OIDCClaimsMapper) *OIDCUserProvider {
+	provider.mapper = mapper
+	return provider
+}
+
+// WithSuccessURL sets the URL that the user is redirected to after sign in if no
+// returnUrl is given to the login path
+func (provider *OIDCUserProvider) WithSuccessURL(successURL string) *OIDCUserProvider {
+	provider.successURL = successURL
+	return provider
+}
+
+// GetName implements UserProvider interface
+func (provider *OIDCUserProvider) GetName() string {
+	return "oidc"
+}
+
+// Authenticate implements UserProvider interface
+func (provider *OIDCUserProvider) Authenticate(request *http.Request) *UserPrincipal {
+	session := GetSession(request)
+	if session == nil {
+		return nil
+	}
+
+	id, ok := session.GetValue(oidcIDSessionKey)
+	if !ok {
+		return nil
+	}
+
+	user := &UserPrincipal{}
+	user.ID, _ = id.(string)
+	if value, ok := session.GetValue(oidcDomainSessionKey); ok {
+		user.Domain, _ = value.(string)
+	}
+
+	if value, ok := session.GetValue(oidcNameSessionKey); ok {
+		user.Name, _ = value.(string)
+	}
+
+	if value, ok := session.GetValue(oidcRolesSessionKey); ok {
+		user.Roles, _ = value.([]string)
+	}
+
+	return user
+}
+
+// Load implements UserProvider interface, the identities of the provider could not be loaded
+func (provider *OIDCUserProvider) Load(domain, id string) *UserPrincipal {
+	return nil
+}
+
+func randomURLString(size int) (string, error) {
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func (provider *OIDCUserProvider) handleLogin(writer http.ResponseWriter, request *http.Request) {
+	response := &Response{traceID: GetTraceID(request.Context()), request: request, writer: writer}
+	session := GetSession(request)
+	if session == nil {
+		response.doneWithHTTPError(NewHTTPError(http.StatusInternalServerError, "Session is required", nil), false)
+		return
+	}
+
+	values := make([]string, 3)
+	for i := range values {
+		value, err := randomURLString(32)
+		if err != nil {
+			response.doneWithHTTPError(err, false)
+			return
+		}
+
+		values[i] = value
+	}
+
+	state, nonce, verifier := values[0], values[1], values[2]
+	session.SetValue(oidcStateSessionKey, state)
+	session.SetValue(oidcNonceSessionKey, nonce)
+	session.SetValue(oidcVerifierSessionKey, verifier)
+	session.SetValue(oidcReturnURLSessionKey, safeReturnURL(request.FormValue(LoginReturnURLField), provider.successURL))
+	query := url.Values{
+		"response_type":         []string{"code"},
+		"client_id":             []string{provider.config.ClientID},
+		"redirect_uri":          []string{provider.config.RedirectURL},
+		"scope":                 []string{strings.Join(provider.config.Scopes, " ")},
+		"state":                 []string{state},
+		"nonce":                 []string{nonce},
+		"code_challenge":        []string{base64.RawURLEncoding.EncodeToString(Sha256([]byte(verifier)))},
+		"code_challenge_method": []string{"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(provider.config.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	http.Redirect(writer, request, provider.config.AuthorizationEndpoint+separator+query.Encode(), http.StatusFound)
+}
+
+// takeSessionString removes the value from the session and returns it
+func takeSessionString(session *Session, key string) string {
+	value, _ := session.GetAsFlashValue(key)
+	result, _ := value.(string)
+	return result
+}
+
+func (provider *OIDCUserProvider) handleCallback(writer http.ResponseWriter, request *http.Request) {
+	response := &Response{traceID: GetTraceID(request.Context()), request: request, writer: writer}
+	session := GetSession(request)
+	if session == nil {
+		response.doneWithHTTPError(NewHTTPError(http.StatusInternalServerError, "Session is required", nil), false)
+		return
+	}
+
+	state := takeSessionString(session, oidcStateSessionKey)
+	nonce := takeSessionString(session, oidcNonceSessionKey)
+	verifier := takeSessionString(session, oidcVerifierSessionKey)
+	returnURL := takeSessionString(session, oidcReturnURLSessionKey)
+	if errorCode := request.FormValue("error"); errorCode != "" {
+		zap.L().Warn("oidcLoginFailed", zap.String("error", errorCode), zap.String("description", request.FormValue("error_description")), zap.String("activityId", response.traceID))
+		response.doneWithHTTPError(NewHTTPError(http.StatusUnauthorized, errorCode, nil), false)
+		return
+	}
+
+	if state == "" || !hmac.Equal([]byte(state), []byte(request.FormValue("state"))) {
+		zap.L().Warn("oidcLoginFailed", zap.Error(ErrBadOIDCState), zap.String("activityId", response.traceID))
+		response.doneWithHTTPError(NewHTTPError(http.StatusBadRequest, ErrBadOIDCState.Error(), nil), false)
+		return
+	}
+
+	user, err := provider.exchange(request, request.FormValue("code"), verifier, nonce)
+	if err != nil {
+		zap.L().Warn("oidcLoginFailed", zap.Error(err), zap.String("activityId", response.traceID))
+		if _, ok := err.(*HTTPError); !ok {
+			err = NewHTTPError(http.StatusUnauthorized, Localize(request, AccessDeniedMsgKey, "Access denied"), nil)
+		}
+
+		response.doneWithHTTPError(err, false)
+		return
+	}
+
+	session = provider.server.renewSession(writer, request)
+	session.SetValue(oidcIDSessionKey, user.ID)
+	session.SetValue(oidcDomainSessionKey, user.Domain)
+	session.SetValue(oidcNameSessionKey, user.Name)
+	if len(user.Roles) > 0 {
+		session.SetValue(oidcRolesSessionKey, user.Roles)
+	}
+
+	if err = provider.server.sessionStore.Save(session, provider.server.sessionTimeout); err != nil {
+		response.doneWithHTTPError(err, false)
+		return
+	}
+
+	zap.L().Info("loggedIn", zap.String("user", user.ID), zap.String("domain", user.Domain), zap.String("provider", provider.GetName()), zap.String("activityId", response.traceID))
+	if returnURL == "" {
+		returnURL = provider.successURL
+	}
+
+	http.Redirect(writer, request, returnURL, http.StatusSeeOther)
+}
+
+// exchange exchanges the authorization code for the id token and maps it to UserPrincipal
+func (provider *OIDCUserProvider) exchange(request *http.Request, code, verifier, nonce string) (*UserPrincipal, error) {
+	form := url.Values{
+		"grant_type":    []string{"authorization_code"},
+		"code":          []string{code},
+		"redirect_uri":  []string{provider.config.RedirectURL},
+		"client_id":     []string{provider.config.ClientID},
+		"code_verifier": []string{verifier},
+	}
+
+	if provider.config.ClientSecret != "" {
+		form.Set("client_secret", provider.config.ClientSecret)
+	}
+
+	resp, err := provider.config.HTTPClient.PostForm(provider.config.TokenEndpoint, form)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	tokenResponse := &oidcTokenResponse{}
+	if resp.StatusCode != http.StatusOK {
+		// the error response may not be json, e.g. an error page of a gateway
+		json.NewDecoder(resp.Body).Decode(tokenResponse)
+		zap.L().Warn("oidcTokenExchangeFailed", zap.Int("status", resp.StatusCode), zap.String("error", tokenResponse.Error), zap.String("activityId", GetTraceID(request.Context())))
+		return nil, NewHTTPError(http.StatusUnauthorized, "token exchange failed", nil)
+	}
+
+	if err = json.NewDecoder(resp.Body).Decode(tokenResponse); err != nil || tokenResponse.IDToken == "" {
+		return nil, NewHTTPError(http.StatusUnauthorized, "token exchange failed", nil)
+	}
+
+	payload, err := provider.keys.verify(tokenResponse.IDToken)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &JWTClaims{}
+	values := make(map[string]interface{})
+	if json.Unmarshal(payload, claims) != nil || json.Unmarshal(payload, &values) != nil {
+		return nil, ErrBadToken
+	}
+
+	if err = claims.Validate(provider.config.Issuer, provider.config.ClientID, time.Minute); err != nil {
+		return nil, err
+	}
+
+	if !hmac.Equal([]byte(claims.Nonce), []byte(nonce)) {
+		return nil, ErrBadNonce
+	}
+
+	user := provider.mapper(values)
+	if user == nil {
+		return nil, NewHTTPError(http.StatusForbidden, Localize(request, AccessDeniedMsgKey, "Access denied"), nil)
+	}
+
+	if user.Domain == "" {
+		user.Domain = provider.config.Domain
+	}
+
+	user.Provider = provider.GetName()
+	return user, nil
+}
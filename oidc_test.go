@@ -0,0 +1,171 @@
+package cypress
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testIdentityProvider a stand-in OpenID Connect provider that signs in alice automatically
+type testIdentityProvider struct {
+	server     *httptest.Server
+	key        *rsa.PrivateKey
+	challenges map[string]string
+	nonces     map[string]string
+	unhealthy  bool
+}
+
+func newTestIdentityProvider() (*testIdentityProvider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	idp := &testIdentityProvider{key: key, challenges: make(map[string]string), nonces: make(map[string]string)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(writer http.ResponseWriter, request *http.Request) {
+		json.NewEncoder(writer).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(writer http.ResponseWriter, request *http.Request) {
+		json.NewEncoder(writer).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "k1",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/authorize", func(writer http.ResponseWriter, request *http.Request) {
+		query := request.URL.Query()
+		if query.Get("client_id") != "cypress" || query.Get("code_challenge_method") != "S256" {
+			http.Error(writer, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		code := NewSessionID()
+		idp.challenges[code] = query.Get("code_challenge")
+		idp.nonces[code] = query.Get("nonce")
+		http.Redirect(writer, request, query.Get("redirect_uri")+"?code="+code+"&state="+url.QueryEscape(query.Get("state")), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(writer http.ResponseWriter, request *http.Request) {
+		if idp.unhealthy {
+			writer.Header().Set("Content-Type", "text/html")
+			writer.WriteHeader(http.StatusBadGateway)
+			writer.Write([]byte("<html><body>Bad Gateway</body></html>"))
+			return
+		}
+
+		code := request.FormValue("code")
+		challenge, ok := idp.challenges[code]
+		delete(idp.challenges, code)
+		if !ok || request.FormValue("client_secret") != "secret" || base64.RawURLEncoding.EncodeToString(Sha256([]byte(request.FormValue("code_verifier")))) != challenge {
+			writer.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(writer).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		token, _ := SignJWT(&JWTKey{ID: "k1", Algorithm: JWTAlgRS256, Key: key}, &JWTClaims{
+			Subject:   "alice",
+			Issuer:    idp.server.URL,
+			Audience:  JWTAudience{"cypress"},
+			ExpiresAt: time.Now().Add(time.Minute).Unix(),
+			Nonce:     idp.nonces[code],
+			Name:      "Alice",
+			Roles:     []string{"admin"},
+		})
+		json.NewEncoder(writer).Encode(map[string]string{"access_token": "at", "token_type": "Bearer", "id_token": token})
+	})
+
+	idp.server = httptest.NewServer(mux)
+	return idp, nil
+}
+
+func TestOIDCLogin(t *testing.T) {
+	idp, err := newTestIdentityProvider()
+	if err != nil {
+		t.Error("failed to create identity provider", err)
+		return
+	}
+
+	defer idp.server.Close()
+	sessionStore := NewInMemorySessionStore()
+	defer sessionStore.Close()
+
+	server := NewWebServer(":8099", nil)
+	server.WithSessionOptions(sessionStore, time.Minute)
+	front := httptest.NewUnstartedServer(nil)
+	frontURL := "http://" + front.Listener.Addr().String()
+	provider, err := server.AddOIDCLogin("/login", "/callback", &OIDCConfig{
+		Issuer:       idp.server.URL,
+		ClientID:     "cypress",
+		ClientSecret: "secret",
+		RedirectURL:  frontURL + "/callback",
+		Domain:       "corp",
+	})
+	if err != nil {
+		t.Error("failed to add oidc login", err)
+		return
+	}
+
+	server.HandleFunc("/me", func(writer http.ResponseWriter, request *http.Request) {
+		if user := provider.Authenticate(request); user != nil {
+			writer.Write([]byte(user.Domain + "/" + user.ID + "/" + user.Name + "/" + strings.Join(user.Roles, ",")))
+		}
+	})
+
+	front.Config.Handler = server.pipeline(true)
+	front.Start()
+	defer front.Close()
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+	get := func(path string) (int, string) {
+		resp, err := client.Get(frontURL + path)
+		if err != nil {
+			return 0, ""
+		}
+
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if status, body := get("/callback?code=x&state=forged"); status != http.StatusBadRequest {
+		t.Error("expecting forged state to be rejected", status, body)
+		return
+	}
+
+	if status, body := get("/login?returnUrl=/me"); status != http.StatusOK || body != "corp/alice/Alice/admin" {
+		t.Error("expecting user to be signed in", status, body)
+		return
+	}
+
+	// replaying the login callback must fail as the state is consumed
+	if status, _ := get("/callback?code=x&state="); status != http.StatusBadRequest {
+		t.Error("expecting replayed callback to be rejected", status)
+		return
+	}
+
+	idp.unhealthy = true
+	if status, body := get("/login?returnUrl=/me"); status != http.StatusUnauthorized || !strings.Contains(body, "token exchange failed") {
+		t.Error("expecting token exchange to fail", status, body)
+		return
+	}
+}
@@ -0,0 +1,387 @@
+package cypress
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"path"
+	"reflect"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+
+	openAPIPageTemplate, _ = template.New("openAPIPageTemplate").Parse(`<!DOCTYPE html>
+	<html>
+		<head>
+			<meta http-equiv="Content-Type" content="text/html; charset=utf-8">
+			<meta name="viewport" content="width=device-width, initial-scale=1">
+			<title>{{.Info.Title}} - {{.Info.Version}}</title>
+			<style>
+			body {
+				font-size:12px;
+				margin:20px 10% 0 10%;
+			}
+			.operation {
+				border-top:solid 1px #c9c9c9;
+				padding:10px 0;
+			}
+			.method {
+				font-weight:bold;
+				text-transform:uppercase;
+			}
+			td, th {
+				text-align:left;
+				padding-right:20px;
+			}
+			</style>
+		</head>
+		<body>
+			<h1>{{.Info.Title}} <small>{{.Info.Version}}</small></h1>
+			{{range $path, $item := .Paths}}{{range $method, $op := $item}}
+			<div class="operation">
+				<h3><span class="method">{{$method}}</span> {{$path}}</h3>
+				{{if $op.Summary}}<p>{{$op.Summary}}</p>{{end}}
+				{{if $op.Parameters}}
+				<table>
+					<tr><th>Name</th><th>In</th><th>Required</th><th>Description</th></tr>
+					{{range $op.Parameters}}<tr><td>{{.Name}}</td><td>{{.In}}</td><td>{{.Required}}</td><td>{{.Description}}</td></tr>{{end}}
+				</table>
+				{{end}}
+				{{with $op.RequestBody}}{{range $type, $media := .Content}}<p>Request: {{$type}} {{$media.Schema.Ref}}{{$media.Schema.Type}}</p>{{end}}{{end}}
+				{{range $status, $resp := $op.Responses}}{{range $type, $media := $resp.Content}}<p>Response {{$status}}: {{$type}} {{$media.Schema.Ref}}{{$media.Schema.Type}}</p>{{end}}{{end}}
+			</div>
+			{{end}}{{end}}
+			<div style="text-align:center;color:#999;border-top:solid 1px #c9c9c9;line-height:30px;">
+				Powered by {{.Info.Title}} - {{.Info.Version}}
+			</div>
+		</body>
+	</html>`)
+)
+
+// OpenAPISchema schema object of OpenAPI 3
+type OpenAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Items                *OpenAPISchema            `json:"items,omitempty"`
+	Properties           map[string]*OpenAPISchema `json:"properties,omitempty"`
+	AdditionalProperties *OpenAPISchema            `json:"additionalProperties,omitempty"`
+}
+
+// OpenAPIParameter parameter object of OpenAPI 3
+type OpenAPIParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required"`
+	Schema      *OpenAPISchema `json:"schema"`
+}
+
+// OpenAPIMediaType media type object of OpenAPI 3
+type OpenAPIMediaType struct {
+	Schema *OpenAPISchema `json:"schema"`
+}
+
+// OpenAPIRequestBody request body object of OpenAPI 3
+type OpenAPIRequestBody struct {
+	Content map[string]*OpenAPIMediaType `json:"content"`
+}
+
+// OpenAPIResponse response object of OpenAPI 3
+type OpenAPIResponse struct {
+	Description string                       `json:"description"`
+	Content     map[string]*OpenAPIMediaType `json:"content,omitempty"`
+}
+
+// OpenAPIOperation operation object of OpenAPI 3
+type OpenAPIOperation struct {
+	OperationID string                      `json:"operationId"`
+	Summary     string                      `json:"summary,omitempty"`
+	Tags        []string                    `json:"tags,omitempty"`
+	Parameters  []*OpenAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *OpenAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*OpenAPIResponse `json:"responses"`
+}
+
+// OpenAPIInfo info object of OpenAPI 3
+type OpenAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// OpenAPIComponents components object of OpenAPI 3
+type OpenAPIComponents struct {
+	Schemas map[string]*OpenAPISchema `json:"schemas,omitempty"`
+}
+
+// OpenAPIDocument OpenAPI 3 document
+type OpenAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       OpenAPIInfo                             `json:"info"`
+	Paths      map[string]map[string]*OpenAPIOperation `json:"paths"`
+	Components OpenAPIComponents                       `json:"components"`
+}
+
+// WithMethods creates a ControllerOption that only allows the given http methods for
+// the given actions, or all actions of the controller if no action is given, other
+// methods are rejected with a 405 status
+func WithMethods(methods []string, actions ...string) ControllerOption {
+	return ControllerOption(func(controller string, action Action) Action {
+		if containsAction(actions, action.Name) {
+			action.Methods = methods
+		}
+
+		return action
+	})
+}
+
+// WithActionDoc creates a ControllerOption that describes the action for api document,
+// parameters, body and result are sample values, which could be nil, whose types are used
+// as the parameters, json request body and json response types, see Action for details
+func WithActionDoc(action, summary string, parameters, body, result interface{}) ControllerOption {
+	return ControllerOption(func(controller string, item Action) Action {
+		if item.Name != action {
+			return item
+		}
+
+		item.Summary = summary
+		if parameters != nil {
+			item.Parameters = reflect.TypeOf(parameters)
+		}
+
+		if body != nil {
+			item.Body = reflect.TypeOf(body)
+		}
+
+		if result != nil {
+			item.Result = reflect.TypeOf(result)
+		}
+
+		return item
+	})
+}
+
+type schemaBuilder struct {
+	schemas map[string]*OpenAPISchema
+}
+
+func (builder *schemaBuilder) schemaOf(t reflect.Type) *OpenAPISchema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == timeType {
+		return &OpenAPISchema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &OpenAPISchema{Type: "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &OpenAPISchema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		return &OpenAPISchema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &OpenAPISchema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &OpenAPISchema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &OpenAPISchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &OpenAPISchema{Type: "string", Format: "byte"}
+		}
+
+		return &OpenAPISchema{Type: "array", Items: builder.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &OpenAPISchema{Type: "object", AdditionalProperties: builder.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return builder.structSchema(t)
+		}
+
+		// the types with the same name from different packages must not overwrite each other
+		name := path.Base(t.PkgPath()) + "." + t.Name()
+
+		// put a placeholder before building properties to break circular references
+		if _, ok := builder.schemas[name]; !ok {
+			builder.schemas[name] = &OpenAPISchema{}
+			builder.schemas[name] = builder.structSchema(t)
+		}
+
+		return &OpenAPISchema{Ref: "#/components/schemas/" + name}
+	}
+
+	return &OpenAPISchema{}
+}
+
+func (builder *schemaBuilder) structSchema(t reflect.Type) *OpenAPISchema {
+	schema := &OpenAPISchema{Type: "object", Properties: make(map[string]*OpenAPISchema)}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+
+		if field.Anonymous && name == "" && fieldType.Kind() == reflect.Struct {
+			for key, value := range builder.structSchema(fieldType).Properties {
+				schema.Properties[key] = value
+			}
+
+			continue
+		}
+
+		if field.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		schema.Properties[name] = builder.schemaOf(field.Type)
+	}
+
+	return schema
+}
+
+func (builder *schemaBuilder) parametersOf(t reflect.Type) []*OpenAPIParameter {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	parameters := make([]*OpenAPIParameter, 0, t.NumField())
+	if t.Kind() != reflect.Struct {
+		return parameters
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		in := "query"
+		name := field.Tag.Get("query")
+		if name == "" {
+			in = "header"
+			name = field.Tag.Get("header")
+		}
+
+		if name == "" {
+			continue
+		}
+
+		parameters = append(parameters, &OpenAPIParameter{
+			Name:        name,
+			In:          in,
+			Description: field.Tag.Get("doc"),
+			Required:    field.Tag.Get("required") == "true",
+			Schema:      builder.schemaOf(field.Type),
+		})
+	}
+
+	return parameters
+}
+
+// OpenAPIDocument generates the OpenAPI 3 document from the registered controllers, the
+// struct schemas are named after the package and the type, e.g. cypress.UserPrincipal. The
+// actions without Methods accept all the methods, but are documented as GET, or POST if
+// they have a request body, use WithMethods to document the methods precisely
+func (server *WebServer) OpenAPIDocument() *OpenAPIDocument {
+	builder := &schemaBuilder{make(map[string]*OpenAPISchema)}
+	doc := &OpenAPIDocument{
+		OpenAPI:    "3.0.0",
+		Info:       OpenAPIInfo{ServerName, ServerVersion},
+		Paths:      make(map[string]map[string]*OpenAPIOperation),
+		Components: OpenAPIComponents{builder.schemas},
+	}
+
+	for controller, actions := range server.registeredActions {
+		for name, action := range actions {
+			operation := &OpenAPIOperation{
+				OperationID: controller + "." + name,
+				Summary:     action.Summary,
+				Tags:        []string{controller},
+				Responses:   map[string]*OpenAPIResponse{"200": {Description: "OK"}},
+			}
+
+			if action.Parameters != nil {
+				operation.Parameters = builder.parametersOf(action.Parameters)
+			}
+
+			if action.Body != nil {
+				operation.RequestBody = &OpenAPIRequestBody{map[string]*OpenAPIMediaType{
+					"application/json": {builder.schemaOf(action.Body)},
+				}}
+			}
+
+			if action.Result != nil {
+				operation.Responses["200"].Content = map[string]*OpenAPIMediaType{
+					"application/json": {builder.schemaOf(action.Result)},
+				}
+			}
+
+			methods := action.Methods
+			if len(methods) == 0 {
+				methods = []string{http.MethodGet}
+				if action.Body != nil {
+					methods = []string{http.MethodPost}
+				}
+			}
+
+			item := make(map[string]*OpenAPIOperation)
+			for _, method := range methods {
+				methodOperation := *operation
+				if len(methods) > 1 {
+					// operation id must be unique in the document
+					methodOperation.OperationID = operation.OperationID + "." + strings.ToLower(method)
+				}
+
+				item[strings.ToLower(method)] = &methodOperation
+			}
+
+			doc.Paths[server.routingPrefix+"/"+controller+"/"+name] = item
+		}
+	}
+
+	return doc
+}
+
+// WithOpenAPI serves the OpenAPI 3 json document of the registered controllers at the given path
+func (server *WebServer) WithOpenAPI(path string) *WebServer {
+	server.router.HandleFunc(path, func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		err := json.NewEncoder(writer).Encode(server.OpenAPIDocument())
+		if err != nil {
+			zap.L().Error("failedToEncodeOpenAPIDocument", zap.Error(err), zap.String("activityId", GetTraceID(request.Context())))
+		}
+	})
+
+	return server
+}
+
+// WithOpenAPIPage serves a html page that renders the OpenAPI 3 document of the registered
+// controllers with a built-in template at the given path
+func (server *WebServer) WithOpenAPIPage(path string) *WebServer {
+	server.router.HandleFunc(path, func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "text/html; charset=UTF-8")
+		err := openAPIPageTemplate.Execute(writer, server.OpenAPIDocument())
+		if err != nil {
+			zap.L().Error("failedToRenderOpenAPIPage", zap.Error(err), zap.String("activityId", GetTraceID(request.Context())))
+		}
+	})
+
+	return server
+}
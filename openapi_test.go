@@ -0,0 +1,97 @@
+package cypress
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type TestSearchParams struct {
+	Keyword string `query:"q" doc:"the keyword" required:"true"`
+	Page    int    `query:"page"`
+	Locale  string `header:"Accept-Language"`
+}
+
+type TestItem struct {
+	ID       int64       `json:"id"`
+	Name     string      `json:"name"`
+	Children []*TestItem `json:"children,omitempty"`
+	Secret   string      `json:"-"`
+}
+
+type Cookie struct {
+	Flavor string `json:"flavor"`
+}
+
+type TestAPIController struct{}
+
+func (c *TestAPIController) Search(req *http.Request, resp *Response) ([]*TestItem, error) {
+	return nil, nil
+}
+
+func (c *TestAPIController) Create(req *http.Request, resp *Response) (*TestItem, error) {
+	return nil, nil
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	server := NewWebServer(":8099", nil)
+	server.WithStandardRouting("/api")
+	server.RegisterController("items", AsController(&TestAPIController{}),
+		WithMethods([]string{http.MethodPost}, "create"),
+		WithActionDoc("search", "search items", TestSearchParams{}, nil, nil),
+		WithActionDoc("create", "create an item", nil, &TestItem{}, nil))
+
+	doc := server.OpenAPIDocument()
+	search, ok := doc.Paths["/api/items/search"]["get"]
+	if !ok {
+		t.Error("search operation is not found", doc.Paths)
+		return
+	}
+
+	if len(search.Parameters) != 3 || search.Parameters[0].Name != "q" || !search.Parameters[0].Required || search.Parameters[2].In != "header" {
+		t.Error("unexpected parameters for search")
+		return
+	}
+
+	if search.Responses["200"].Content["application/json"].Schema.Items.Ref != "#/components/schemas/cypress.TestItem" {
+		t.Error("unexpected response schema for search")
+		return
+	}
+
+	create, ok := doc.Paths["/api/items/create"]["post"]
+	if !ok || create.RequestBody == nil || create.Summary != "create an item" {
+		t.Error("unexpected create operation")
+		return
+	}
+
+	item := doc.Components.Schemas["cypress.TestItem"]
+	if item == nil || len(item.Properties) != 3 || item.Properties["children"].Items.Ref != "#/components/schemas/cypress.TestItem" {
+		t.Error("unexpected schema for TestItem", item)
+		return
+	}
+
+	builder := &schemaBuilder{make(map[string]*OpenAPISchema)}
+	builder.schemaOf(reflect.TypeOf(&Cookie{}))
+	builder.schemaOf(reflect.TypeOf(&http.Cookie{}))
+	if cookie := builder.schemas["cypress.Cookie"]; cookie == nil || len(cookie.Properties) != 1 || builder.schemas["http.Cookie"] == nil {
+		t.Error("expecting the types with the same name to have their own schemas", builder.schemas)
+		return
+	}
+
+	server.WithOpenAPIPage("/apidoc")
+	writer := httptest.NewRecorder()
+	server.router.ServeHTTP(writer, httptest.NewRequest(http.MethodGet, "/apidoc", nil))
+	if writer.Code != http.StatusOK || !strings.Contains(writer.Body.String(), "/api/items/search") {
+		t.Error("unexpected api document page", writer.Code, writer.Body.String())
+		return
+	}
+
+	writer = httptest.NewRecorder()
+	server.router.ServeHTTP(writer, httptest.NewRequest(http.MethodGet, "/api/items/create", nil))
+	if writer.Code != http.StatusMethodNotAllowed || writer.Header().Get("Allow") != http.MethodPost {
+		t.Error("expecting 405 but got", writer.Code)
+		return
+	}
+}
@@ -0,0 +1,219 @@
+package cypress
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrCacheMiss no cached output is found for the given key
+	ErrCacheMiss = errors.New("cache miss")
+)
+
+// CachedOutput the output of an action that is stored in OutputCacheStore
+type CachedOutput struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// OutputCacheStore interface for storing action outputs
+type OutputCacheStore interface {
+	// Get gets the cached output with the given key, returns
+	// (nil, ErrCacheMiss) if the output is not found or expired
+	Get(key string) (*CachedOutput, error)
+
+	// Save saves the output with the given key and ttl, the output
+	// is associated with the given tags, so that it could be removed
+	// by any of the tags, the output is not cached if ttl is not positive,
+	// and the output cached with the key before is removed
+	Save(key string, output *CachedOutput, ttl time.Duration, tags []string) error
+
+	// Remove removes the cached output with the given key
+	Remove(key string) error
+
+	// RemoveByTag removes all cached outputs associated with the given tag
+	RemoveByTag(tag string) error
+
+	// Close closes the store and release the resources that
+	// the store owns
+	Close()
+}
+
+// OutputCachePolicy tells how an action's output should be cached
+type OutputCachePolicy struct {
+	// TTL how long the output could be served from cache, the output is
+	// not cached if it's not positive
+	TTL time.Duration
+
+	// VaryByQuery query parameters that the output varies by
+	VaryByQuery []string
+
+	// VaryByHeader request headers that the output varies by
+	VaryByHeader []string
+
+	// VaryBySkin the output varies by the skin selected for the request
+	VaryBySkin bool
+
+	// VaryByUser the output varies by the authenticated user
+	VaryByUser bool
+
+	// Tags tags to be associated with the cached outputs, which
+	// could be used to invalidate the outputs by OutputCacheStore.RemoveByTag
+	Tags []string
+}
+
+type outputRecorder struct {
+	statusCode  int
+	wroteHeader bool
+	header      http.Header
+	body        *bytes.Buffer
+}
+
+func newOutputRecorder() *outputRecorder {
+	return &outputRecorder{
+		statusCode: http.StatusOK,
+		header:     make(http.Header),
+		body:       new(bytes.Buffer),
+	}
+}
+
+func (w *outputRecorder) Header() http.Header {
+	return w.header
+}
+
+func (w *outputRecorder) Write(data []byte) (int, error) {
+	w.wroteHeader = true
+	return w.body.Write(data)
+}
+
+func (w *outputRecorder) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.statusCode = statusCode
+		w.wroteHeader = true
+	}
+}
+
+func (w *outputRecorder) output() *CachedOutput {
+	return &CachedOutput{
+		StatusCode: w.statusCode,
+		Header:     w.header,
+		Body:       w.body.Bytes(),
+	}
+}
+
+// WriteTo writes the cached output to the given writer
+func (output *CachedOutput) WriteTo(writer http.ResponseWriter) {
+	for name, values := range output.Header {
+		writer.Header()[name] = values
+	}
+
+	writer.WriteHeader(output.StatusCode)
+	writer.Write(output.Body)
+}
+
+// OutputCacheKey the key of the given controller and action, all
+// outputs of the action are associated with this key as a tag, this
+// could be used to invalidate all outputs of an action by
+// OutputCacheStore.RemoveByTag
+func OutputCacheKey(controller, action string) string {
+	return controller + "/" + action
+}
+
+func (policy *OutputCachePolicy) cacheKey(baseKey string, request *http.Request, response *Response) string {
+	values := url.Values{}
+	query := request.URL.Query()
+	for _, name := range policy.VaryByQuery {
+		values["q:"+name] = query[name]
+	}
+
+	for _, name := range policy.VaryByHeader {
+		values["h:"+name] = request.Header[http.CanonicalHeaderKey(name)]
+	}
+
+	if policy.VaryBySkin {
+		values.Set("skin", response.skin)
+	}
+
+	if policy.VaryByUser {
+		if user := GetUser(request); user != nil {
+			values.Set("user", user.Domain+"\\"+user.ID)
+		}
+	}
+
+	return baseKey + "?" + values.Encode()
+}
+
+// WithOutputCache creates a ControllerOption that caches the outputs of the given
+// actions, or all actions of the controller if no action is given, into the store
+// based on the policy. Only successful GET and HEAD responses without cookies are
+// cached, and cached outputs are served before the action runs
+func WithOutputCache(store OutputCacheStore, policy *OutputCachePolicy, actions ...string) ControllerOption {
+	return ControllerOption(func(controller string, action Action) Action {
+		if !containsAction(actions, action.Name) {
+			return action
+		}
+
+		baseKey := OutputCacheKey(controller, action.Name)
+		tags := append([]string{baseKey}, policy.Tags...)
+		handler := action.Handler
+		action.Handler = ActionHandler(func(request *http.Request, response *Response) {
+			if request.Method != http.MethodGet && request.Method != http.MethodHead {
+				handler(request, response)
+				return
+			}
+
+			key := policy.cacheKey(baseKey, request, response)
+			output, err := store.Get(key)
+			if err == nil {
+				if isNotModified(request, output.Header) {
+					writeNotModified(response.writer, output.Header)
+				} else {
+					output.WriteTo(response.writer)
+				}
+
+				return
+			}
+
+			if err != ErrCacheMiss {
+				zap.L().Error("failedToGetCachedOutput", zap.Error(err), zap.String("key", key), zap.String("activityId", response.traceID))
+			}
+
+			recorder := newOutputRecorder()
+			recordedResponse := *response
+			recordedResponse.writer = recorder
+			handler(request, &recordedResponse)
+			recordedResponse.flush()
+			output = recorder.output()
+			if output.StatusCode == http.StatusOK && len(output.Header["Set-Cookie"]) == 0 {
+				err = store.Save(key, output, policy.TTL, tags)
+				if err != nil {
+					zap.L().Error("failedToSaveCachedOutput", zap.Error(err), zap.String("key", key), zap.String("activityId", response.traceID))
+				}
+			}
+
+			output.WriteTo(response.writer)
+		})
+
+		return action
+	})
+}
+
+func containsAction(actions []string, name string) bool {
+	if len(actions) == 0 {
+		return true
+	}
+
+	for _, action := range actions {
+		if action == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,158 @@
+package cypress
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type outputCacheItem struct {
+	output     *CachedOutput
+	expiration time.Time
+	tags       []string
+}
+
+type inMemoryOutputCacheStore struct {
+	items    map[string]*outputCacheItem
+	tags     map[string]map[string]bool
+	lock     *sync.RWMutex
+	gcTicker *time.Ticker
+	exitChan chan bool
+}
+
+// NewInMemoryOutputCacheStore creates an in memory output cache store
+func NewInMemoryOutputCacheStore() OutputCacheStore {
+	store := &inMemoryOutputCacheStore{
+		items:    make(map[string]*outputCacheItem),
+		tags:     make(map[string]map[string]bool),
+		lock:     new(sync.RWMutex),
+		gcTicker: time.NewTicker(5 * time.Minute),
+		exitChan: make(chan bool),
+	}
+
+	go func() {
+		for {
+			select {
+			case <-store.gcTicker.C:
+				store.doGC()
+				break
+			case <-store.exitChan:
+				return
+			}
+		}
+	}()
+
+	return store
+}
+
+// Close closes the output cache store
+func (store *inMemoryOutputCacheStore) Close() {
+	store.exitChan <- true
+	store.gcTicker.Stop()
+	close(store.exitChan)
+}
+
+// Get retrieves the cached output by key
+func (store *inMemoryOutputCacheStore) Get(key string) (*CachedOutput, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	item, ok := store.items[key]
+	if !ok || item.expiration.Before(time.Now()) {
+		return nil, ErrCacheMiss
+	}
+
+	return item.output, nil
+}
+
+// Save saves the output into store
+func (store *inMemoryOutputCacheStore) Save(key string, output *CachedOutput, ttl time.Duration, tags []string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.removeItem(key)
+	if ttl <= 0 {
+		return nil
+	}
+
+	store.items[key] = &outputCacheItem{
+		output:     output,
+		expiration: time.Now().Add(ttl),
+		tags:       tags,
+	}
+
+	for _, tag := range tags {
+		keys, ok := store.tags[tag]
+		if !ok {
+			keys = make(map[string]bool)
+			store.tags[tag] = keys
+		}
+
+		keys[key] = true
+	}
+
+	return nil
+}
+
+// Remove removes the cached output with the given key
+func (store *inMemoryOutputCacheStore) Remove(key string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.removeItem(key)
+	return nil
+}
+
+// RemoveByTag removes all cached outputs that are associated with the tag
+func (store *inMemoryOutputCacheStore) RemoveByTag(tag string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	for key := range store.tags[tag] {
+		store.removeItem(key)
+	}
+
+	return nil
+}
+
+// removeItem must be called with write lock held
+func (store *inMemoryOutputCacheStore) removeItem(key string) {
+	item, ok := store.items[key]
+	if !ok {
+		return
+	}
+
+	delete(store.items, key)
+	for _, tag := range item.tags {
+		keys, ok := store.tags[tag]
+		if ok {
+			delete(keys, key)
+			if len(keys) == 0 {
+				delete(store.tags, tag)
+			}
+		}
+	}
+}
+
+func (store *inMemoryOutputCacheStore) doGC() {
+	keysToRemove := make([]string, 0)
+	now := time.Now()
+	func() {
+		store.lock.RLock()
+		defer store.lock.RUnlock()
+		for key, value := range store.items {
+			if value.expiration.Before(now) {
+				keysToRemove = append(keysToRemove, key)
+			}
+		}
+	}()
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	for _, key := range keysToRemove {
+		// the item could be saved again after the scan
+		if item, ok := store.items[key]; !ok || item.expiration.After(now) {
+			continue
+		}
+
+		store.removeItem(key)
+		zap.L().Debug("cached output released by GC", zap.String("key", key))
+	}
+}
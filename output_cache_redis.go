@@ -0,0 +1,116 @@
+package cypress
+
+import (
+	"bytes"
+	"encoding/gob"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const (
+	redisOutputCacheKeyPrefix = "cypress$output$"
+	redisOutputCacheTagPrefix = "cypress$output$tag$"
+)
+
+// redisTagScript adds the key to the tag set and extends the ttl of the set, the ttl
+// is never shortened, so that the set outlives all the outputs in it
+var redisTagScript = redis.NewScript(`
+redis.call("SADD", KEYS[1], ARGV[1])
+if redis.call("PTTL", KEYS[1]) < tonumber(ARGV[2]) then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 1
+`)
+
+type redisOutputCacheStore struct {
+	redisDb *redis.Client
+}
+
+// NewRedisOutputCacheStore creates a new redis based output cache store
+func NewRedisOutputCacheStore(cli *redis.Client) OutputCacheStore {
+	return &redisOutputCacheStore{cli}
+}
+
+// Close closes the store
+func (store *redisOutputCacheStore) Close() {
+	store.redisDb.Close()
+}
+
+// Get implements OutputCacheStore's Get api, retrieves cached output from redis by given key
+func (store *redisOutputCacheStore) Get(key string) (*CachedOutput, error) {
+	status := store.redisDb.Get(redisOutputCacheKeyPrefix + key)
+	if status.Err() != nil {
+		if status.Err() == redis.Nil {
+			return nil, ErrCacheMiss
+		}
+
+		return nil, status.Err()
+	}
+
+	data, err := status.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	output := &CachedOutput{}
+	err = gob.NewDecoder(bytes.NewBuffer(data)).Decode(output)
+	if err != nil {
+		return nil, err
+	}
+
+	return output, nil
+}
+
+// Save implements OutputCacheStore's Save api, stores the output into redis and
+// adds the key to the sets of the tags
+func (store *redisOutputCacheStore) Save(key string, output *CachedOutput, ttl time.Duration, tags []string) error {
+	if ttl <= 0 {
+		// redis keeps the key forever with zero ttl, while the output must not be cached
+		return store.Remove(key)
+	}
+
+	buf := new(bytes.Buffer)
+	err := gob.NewEncoder(buf).Encode(output)
+	if err != nil {
+		return err
+	}
+
+	err = store.redisDb.Set(redisOutputCacheKeyPrefix+key, buf.Bytes(), ttl).Err()
+	if err != nil {
+		return err
+	}
+
+	for _, tag := range tags {
+		tagKey := redisOutputCacheTagPrefix + tag
+		// the tag set lives as long as the longest lived output saved with it
+		err = redisTagScript.Run(store.redisDb, []string{tagKey}, key, int64(ttl/time.Millisecond)).Err()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Remove implements OutputCacheStore's Remove api
+func (store *redisOutputCacheStore) Remove(key string) error {
+	return store.redisDb.Del(redisOutputCacheKeyPrefix + key).Err()
+}
+
+// RemoveByTag implements OutputCacheStore's RemoveByTag api, removes all outputs
+// in the tag's set and the set itself
+func (store *redisOutputCacheStore) RemoveByTag(tag string) error {
+	tagKey := redisOutputCacheTagPrefix + tag
+	keys, err := store.redisDb.SMembers(tagKey).Result()
+	if err != nil {
+		return err
+	}
+
+	for i, key := range keys {
+		keys[i] = redisOutputCacheKeyPrefix + key
+	}
+
+	keys = append(keys, tagKey)
+	return store.redisDb.Del(keys...).Err()
+}
@@ -0,0 +1,134 @@
+package cypress
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+func TestInMemoryOutputCacheStore(t *testing.T) {
+	store := NewInMemoryOutputCacheStore()
+	defer store.Close()
+	testOutputCacheStore(store, t)
+}
+
+// TestRedisOutputCacheStore test redis store, enable this by change first character to upper case
+// however, please make sure redis server is started without any password and default port before
+// you run the test case
+func testRedisOutputCacheStore(t *testing.T) {
+	redisdb := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       0,  // use default DB
+	})
+
+	store := NewRedisOutputCacheStore(redisdb)
+	defer store.Close()
+	testOutputCacheStore(store, t)
+}
+
+func testOutputCacheStore(store OutputCacheStore, t *testing.T) {
+	output := &CachedOutput{http.StatusOK, make(http.Header), []byte("content")}
+	err := store.Save("key2", output, time.Minute, []string{"tag1", "tag2"})
+	if err != nil {
+		t.Error("failed to save output", err)
+		return
+	}
+
+	// a short lived output must not shorten the life of the tag shared with key2
+	store.Save("key1", output, time.Millisecond*50, []string{"tag1"})
+	store.Save("key3", output, time.Minute, []string{"tag2"})
+	if _, err = store.Get("key1"); err != nil {
+		t.Error("key1 must exist", err)
+		return
+	}
+
+	time.Sleep(time.Millisecond * 51)
+	if _, err = store.Get("key1"); err != ErrCacheMiss {
+		t.Error("key1 must be expired")
+		return
+	}
+
+	store.RemoveByTag("tag1")
+	if _, err = store.Get("key2"); err != ErrCacheMiss {
+		t.Error("key2 must be removed by tag1")
+		return
+	}
+
+	if _, err = store.Get("key3"); err != nil {
+		t.Error("key3 must exist", err)
+		return
+	}
+
+	store.Remove("key3")
+	if _, err = store.Get("key3"); err != ErrCacheMiss {
+		t.Error("key3 must be removed")
+		return
+	}
+
+	store.Save("key4", output, time.Minute, []string{"tag3"})
+	if err = store.Save("key4", output, 0, []string{"tag3"}); err != nil {
+		t.Error("failed to save output with zero ttl", err)
+		return
+	}
+
+	if _, err = store.Get("key4"); err != ErrCacheMiss {
+		t.Error("output with zero ttl must not be cached")
+		return
+	}
+}
+
+func TestOutputCache(t *testing.T) {
+	store := NewInMemoryOutputCacheStore()
+	defer store.Close()
+
+	calls := 0
+	action := Action{
+		Name: "index",
+		Handler: ActionHandler(func(request *http.Request, response *Response) {
+			calls++
+			response.DoneWithContent(http.StatusOK, "text/plain", []byte("page "+request.URL.Query().Get("page")))
+		}),
+	}
+
+	option := WithOutputCache(store, &OutputCachePolicy{TTL: time.Minute, VaryByQuery: []string{"page"}, Tags: []string{"pages"}})
+	action = option("test", action)
+	serve := func(url string) string {
+		writer := httptest.NewRecorder()
+		action.Handler(httptest.NewRequest(http.MethodGet, url, nil), &Response{writer: writer})
+		return writer.Body.String()
+	}
+
+	if body := serve("/web/test/index?page=1"); body != "page 1" {
+		t.Error("unexpected response", body)
+		return
+	}
+
+	if body := serve("/web/test/index?page=1&other=1"); body != "page 1" || calls != 1 {
+		t.Error("expecting cached response but got", body, calls)
+		return
+	}
+
+	if body := serve("/web/test/index?page=2"); body != "page 2" || calls != 2 {
+		t.Error("expecting page 2 to be rendered but got", body, calls)
+		return
+	}
+
+	store.RemoveByTag(OutputCacheKey("test", "index"))
+	serve("/web/test/index?page=1")
+	serve("/web/test/index?page=2")
+	if calls != 4 {
+		t.Error("expecting all outputs to be invalidated but got", calls)
+		return
+	}
+
+	store.RemoveByTag("pages")
+	serve("/web/test/index?page=1")
+	if calls != 5 {
+		t.Error("expecting outputs to be invalidated by tag but got", calls)
+		return
+	}
+}
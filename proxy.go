@@ -0,0 +1,100 @@
+package cypress
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+var (
+	// ActivityIDHeader http header name for forwarding the activity id to upstream services
+	ActivityIDHeader = http.CanonicalHeaderKey("x-activity-id")
+
+	// UserIDHeader http header name for forwarding the user id to upstream services
+	UserIDHeader = http.CanonicalHeaderKey("x-cypress-user-id")
+
+	// UserDomainHeader http header name for forwarding the user domain to upstream services
+	UserDomainHeader = http.CanonicalHeaderKey("x-cypress-user-domain")
+
+	// UserNameHeader http header name for forwarding the user name to upstream services
+	UserNameHeader = http.CanonicalHeaderKey("x-cypress-user-name")
+
+	// UserRolesHeader http header name for forwarding the comma separated user roles to upstream services
+	UserRolesHeader = http.CanonicalHeaderKey("x-cypress-user-roles")
+
+	// BadGatewayMsg message to be shown when the upstream service is not available
+	BadGatewayMsg = "Sorry, the service you requested is temporarily unavailable"
+)
+
+// NewReverseProxy creates a reverse proxy to the target, the trace activity id, correlation id
+// and the identity of the authenticated user are forwarded to the target as headers, while the
+// identity headers from the client are always removed. The credentials of the web server, which
+// are the Authorization header, the session cookie and the remember-me cookie, are not forwarded
+// to the target either. WebSocket upgrades are proxied as well.
+func NewReverseProxy(target *url.URL) http.Handler {
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	director := proxy.Director
+	proxy.Director = func(request *http.Request) {
+		director(request)
+		header := request.Header
+		header.Del(UserIDHeader)
+		header.Del(UserDomainHeader)
+		header.Del(UserNameHeader)
+		header.Del(UserRolesHeader)
+		header.Del("Authorization")
+		removeCredentialCookies(request)
+		if traceID := GetTraceID(request.Context()); traceID != "" {
+			header.Set(ActivityIDHeader, traceID)
+		}
+
+		if correlationID := GetCorrelationID(request.Context()); correlationID != "" {
+			header.Set(CorrelationIDHeader, correlationID)
+		}
+
+		if user := GetUser(request); user != nil {
+			header.Set(UserIDHeader, user.ID)
+			header.Set(UserDomainHeader, user.Domain)
+			header.Set(UserNameHeader, user.Name)
+			header.Set(UserRolesHeader, strings.Join(user.Roles, ","))
+		}
+	}
+
+	proxy.ErrorHandler = func(writer http.ResponseWriter, request *http.Request, err error) {
+		zap.L().Error("failedToProxyRequest", zap.Error(err), zap.String("target", target.String()), zap.String("activityId", GetTraceID(request.Context())))
+		SendError(writer, http.StatusBadGateway, BadGatewayMsg)
+	}
+
+	return proxy
+}
+
+// removeCredentialCookies removes the session cookie and the remember-me cookie from the request
+func removeCredentialCookies(request *http.Request) {
+	cookies := request.Cookies()
+	request.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		if cookie.Name != sessionIDCookieKey && cookie.Name != RememberMeCookieName {
+			request.AddCookie(cookie)
+		}
+	}
+}
+
+// Mount mounts the handler at the given prefix, the handler is executed after the security
+// handler and custom handlers, and the prefix is removed from the request path before the
+// request is passed to the handler, a prefix without the trailing slash matches the prefix
+// itself and the paths under it, e.g. /api matches /api and /api/items but not /apifoo
+func (server *WebServer) Mount(prefix string, handler http.Handler) *WebServer {
+	prefix = strings.TrimSuffix(prefix, "/")
+	handler = http.StripPrefix(prefix, handler)
+	server.router.Path(prefix).Handler(handler)
+	server.router.PathPrefix(prefix + "/").Handler(handler)
+	return server
+}
+
+// AddReverseProxy mounts a reverse proxy to the target at the given prefix, see NewReverseProxy
+// for the headers that are forwarded to the target
+func (server *WebServer) AddReverseProxy(prefix string, target *url.URL) *WebServer {
+	return server.Mount(prefix, NewReverseProxy(target))
+}
@@ -0,0 +1,133 @@
+package cypress
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestReverseProxy(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path == "/ws" {
+			conn, err := (&websocket.Upgrader{}).Upgrade(writer, request, nil)
+			if err != nil {
+				return
+			}
+
+			defer conn.Close()
+			msgType, msg, err := conn.ReadMessage()
+			if err == nil {
+				conn.WriteMessage(msgType, msg)
+			}
+
+			return
+		}
+
+		json.NewEncoder(writer).Encode(map[string]string{
+			"path":          request.URL.Path,
+			"userId":        request.Header.Get(UserIDHeader),
+			"userRoles":     request.Header.Get(UserRolesHeader),
+			"correlationId": request.Header.Get(CorrelationIDHeader),
+			"activityId":    request.Header.Get(ActivityIDHeader),
+			"authorization": request.Header.Get("Authorization"),
+			"cookie":        request.Header.Get("Cookie"),
+		})
+	}))
+	defer upstream.Close()
+
+	target, _ := url.Parse(upstream.URL)
+	server := NewWebServer(":8099", nil)
+	server.AddReverseProxy("/upstream/", target)
+	server.AddReverseProxy("/api", target)
+	front := httptest.NewServer(LoggingHandler(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Query().Get("ticket") != "" {
+			request.Context().(*multiValueCtx).withValue(UserPrincipalKey, &UserPrincipal{
+				ID:    request.URL.Query().Get("ticket"),
+				Roles: []string{"admin", "user"},
+			})
+		}
+
+		server.router.ServeHTTP(writer, request)
+	})))
+	defer front.Close()
+
+	request, _ := http.NewRequest(http.MethodGet, front.URL+"/upstream/api/items?ticket=test", nil)
+	request.Header.Set(CorrelationIDHeader, "correlation1")
+	request.Header.Set(UserIDHeader, "spoofed")
+	request.Header.Set("Authorization", "Bearer secret")
+	request.AddCookie(&http.Cookie{Name: sessionIDCookieKey, Value: "session"})
+	request.AddCookie(&http.Cookie{Name: RememberMeCookieName, Value: "remember"})
+	request.AddCookie(&http.Cookie{Name: "theme", Value: "dark"})
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Error("failed to send request through proxy", err)
+		return
+	}
+
+	defer resp.Body.Close()
+	result := make(map[string]string)
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		t.Error("bad response from proxy", err)
+		return
+	}
+
+	if result["path"] != "/api/items" || result["userId"] != "test" || result["userRoles"] != "admin,user" {
+		t.Error("unexpected path or identity forwarded", result)
+		return
+	}
+
+	if result["correlationId"] != "correlation1" || result["activityId"] == "" {
+		t.Error("trace ids are not forwarded", result)
+		return
+	}
+
+	if result["authorization"] != "" || result["cookie"] != "theme=dark" {
+		t.Error("credentials of the web server must not be forwarded", result)
+		return
+	}
+
+	for path, status := range map[string]int{"/api": http.StatusOK, "/api/items": http.StatusOK, "/apifoo": http.StatusNotFound} {
+		resp, err = http.Get(front.URL + path)
+		if err != nil || resp.StatusCode != status {
+			t.Error("expecting", status, "for", path, "but got", resp, err)
+			return
+		}
+
+		resp.Body.Close()
+	}
+
+	request, _ = http.NewRequest(http.MethodGet, front.URL+"/upstream/api/items", nil)
+	request.Header.Set(UserIDHeader, "spoofed")
+	resp, err = http.DefaultClient.Do(request)
+	if err != nil {
+		t.Error("failed to send request through proxy", err)
+		return
+	}
+
+	defer resp.Body.Close()
+	result = make(map[string]string)
+	json.NewDecoder(resp.Body).Decode(&result)
+	if result["userId"] != "" {
+		t.Error("identity headers from client must be removed", result)
+		return
+	}
+
+	c, _, err := websocket.DefaultDialer.Dial(strings.Replace(front.URL, "http", "ws", 1)+"/upstream/ws", nil)
+	if err != nil {
+		t.Error("failed to dial websocket through proxy", err)
+		return
+	}
+
+	defer c.Close()
+	c.WriteMessage(websocket.TextMessage, []byte("Hello, proxy!"))
+	msgType, msg, err := c.ReadMessage()
+	if msgType != websocket.TextMessage || err != nil || string(msg) != "Hello, proxy!" {
+		t.Error("failed to read back the message through proxy", err)
+	}
+}
@@ -0,0 +1,280 @@
+package cypress
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrBadPathPattern the path pattern is malformed
+	ErrBadPathPattern = errors.New("bad path pattern")
+)
+
+// AccessRule a rule for RoleBasedAuthz. Path is a pattern where * matches any characters
+// in a path segment, ** matches any path segments and {name} or {name:regexp} matches a
+// path segment like mux variables. Methods are the http methods the rule applies to, or
+// all methods if empty. An allow rule applies to everyone if Anonymous is set, or the
+// users with any of the Roles, or any authenticated user if Roles is empty. A deny rule
+// applies to the users with any of the Roles, or everyone if Roles is empty
+type AccessRule struct {
+	Path      string   `json:"path"`
+	Methods   []string `json:"methods,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+	Anonymous bool     `json:"anonymous,omitempty"`
+	Deny      bool     `json:"deny,omitempty"`
+	matcher   *regexp.Regexp
+}
+
+// AccessDecision the result of an access evaluation, Rule is nil if no rule applies
+type AccessDecision struct {
+	Allowed bool
+	Index   int
+	Rule    *AccessRule
+}
+
+// RoleBasedAuthz an AuthorizationManager evaluates ordered rules, the first rule that
+// applies to the request decides the access, and access is denied if no rule applies.
+// Roles could inherit other roles, e.g. admin inherits editor, so that the rules for
+// editor apply to admin too
+type RoleBasedAuthz struct {
+	lock    *sync.RWMutex
+	rules   []*AccessRule
+	parents map[string][]string
+}
+
+// RoleBasedAuthzConfig the configuration of RoleBasedAuthz, Roles maps a role to the
+// roles it inherits
+type RoleBasedAuthzConfig struct {
+	Roles map[string][]string `json:"roles"`
+	Rules []*AccessRule       `json:"rules"`
+}
+
+// String returns the explanation of the decision
+func (decision *AccessDecision) String() string {
+	result := "denied"
+	if decision.Allowed {
+		result = "allowed"
+	}
+
+	if decision.Rule == nil {
+		return result + " as no rule applies"
+	}
+
+	kind := "allow"
+	if decision.Rule.Deny {
+		kind = "deny"
+	}
+
+	return fmt.Sprintf("%s by %s rule #%d %s %v roles %v anonymous %v", result, kind, decision.Index, decision.Rule.Path, decision.Rule.Methods, decision.Rule.Roles, decision.Rule.Anonymous)
+}
+
+// compilePathPattern compiles the path pattern of AccessRule into a regular expression
+func compilePathPattern(pattern string) (*regexp.Regexp, error) {
+	var builder strings.Builder
+	builder.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				// a trailing /** matches the parent path as well
+				if i > 0 && pattern[i-1] == '/' && i+2 == len(pattern) {
+					result := builder.String()
+					builder.Reset()
+					builder.WriteString(result[:len(result)-1] + "(?:/.*)?")
+				} else {
+					builder.WriteString(".*")
+				}
+
+				i++
+			} else {
+				builder.WriteString("[^/]*")
+			}
+		case '{':
+			depth, end := 1, i+1
+			for ; end < len(pattern) && depth > 0; end++ {
+				if pattern[end] == '{' {
+					depth++
+				} else if pattern[end] == '}' {
+					depth--
+				}
+			}
+
+			if depth > 0 {
+				return nil, ErrBadPathPattern
+			}
+
+			variable := pattern[i+1 : end-1]
+			if index := strings.Index(variable, ":"); index >= 0 {
+				builder.WriteString("(?:" + variable[index+1:] + ")")
+			} else {
+				builder.WriteString("[^/]+")
+			}
+
+			i = end - 1
+		default:
+			builder.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	builder.WriteString("$")
+	matcher, err := regexp.Compile(builder.String())
+	if err != nil {
+		return nil, ErrBadPathPattern
+	}
+
+	return matcher, nil
+}
+
+// NewRoleBasedAuthz creates a RoleBasedAuthz without rules, which denies all requests
+func NewRoleBasedAuthz() *RoleBasedAuthz {
+	return &RoleBasedAuthz{
+		lock:    &sync.RWMutex{},
+		rules:   make([]*AccessRule, 0, 10),
+		parents: make(map[string][]string),
+	}
+}
+
+// NewRoleBasedAuthzFromConfig creates a RoleBasedAuthz with the configuration
+func NewRoleBasedAuthzFromConfig(config *RoleBasedAuthzConfig) (*RoleBasedAuthz, error) {
+	authz := NewRoleBasedAuthz()
+	for role, inherits := range config.Roles {
+		authz.WithRoleHierarchy(role, inherits...)
+	}
+
+	for _, rule := range config.Rules {
+		if err := authz.AddRule(rule); err != nil {
+			return nil, err
+		}
+	}
+
+	return authz, nil
+}
+
+// LoadRoleBasedAuthz loads the RoleBasedAuthz from a json file of RoleBasedAuthzConfig
+func LoadRoleBasedAuthz(file string) (*RoleBasedAuthz, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &RoleBasedAuthzConfig{}
+	if err = json.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+
+	return NewRoleBasedAuthzFromConfig(config)
+}
+
+// WithRoleHierarchy makes the role inherit the permissions of the other roles
+func (authz *RoleBasedAuthz) WithRoleHierarchy(role string, inherits ...string) *RoleBasedAuthz {
+	authz.lock.Lock()
+	defer authz.lock.Unlock()
+	authz.parents[role] = append(authz.parents[role], inherits...)
+	return authz
+}
+
+// AddRule appends the rule to the rules
+func (authz *RoleBasedAuthz) AddRule(rule *AccessRule) error {
+	matcher, err := compilePathPattern(rule.Path)
+	if err != nil {
+		return err
+	}
+
+	rule.matcher = matcher
+	authz.lock.Lock()
+	defer authz.lock.Unlock()
+	authz.rules = append(authz.rules, rule)
+	return nil
+}
+
+// expandRoles returns the roles and all the roles inherited by them
+func (authz *RoleBasedAuthz) expandRoles(roles []string) map[string]bool {
+	result := make(map[string]bool)
+	pending := append([]string{}, roles...)
+	for len(pending) > 0 {
+		role := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if !result[role] {
+			result[role] = true
+			pending = append(pending, authz.parents[role]...)
+		}
+	}
+
+	return result
+}
+
+func (rule *AccessRule) matches(method, path string) bool {
+	if len(rule.Methods) > 0 {
+		found := false
+		for _, m := range rule.Methods {
+			if strings.EqualFold(m, method) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return rule.matcher.MatchString(path)
+}
+
+// appliesTo checks if the rule applies to the user with the roles, user is nil for
+// anonymous users
+func (rule *AccessRule) appliesTo(user *UserPrincipal, roles map[string]bool) bool {
+	if rule.Anonymous {
+		return true
+	}
+
+	if len(rule.Roles) == 0 {
+		return rule.Deny || user != nil
+	}
+
+	for _, role := range rule.Roles {
+		if roles[role] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Evaluate evaluates the access of the user to the path with the method, user is nil
+// for anonymous users
+func (authz *RoleBasedAuthz) Evaluate(user *UserPrincipal, method, path string) *AccessDecision {
+	authz.lock.RLock()
+	defer authz.lock.RUnlock()
+	var roles map[string]bool
+	if user != nil {
+		roles = authz.expandRoles(user.Roles)
+	}
+
+	for index, rule := range authz.rules {
+		if rule.matches(method, path) && rule.appliesTo(user, roles) {
+			return &AccessDecision{!rule.Deny, index, rule}
+		}
+	}
+
+	return &AccessDecision{false, -1, nil}
+}
+
+// CheckAccess implements AuthorizationManager
+func (authz *RoleBasedAuthz) CheckAccess(user *UserPrincipal, method, path string) bool {
+	decision := authz.Evaluate(user, method, path)
+	zap.L().Debug("checkAccess", zap.String("user", user.ID), zap.String("method", method), zap.String("path", path), zap.Stringer("decision", decision))
+	return decision.Allowed
+}
+
+// CheckAnonymousAccessible implements AuthorizationManager
+func (authz *RoleBasedAuthz) CheckAnonymousAccessible(method, path string) bool {
+	return authz.Evaluate(nil, method, path).Allowed
+}
@@ -0,0 +1,126 @@
+package cypress
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestCompilePathPattern(t *testing.T) {
+	cases := []struct {
+		pattern string
+		path    string
+		match   bool
+	}{
+		{"/api/**", "/api", true},
+		{"/api/**", "/api/v1/users", true},
+		{"/api/**", "/apis", false},
+		{"/static/*.css", "/static/site.css", true},
+		{"/static/*.css", "/static/css/site.css", false},
+		{"/users/{id}", "/users/1001", true},
+		{"/users/{id}", "/users/", false},
+		{"/users/{id:[0-9]{4}}/profile", "/users/1001/profile", true},
+		{"/users/{id:[0-9]{4}}/profile", "/users/abcd/profile", false},
+		{"/**/edit", "/docs/a/edit", true},
+		{"/a.b", "/axb", false},
+	}
+
+	for _, c := range cases {
+		matcher, err := compilePathPattern(c.pattern)
+		if err != nil {
+			t.Error("failed to compile", c.pattern, err)
+			return
+		}
+
+		if matcher.MatchString(c.path) != c.match {
+			t.Error("unexpected match result for", c.pattern, c.path)
+			return
+		}
+	}
+
+	if _, err := compilePathPattern("/users/{id"); err != ErrBadPathPattern {
+		t.Error("expecting bad pattern error")
+	}
+}
+
+func TestRoleBasedAuthz(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "cyrbactest")
+	if err != nil {
+		t.Error("failed to create test dir", err)
+		return
+	}
+
+	defer os.RemoveAll(testDir)
+	config := `{
+		"roles": {"admin": ["editor"], "editor": ["viewer"]},
+		"rules": [
+			{"path": "/login", "anonymous": true},
+			{"path": "/docs/{id}/publish", "roles": ["intern"], "deny": true},
+			{"path": "/docs/**", "methods": ["GET"], "roles": ["viewer"]},
+			{"path": "/docs/**", "methods": ["POST", "PUT"], "roles": ["editor"]},
+			{"path": "/admin/**", "roles": ["admin"]},
+			{"path": "/internal/**", "deny": true},
+			{"path": "/profile"}
+		]
+	}`
+	if err = ioutil.WriteFile(path.Join(testDir, "authz.json"), []byte(config), os.ModePerm); err != nil {
+		t.Error("failed to write config", err)
+		return
+	}
+
+	authz, err := LoadRoleBasedAuthz(path.Join(testDir, "authz.json"))
+	if err != nil {
+		t.Error("failed to load authz", err)
+		return
+	}
+
+	admin := &UserPrincipal{ID: "admin", Roles: []string{"admin"}}
+	viewer := &UserPrincipal{ID: "viewer", Roles: []string{"viewer"}}
+	intern := &UserPrincipal{ID: "intern", Roles: []string{"intern", "editor"}}
+	cases := []struct {
+		user    *UserPrincipal
+		method  string
+		path    string
+		allowed bool
+	}{
+		{nil, http.MethodPost, "/login", true},
+		{nil, http.MethodGet, "/docs/1", false},
+		{nil, http.MethodGet, "/profile", false},
+		{viewer, http.MethodGet, "/docs/1", true},
+		{viewer, http.MethodPost, "/docs/1", false},
+		{admin, http.MethodPost, "/docs/1", true},
+		{admin, http.MethodGet, "/admin/users", true},
+		{viewer, http.MethodGet, "/admin", false},
+		{intern, http.MethodPost, "/docs/1/publish", false},
+		{admin, http.MethodPost, "/docs/1/publish", true},
+		{admin, http.MethodGet, "/internal/stats", false},
+		{viewer, http.MethodGet, "/profile", true},
+	}
+
+	for _, c := range cases {
+		var allowed bool
+		if c.user == nil {
+			allowed = authz.CheckAnonymousAccessible(c.method, c.path)
+		} else {
+			allowed = authz.CheckAccess(c.user, c.method, c.path)
+		}
+
+		if allowed != c.allowed {
+			t.Error("unexpected access for", c.user, c.method, c.path, authz.Evaluate(c.user, c.method, c.path))
+			return
+		}
+	}
+
+	decision := authz.Evaluate(intern, http.MethodPost, "/docs/1/publish")
+	if decision.Index != 1 || !strings.HasPrefix(decision.String(), "denied by deny rule #1 /docs/{id}/publish") {
+		t.Error("unexpected explanation", decision)
+		return
+	}
+
+	if decision = NewRoleBasedAuthz().Evaluate(admin, http.MethodGet, "/"); decision.Allowed || decision.String() != "denied as no rule applies" {
+		t.Error("expecting access to be denied without rules", decision)
+	}
+}
@@ -0,0 +1,494 @@
+package cypress
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// RedactedValue the value that replaces the sensitive data in recordings
+	RedactedValue = "[REDACTED]"
+)
+
+var (
+	// SensitiveHeaders http headers that are redacted in recordings
+	SensitiveHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "Proxy-Authorization", "X-Api-Key"}
+
+	// SensitiveFields json and form fields that are redacted in recordings, a field is
+	// sensitive if its name contains any of these, case insensitive
+	SensitiveFields = []string{"password", "passwd", "secret", "token", "apikey", "api_key"}
+
+	// SensitiveQueryParams query parameters that are redacted in recordings besides the
+	// sensitive fields and headers, e.g. the OAuth authorization code and state
+	SensitiveQueryParams = []string{"code", "state", "nonce"}
+)
+
+// RecordedRequest a recorded request and its response
+type RecordedRequest struct {
+	ActivityID        string      `json:"activityId"`
+	Time              time.Time   `json:"time"`
+	Method            string      `json:"method"`
+	URL               string      `json:"url"`
+	Host              string      `json:"host"`
+	RemoteAddr        string      `json:"remoteAddr"`
+	User              string      `json:"user,omitempty"`
+	Header            http.Header `json:"header"`
+	Body              []byte      `json:"body,omitempty"`
+	BodyTruncated     bool        `json:"bodyTruncated,omitempty"`
+	StatusCode        int         `json:"statusCode"`
+	ResponseHeader    http.Header `json:"responseHeader"`
+	ResponseBody      []byte      `json:"responseBody,omitempty"`
+	ResponseTruncated bool        `json:"responseTruncated,omitempty"`
+	Latency           int         `json:"latency"`
+}
+
+// Recorder records the sanitized requests and responses that match the rules, a request
+// is recorded if its path has one of the prefixes, or it's sent by one of the users, or
+// it's sampled. The rules could be changed at runtime
+type Recorder struct {
+	lock        *sync.RWMutex
+	writeLock   *sync.Mutex
+	writer      io.Writer
+	prefixes    []string
+	users       map[string]bool
+	sampleRate  float64
+	maxBodySize int
+}
+
+type recordingHandler struct {
+	recorder *Recorder
+	pipeline http.Handler
+}
+
+type recordingResponseWriter struct {
+	statusCode int
+	body       *bytes.Buffer
+	limit      int
+	truncated  bool
+	writer     http.ResponseWriter
+}
+
+type recordedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// NewRecorder creates a recorder that writes the recordings as json lines to the writer,
+// nothing is recorded until rules are added
+func NewRecorder(writer io.Writer) *Recorder {
+	return &Recorder{
+		lock:        &sync.RWMutex{},
+		writeLock:   &sync.Mutex{},
+		writer:      writer,
+		prefixes:    make([]string, 0, 4),
+		users:       make(map[string]bool),
+		maxBodySize: 64 * 1024,
+	}
+}
+
+// NewFileRecorder creates a recorder that writes the recordings to a rotating file, see
+// NewRollingLogWriter
+func NewFileRecorder(fileName string, maxSizeInMegaBytes, maxRotationFiles int) *Recorder {
+	return NewRecorder(NewRollingLogWriter(fileName, maxSizeInMegaBytes, maxRotationFiles))
+}
+
+// RecordPath records the requests whose paths have the prefix
+func (recorder *Recorder) RecordPath(prefix string) *Recorder {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	recorder.prefixes = append(recorder.prefixes, prefix)
+	return recorder
+}
+
+// RecordUser records the requests sent by the user, the user is only known if it's
+// resolved by the pipeline, e.g. the path is protected by the AuthorizationManager
+func (recorder *Recorder) RecordUser(userID string) *Recorder {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	recorder.users[userID] = true
+	return recorder
+}
+
+// WithSampleRate records the given ratio, from 0 to 1, of all requests
+func (recorder *Recorder) WithSampleRate(rate float64) *Recorder {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	recorder.sampleRate = rate
+	return recorder
+}
+
+// WithMaxBodySize sets the max size of the request and response bodies to be recorded,
+// the bodies are truncated if they are larger than that
+func (recorder *Recorder) WithMaxBodySize(size int) *Recorder {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	recorder.maxBodySize = size
+	return recorder
+}
+
+// Reset removes all rules, so that nothing is recorded
+func (recorder *Recorder) Reset() {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	recorder.prefixes = make([]string, 0, 4)
+	recorder.users = make(map[string]bool)
+	recorder.sampleRate = 0
+}
+
+// selects returns true if the request should be recorded without knowing the user,
+// and true for maybe if it should be recorded when it's sent by one of the users
+func (recorder *Recorder) selects(request *http.Request) (bool, bool) {
+	recorder.lock.RLock()
+	defer recorder.lock.RUnlock()
+	for _, prefix := range recorder.prefixes {
+		if strings.HasPrefix(request.URL.Path, prefix) {
+			return true, true
+		}
+	}
+
+	if recorder.sampleRate > 0 && rand.Float64() < recorder.sampleRate {
+		return true, true
+	}
+
+	return false, len(recorder.users) > 0
+}
+
+func (recorder *Recorder) hasUser(user *UserPrincipal) bool {
+	recorder.lock.RLock()
+	defer recorder.lock.RUnlock()
+	return user != nil && recorder.users[user.ID]
+}
+
+func (recorder *Recorder) write(record *RecordedRequest) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		zap.L().Error("failedToEncodeRecording", zap.Error(err), zap.String("activityId", record.ActivityID))
+		return
+	}
+
+	recorder.writeLock.Lock()
+	defer recorder.writeLock.Unlock()
+	_, err = recorder.writer.Write(append(data, '\n'))
+	if err != nil {
+		zap.L().Error("failedToWriteRecording", zap.Error(err), zap.String("activityId", record.ActivityID))
+	}
+}
+
+func (w *recordingResponseWriter) Header() http.Header {
+	return w.writer.Header()
+}
+
+func (w *recordingResponseWriter) Write(data []byte) (int, error) {
+	if remaining := w.limit - w.body.Len(); remaining < len(data) {
+		w.truncated = true
+		if remaining > 0 {
+			w.body.Write(data[:remaining])
+		}
+	} else {
+		w.body.Write(data)
+	}
+
+	return w.writer.Write(data)
+}
+
+func (w *recordingResponseWriter) WriteHeader(statusCode int) {
+	w.statusCode = statusCode
+	w.writer.WriteHeader(statusCode)
+}
+
+func (w *recordingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.writer.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the ResponseWriter doesn't support the Hijacker interface")
+	}
+
+	return hijacker.Hijack()
+}
+
+// ServeHTTP serves incoming http request
+func (handler *recordingHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	selected, maybe := handler.recorder.selects(request)
+	if !maybe {
+		handler.pipeline.ServeHTTP(writer, request)
+		return
+	}
+
+	handler.recorder.lock.RLock()
+	limit := handler.recorder.maxBodySize
+	handler.recorder.lock.RUnlock()
+
+	start := time.Now()
+	var body []byte
+	if request.Body != nil {
+		body, _ = ioutil.ReadAll(io.LimitReader(request.Body, int64(limit)+1))
+		request.Body = &recordedBody{io.MultiReader(bytes.NewReader(body), request.Body), request.Body}
+	}
+
+	header := sanitizeHeader(request.Header)
+	recordingWriter := &recordingResponseWriter{
+		statusCode: http.StatusOK,
+		body:       new(bytes.Buffer),
+		limit:      limit,
+		writer:     writer,
+	}
+
+	handler.pipeline.ServeHTTP(recordingWriter, request)
+	user := GetUser(request)
+	if !selected && !handler.recorder.hasUser(user) {
+		return
+	}
+
+	record := &RecordedRequest{
+		ActivityID:        GetTraceID(request.Context()),
+		Time:              start,
+		Method:            request.Method,
+		URL:               sanitizeURL(request.URL),
+		Host:              request.Host,
+		RemoteAddr:        request.RemoteAddr,
+		Header:            header,
+		BodyTruncated:     len(body) > limit,
+		StatusCode:        recordingWriter.statusCode,
+		ResponseHeader:    sanitizeHeader(writer.Header()),
+		ResponseTruncated: recordingWriter.truncated,
+		Latency:           int(time.Since(start).Seconds() * 1000),
+	}
+
+	if user != nil {
+		record.User = user.ID
+	}
+
+	if record.BodyTruncated {
+		body = body[:limit]
+	}
+
+	record.Body = sanitizeBody(request.Header.Get("Content-Type"), body, record.BodyTruncated)
+	record.ResponseBody = sanitizeBody(writer.Header().Get("Content-Type"), recordingWriter.body.Bytes(), record.ResponseTruncated)
+	handler.recorder.write(record)
+}
+
+// NewRecordingHandler creates a handler that records the requests selected by the recorder,
+// the handler must be placed after LoggingHandler to have the activity ids
+func NewRecordingHandler(pipeline http.Handler, recorder *Recorder) http.Handler {
+	return &recordingHandler{recorder, pipeline}
+}
+
+func isSensitiveField(name string) bool {
+	name = strings.ToLower(name)
+	for _, field := range SensitiveFields {
+		if strings.Contains(name, field) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func sanitizeHeader(header http.Header) http.Header {
+	result := make(http.Header)
+	for name, values := range header {
+		result[name] = values
+	}
+
+	for _, name := range SensitiveHeaders {
+		if _, ok := result[http.CanonicalHeaderKey(name)]; ok {
+			result[http.CanonicalHeaderKey(name)] = []string{RedactedValue}
+		}
+	}
+
+	return result
+}
+
+// sanitizeURL redacts the sensitive query parameters, which are the sensitive fields,
+// the sensitive headers and the SensitiveQueryParams
+func sanitizeURL(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.RequestURI()
+	}
+
+	values, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return u.EscapedPath() + "?" + RedactedValue
+	}
+
+	for key := range values {
+		if isSensitiveField(key) || isSensitiveQueryParam(key) {
+			values[key] = []string{RedactedValue}
+		}
+	}
+
+	copied := *u
+	copied.RawQuery = values.Encode()
+	return copied.RequestURI()
+}
+
+func isSensitiveQueryParam(name string) bool {
+	for _, param := range SensitiveQueryParams {
+		if strings.EqualFold(param, name) {
+			return true
+		}
+	}
+
+	for _, header := range SensitiveHeaders {
+		if strings.EqualFold(header, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func sanitizeJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if isSensitiveField(key) {
+				v[key] = RedactedValue
+			} else {
+				v[key] = sanitizeJSON(item)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = sanitizeJSON(item)
+		}
+	}
+
+	return value
+}
+
+// sanitizeBody redacts the sensitive fields of json and form bodies, the body is
+// fully redacted if it cannot be parsed, e.g. it's truncated
+func sanitizeBody(contentType string, body []byte, truncated bool) []byte {
+	if len(body) == 0 {
+		return body
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		var value interface{}
+		if truncated || json.Unmarshal(body, &value) != nil {
+			return []byte(RedactedValue)
+		}
+
+		data, err := json.Marshal(sanitizeJSON(value))
+		if err != nil {
+			return []byte(RedactedValue)
+		}
+
+		return data
+	case mediaType == "application/x-www-form-urlencoded":
+		values, err := url.ParseQuery(string(body))
+		if truncated || err != nil {
+			return []byte(RedactedValue)
+		}
+
+		for key := range values {
+			if isSensitiveField(key) {
+				values[key] = []string{RedactedValue}
+			}
+		}
+
+		return []byte(values.Encode())
+	}
+
+	return body
+}
+
+// WithRecorder records the requests selected by the recorder
+func (server *WebServer) WithRecorder(recorder *Recorder) *WebServer {
+	server.recorder = recorder
+	return server
+}
+
+// LoadRecordings loads the recordings from the file written by a recorder
+func LoadRecordings(fileName string) ([]*RecordedRequest, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+	records := make([]*RecordedRequest, 0, 16)
+	decoder := json.NewDecoder(file)
+	for {
+		record := &RecordedRequest{}
+		err = decoder.Decode(record)
+		if err == io.EOF {
+			return records, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, record)
+	}
+}
+
+// FindRecording finds the recording with the activity id from the file written by a recorder
+func FindRecording(fileName, activityID string) (*RecordedRequest, error) {
+	records, err := LoadRecordings(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, record := range records {
+		if record.ActivityID == activityID {
+			return record, nil
+		}
+	}
+
+	return nil, os.ErrNotExist
+}
+
+// NewRequest creates a request from the recording, the redacted headers are not
+// set, the credentials could be given by updating the Header before calling this
+func (record *RecordedRequest) NewRequest() (*http.Request, error) {
+	request, err := http.NewRequest(record.Method, record.URL, bytes.NewReader(record.Body))
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range record.Header {
+		if len(values) == 1 && values[0] == RedactedValue {
+			continue
+		}
+
+		request.Header[name] = values
+	}
+
+	request.Host = record.Host
+	request.RemoteAddr = record.RemoteAddr
+	request.RequestURI = record.URL
+	return request, nil
+}
+
+// Replay serves the recorded request by the web server without a network listener,
+// and returns the output, this is for reproducing the issues with a local instance
+// that has the same setup as the one that recorded the request
+func (server *WebServer) Replay(record *RecordedRequest) (*CachedOutput, error) {
+	request, err := record.NewRequest()
+	if err != nil {
+		return nil, err
+	}
+
+	recorder := newOutputRecorder()
+	server.pipeline(false).ServeHTTP(recorder, request)
+	return recorder.output(), nil
+}
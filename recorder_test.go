@@ -0,0 +1,133 @@
+package cypress
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecorder(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "cyrecordertest")
+	if err != nil {
+		t.Error("failed to create test dir", err)
+		return
+	}
+
+	defer os.RemoveAll(testDir)
+	fileName := path.Join(testDir, "recordings.log")
+	recorder := NewFileRecorder(fileName, 10, 2).RecordPath("/api/").RecordUser("alice")
+	server := NewWebServer(":8099", nil)
+	server.WithSessionOptions(NewInMemorySessionStore(), time.Minute)
+	server.AddUserProvider(&TestUserProvider{})
+	authz := NewRoleBasedAuthz()
+	authz.AddRule(&AccessRule{Path: "/api/**", Anonymous: true})
+	authz.AddRule(&AccessRule{Path: "/web/**"})
+	server.WithAuthz(authz)
+	server.WithRecorder(recorder)
+	server.HandleFunc("/api/login", func(writer http.ResponseWriter, request *http.Request) {
+		body, _ := ioutil.ReadAll(request.Body)
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Header().Set("Set-Cookie", "token=abc")
+		writer.WriteHeader(http.StatusCreated)
+		writer.Write([]byte(`{"accessToken":"abc","echo":` + string(body) + `}`))
+	})
+	server.HandleFunc("/web/home", func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write([]byte("home"))
+	})
+
+	handler := server.pipeline(true)
+	serve := func(method, url, body string, header http.Header) *httptest.ResponseRecorder {
+		writer := httptest.NewRecorder()
+		request := httptest.NewRequest(method, url, strings.NewReader(body))
+		for name, values := range header {
+			request.Header[name] = values
+		}
+
+		handler.ServeHTTP(writer, request)
+		return writer
+	}
+
+	body := `{"user":"bob","password":"secret1","profile":{"apiKey":"k"}}`
+	header := http.Header{"Content-Type": []string{"application/json"}, "Authorization": []string{"Bearer xyz"}}
+	result := serve(http.MethodPost, "/api/login", body, header)
+	if result.Code != http.StatusCreated || !strings.Contains(result.Body.String(), "secret1") {
+		t.Error("the recorder must not change the request or response, but got", result.Code, result.Body.String())
+		return
+	}
+
+	serve(http.MethodGet, "/web/home", "", nil)
+	serve(http.MethodGet, "/web/home?ticket=alice", "", nil)
+	records, err := LoadRecordings(fileName)
+	if err != nil || len(records) != 2 {
+		t.Error("expecting two recordings but got", len(records), err)
+		return
+	}
+
+	record := records[0]
+	if record.ActivityID == "" || record.StatusCode != http.StatusCreated || record.Header.Get("Authorization") != RedactedValue || record.ResponseHeader.Get("Set-Cookie") != RedactedValue {
+		t.Error("unexpected recording", record)
+		return
+	}
+
+	recorded := bytes.Join([][]byte{record.Body, record.ResponseBody}, nil)
+	if bytes.Contains(recorded, []byte("secret1")) || bytes.Contains(recorded, []byte(`"abc"`)) || !bytes.Contains(record.Body, []byte("bob")) {
+		t.Error("sensitive fields must be redacted but got", string(recorded))
+		return
+	}
+
+	if records[1].User != "alice" || string(records[1].ResponseBody) != "home" {
+		t.Error("expecting the request from alice to be recorded but got", records[1].User)
+		return
+	}
+
+	if records[1].URL != "/web/home?ticket=alice" {
+		t.Error("expecting the query to be kept but got", records[1].URL)
+		return
+	}
+
+	found, err := FindRecording(fileName, record.ActivityID)
+	if err != nil || found.URL != "/api/login" {
+		t.Error("failed to find the recording", err)
+		return
+	}
+
+	output, err := server.Replay(found)
+	if err != nil {
+		t.Error("failed to replay the recording", err)
+		return
+	}
+
+	echo := make(map[string]interface{})
+	err = json.Unmarshal(output.Body, &echo)
+	if err != nil || output.StatusCode != http.StatusCreated || echo["echo"].(map[string]interface{})["password"] != RedactedValue {
+		t.Error("unexpected replay output", output.StatusCode, string(output.Body))
+		return
+	}
+
+	records, _ = LoadRecordings(fileName)
+	if len(records) != 2 {
+		t.Error("replayed requests must not be recorded")
+		return
+	}
+
+	serve(http.MethodGet, "/api/callback?code=c1&state=s1&api_key=k1&Authorization=a1&page=2", "", nil)
+	records, _ = LoadRecordings(fileName)
+	if len(records) != 3 || !strings.Contains(records[2].URL, "page=2") {
+		t.Error("expecting the callback to be recorded")
+		return
+	}
+
+	for _, value := range []string{"c1", "s1", "k1", "a1"} {
+		if strings.Contains(records[2].URL, value) {
+			t.Error("sensitive query parameters must be redacted but got", records[2].URL)
+			return
+		}
+	}
+}
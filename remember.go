@@ -0,0 +1,412 @@
+package cypress
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrRememberMeTokenNotFound the remember-me token is not found in the store
+	ErrRememberMeTokenNotFound = errors.New("remember-me token not found")
+
+	// RememberMeCookieName the cookie name for the remember-me token
+	RememberMeCookieName = "_CYPRESS_REMEMBER"
+
+	// LoginRememberMeField form field name to request a remember-me token on login
+	LoginRememberMeField = "rememberMe"
+
+	// RememberMeRotationGrace the period that the previous validator of a series is still
+	// accepted after rotation, for the concurrent requests sent with the previous cookie
+	RememberMeRotationGrace = 30 * time.Second
+)
+
+// RememberMeToken a persistent login token series, the token is in
+// "<series>.<validator>" form, the validator is rotated each time the
+// token is used, and only the hash of the validator is stored
+type RememberMeToken struct {
+	Series       string
+	Hash         string
+	PreviousHash string
+	RotatedAt    time.Time
+	Domain       string
+	UserID       string
+	ExpiresAt    time.Time
+}
+
+// RememberMeStore a store for remember-me tokens
+type RememberMeStore interface {
+	// Get gets the token by series, returns ErrRememberMeTokenNotFound if the series does not exist
+	Get(series string) (*RememberMeToken, error)
+
+	// Save saves the token
+	Save(token *RememberMeToken) error
+
+	// Rotate saves the rotated token only if the stored hash of the series is still
+	// expectedHash, returns false if the token has been rotated by someone else
+	Rotate(series, expectedHash string, token *RememberMeToken) (bool, error)
+
+	// Delete deletes the token series
+	Delete(series string) error
+}
+
+type inMemoryRememberMeStore struct {
+	lock   *sync.RWMutex
+	tokens map[string]*RememberMeToken
+}
+
+type sqlRememberMeStore struct {
+	db    Executable
+	table string
+}
+
+type rememberMeRow struct {
+	Series       string `col:"series"`
+	Hash         string `col:"token_hash"`
+	PreviousHash string `col:"previous_hash"`
+	RotatedAt    int64  `col:"rotated_at"`
+	Domain       string `col:"domain"`
+	UserID       string `col:"user_id"`
+	ExpiresAt    int64  `col:"expires_at"`
+}
+
+// NewInMemoryRememberMeStore creates a remember-me token store in memory
+func NewInMemoryRememberMeStore() RememberMeStore {
+	return &inMemoryRememberMeStore{&sync.RWMutex{}, make(map[string]*RememberMeToken)}
+}
+
+// Get implements RememberMeStore
+func (store *inMemoryRememberMeStore) Get(series string) (*RememberMeToken, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	token, ok := store.tokens[series]
+	if !ok {
+		return nil, ErrRememberMeTokenNotFound
+	}
+
+	copied := *token
+	return &copied, nil
+}
+
+// Save implements RememberMeStore
+func (store *inMemoryRememberMeStore) Save(token *RememberMeToken) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	copied := *token
+	store.tokens[token.Series] = &copied
+	return nil
+}
+
+// Rotate implements RememberMeStore
+func (store *inMemoryRememberMeStore) Rotate(series, expectedHash string, token *RememberMeToken) (bool, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	current, ok := store.tokens[series]
+	if !ok {
+		return false, ErrRememberMeTokenNotFound
+	}
+
+	if current.Hash != expectedHash {
+		return false, nil
+	}
+
+	copied := *token
+	store.tokens[series] = &copied
+	return true, nil
+}
+
+// Delete implements RememberMeStore
+func (store *inMemoryRememberMeStore) Delete(series string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	delete(store.tokens, series)
+	return nil
+}
+
+// NewSQLRememberMeStore creates a remember-me token store backed by the table, which requires
+// columns series (primary key), token_hash, previous_hash, rotated_at, domain, user_id and
+// expires_at (unix seconds)
+func NewSQLRememberMeStore(db Executable, table string) RememberMeStore {
+	return &sqlRememberMeStore{db, table}
+}
+
+// Get implements RememberMeStore
+func (store *sqlRememberMeStore) Get(series string) (*RememberMeToken, error) {
+	obj, err := QueryOne(context.Background(), store.db, NewSmartMapper(&rememberMeRow{}),
+		"SELECT series, token_hash, previous_hash, rotated_at, domain, user_id, expires_at FROM "+store.table+" WHERE series = ?", series)
+	if err != nil {
+		return nil, err
+	}
+
+	if obj == nil {
+		return nil, ErrRememberMeTokenNotFound
+	}
+
+	row := obj.(*rememberMeRow)
+	return &RememberMeToken{
+		Series:       row.Series,
+		Hash:         row.Hash,
+		PreviousHash: row.PreviousHash,
+		RotatedAt:    timeOrZero(row.RotatedAt),
+		Domain:       row.Domain,
+		UserID:       row.UserID,
+		ExpiresAt:    timeOrZero(row.ExpiresAt),
+	}, nil
+}
+
+// Save implements RememberMeStore, the token is updated if the series exists, or
+// inserted otherwise, the update is retried if the series is inserted concurrently
+func (store *sqlRememberMeStore) Save(token *RememberMeToken) error {
+	update := func() (int64, error) {
+		result, err := store.db.ExecContext(context.Background(),
+			"UPDATE "+store.table+" SET token_hash = ?, previous_hash = ?, rotated_at = ?, domain = ?, user_id = ?, expires_at = ? WHERE series = ?",
+			token.Hash, token.PreviousHash, unixOrZero(token.RotatedAt), token.Domain, token.UserID, unixOrZero(token.ExpiresAt), token.Series)
+		if err != nil {
+			return 0, err
+		}
+
+		return result.RowsAffected()
+	}
+
+	affected, err := update()
+	if err != nil || affected > 0 {
+		return err
+	}
+
+	_, err = store.db.ExecContext(context.Background(),
+		"INSERT INTO "+store.table+" (series, token_hash, previous_hash, rotated_at, domain, user_id, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		token.Series, token.Hash, token.PreviousHash, unixOrZero(token.RotatedAt), token.Domain, token.UserID, unixOrZero(token.ExpiresAt))
+	if err != nil {
+		// the row exists but was not changed by the update, or it's inserted concurrently
+		if affected, updateErr := update(); updateErr != nil || affected > 0 {
+			return updateErr
+		}
+	}
+
+	return err
+}
+
+// Rotate implements RememberMeStore
+func (store *sqlRememberMeStore) Rotate(series, expectedHash string, token *RememberMeToken) (bool, error) {
+	result, err := store.db.ExecContext(context.Background(),
+		"UPDATE "+store.table+" SET token_hash = ?, previous_hash = ?, rotated_at = ? WHERE series = ? AND token_hash = ?",
+		token.Hash, token.PreviousHash, unixOrZero(token.RotatedAt), series, expectedHash)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// Delete implements RememberMeStore
+func (store *sqlRememberMeStore) Delete(series string) error {
+	_, err := store.db.ExecContext(context.Background(), "DELETE FROM "+store.table+" WHERE series = ?", series)
+	return err
+}
+
+func newRememberMeValidator() (string, error) {
+	validator := make([]byte, 32)
+	if _, err := rand.Read(validator); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(validator), nil
+}
+
+func hashRememberMeValidator(validator string) string {
+	return hex.EncodeToString(Sha256([]byte(validator)))
+}
+
+// WithRememberMe enables remember-me tokens that are valid for ttl, the token is issued
+// when the login form has a non-empty rememberMe field, and is used to re-establish the
+// session when the user is not logged in the session, e.g. the session expired. The
+// validator of the token is rotated each time it's used, a reused validator means
+// the token was stolen, and the whole token series is revoked
+func (provider *SessionUserProvider) WithRememberMe(store RememberMeStore, ttl time.Duration) *SessionUserProvider {
+	provider.rememberMeStore = store
+	provider.rememberMeTTL = ttl
+	provider.server.WithCustomHandler(CustomHandlerFunc(provider.restoreSession))
+	return provider
+}
+
+// Remember issues a new remember-me token series for the user
+func (provider *SessionUserProvider) Remember(writer http.ResponseWriter, user *UserPrincipal) error {
+	series := make([]byte, 16)
+	if _, err := rand.Read(series); err != nil {
+		return err
+	}
+
+	validator, err := newRememberMeValidator()
+	if err != nil {
+		return err
+	}
+
+	token := &RememberMeToken{
+		Series:    hex.EncodeToString(series),
+		Hash:      hashRememberMeValidator(validator),
+		RotatedAt: time.Now(),
+		Domain:    user.Domain,
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(provider.rememberMeTTL),
+	}
+
+	if err = provider.rememberMeStore.Save(token); err != nil {
+		return err
+	}
+
+	setRememberMeCookie(writer, token.Series+"."+validator, token.ExpiresAt)
+	return nil
+}
+
+// forget revokes the remember-me token series of the request
+func (provider *SessionUserProvider) forget(writer http.ResponseWriter, request *http.Request) {
+	if provider.rememberMeStore == nil {
+		return
+	}
+
+	if cookie, err := request.Cookie(RememberMeCookieName); err == nil {
+		series := strings.SplitN(cookie.Value, ".", 2)[0]
+		if err = provider.rememberMeStore.Delete(series); err != nil {
+			zap.L().Error("failedToDeleteRememberMeToken", zap.Error(err), zap.String("activityId", GetTraceID(request.Context())))
+		}
+
+		setRememberMeCookie(writer, "", time.Time{})
+	}
+}
+
+func setRememberMeCookie(writer http.ResponseWriter, value string, expiresAt time.Time) {
+	maxAge := -1
+	if value != "" {
+		maxAge = int(time.Until(expiresAt).Seconds())
+	}
+
+	http.SetCookie(writer, &http.Cookie{
+		Name:     RememberMeCookieName,
+		Value:    value,
+		MaxAge:   maxAge,
+		Path:     "/",
+		HttpOnly: true,
+	})
+}
+
+// restoreSession re-establishes the session by the remember-me token if the user is not
+// logged in the session
+func (provider *SessionUserProvider) restoreSession(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if session := GetSession(request); session != nil {
+			if _, ok := session.GetValue(UserIDSessionKey); !ok {
+				if cookie, err := request.Cookie(RememberMeCookieName); err == nil {
+					provider.loginByToken(writer, request, cookie.Value)
+				}
+			}
+		}
+
+		handler.ServeHTTP(writer, request)
+	})
+}
+
+// verifyRememberMeToken verifies the token and rotates the validator, returns nil
+// if the token is invalid
+func (provider *SessionUserProvider) verifyRememberMeToken(writer http.ResponseWriter, request *http.Request, value string) *RememberMeToken {
+	traceID := GetTraceID(request.Context())
+	parts := strings.SplitN(value, ".", 2)
+	if len(parts) != 2 {
+		return nil
+	}
+
+	token, err := provider.rememberMeStore.Get(parts[0])
+	if err != nil {
+		if err != ErrRememberMeTokenNotFound {
+			zap.L().Error("failedToGetRememberMeToken", zap.Error(err), zap.String("activityId", traceID))
+		}
+
+		return nil
+	}
+
+	if time.Now().After(token.ExpiresAt) {
+		if err = provider.rememberMeStore.Delete(token.Series); err != nil {
+			zap.L().Error("failedToDeleteRememberMeToken", zap.Error(err), zap.String("activityId", traceID))
+		}
+
+		return nil
+	}
+
+	hash := hashRememberMeValidator(parts[1])
+	if hmac.Equal([]byte(hash), []byte(token.PreviousHash)) && time.Since(token.RotatedAt) < RememberMeRotationGrace {
+		// a concurrent request with the previous cookie, the new cookie has been sent
+		return token
+	}
+
+	if !hmac.Equal([]byte(hash), []byte(token.Hash)) {
+		zap.L().Warn("rememberMeTokenTheft", zap.String("series", token.Series), zap.String("user", token.UserID), zap.String("remoteAddr", request.RemoteAddr), zap.String("activityId", traceID))
+		if err = provider.rememberMeStore.Delete(token.Series); err != nil {
+			zap.L().Error("failedToDeleteRememberMeToken", zap.Error(err), zap.String("activityId", traceID))
+		}
+
+		return nil
+	}
+
+	validator, err := newRememberMeValidator()
+	if err != nil {
+		return nil
+	}
+
+	token.PreviousHash = token.Hash
+	token.Hash = hashRememberMeValidator(validator)
+	token.RotatedAt = time.Now()
+	rotated, err := provider.rememberMeStore.Rotate(token.Series, token.PreviousHash, token)
+	if err != nil {
+		zap.L().Error("failedToRotateRememberMeToken", zap.Error(err), zap.String("activityId", traceID))
+		return nil
+	}
+
+	if !rotated {
+		// a concurrent request with the same cookie has rotated the token, the
+		// cookie is now the previous one, which is accepted in the grace period.
+		// The validator is not checked against the hash stored by that request,
+		// it's safe only because the validator matched the hash that the winner
+		// replaced, which is the PreviousHash the grace check above accepts
+		return token
+	}
+
+	setRememberMeCookie(writer, token.Series+"."+validator, token.ExpiresAt)
+	return token
+}
+
+// loginByToken logs the user in a new session by the remember-me token
+func (provider *SessionUserProvider) loginByToken(writer http.ResponseWriter, request *http.Request, value string) {
+	token := provider.verifyRememberMeToken(writer, request, value)
+	if token == nil {
+		setRememberMeCookie(writer, "", time.Time{})
+		return
+	}
+
+	if provider.Load(token.Domain, token.UserID) == nil {
+		if err := provider.rememberMeStore.Delete(token.Series); err != nil {
+			zap.L().Error("failedToDeleteRememberMeToken", zap.Error(err), zap.String("activityId", GetTraceID(request.Context())))
+		}
+
+		setRememberMeCookie(writer, "", time.Time{})
+		return
+	}
+
+	session := provider.server.renewSession(writer, request)
+	session.SetValue(UserDomainSessionKey, token.Domain)
+	session.SetValue(UserIDSessionKey, token.UserID)
+	if err := provider.server.sessionStore.Save(session, provider.server.sessionTimeout); err != nil {
+		zap.L().Error("failedToSaveSession", zap.Error(err), zap.String("activityId", GetTraceID(request.Context())))
+		return
+	}
+
+	zap.L().Info("loggedInByRememberMe", zap.String("user", token.UserID), zap.String("domain", token.Domain), zap.String("activityId", GetTraceID(request.Context())))
+}
@@ -0,0 +1,192 @@
+package cypress
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRememberMe(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "cyremembertest")
+	if err != nil {
+		t.Error("failed to create test dir", err)
+		return
+	}
+
+	defer os.RemoveAll(testDir)
+	db, err := sql.Open("sqlite3", path.Join(testDir, "tokens.db"))
+	if err != nil {
+		t.Error("failed to open the database file", err)
+		return
+	}
+
+	defer db.Close()
+	_, err = db.Exec("create table remember_me(series varchar(32) primary key, token_hash varchar(64), previous_hash varchar(64), rotated_at bigint, domain varchar(64), user_id varchar(64), expires_at bigint)")
+	if err != nil {
+		t.Error("failed to create test table", err)
+		return
+	}
+
+	grace := RememberMeRotationGrace
+	RememberMeRotationGrace = 0
+	defer func() {
+		RememberMeRotationGrace = grace
+	}()
+
+	for name, store := range map[string]RememberMeStore{"memory": NewInMemoryRememberMeStore(), "sql": NewSQLRememberMeStore(db, "remember_me")} {
+		if !testRememberMeRotate(t, name, store) || !testRememberMeFlow(t, name, store) {
+			return
+		}
+	}
+}
+
+func testRememberMeRotate(t *testing.T, name string, store RememberMeStore) bool {
+	token := &RememberMeToken{Series: "rotate", Hash: "h1", RotatedAt: time.Now(), Domain: "test", UserID: "1001", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := store.Save(token); err != nil {
+		t.Error("failed to save token for", name, err)
+		return false
+	}
+
+	// saving the same token again must update the series instead of failing
+	if err := store.Save(token); err != nil {
+		t.Error("failed to save token again for", name, err)
+		return false
+	}
+
+	first := *token
+	first.Hash, first.PreviousHash = "h2", "h1"
+	second := *token
+	second.Hash, second.PreviousHash = "h3", "h1"
+	if rotated, err := store.Rotate(token.Series, "h1", &first); !rotated || err != nil {
+		t.Error("expecting the first rotation to win for", name, err)
+		return false
+	}
+
+	if rotated, err := store.Rotate(token.Series, "h1", &second); rotated || err != nil {
+		t.Error("expecting the concurrent rotation to lose for", name, err)
+		return false
+	}
+
+	if stored, err := store.Get(token.Series); err != nil || stored.Hash != "h2" || stored.PreviousHash != "h1" {
+		t.Error("unexpected rotated token for", name, stored, err)
+		return false
+	}
+
+	return store.Delete(token.Series) == nil
+}
+
+func testRememberMeFlow(t *testing.T, name string, store RememberMeStore) bool {
+	sessionStore := NewInMemorySessionStore()
+	defer sessionStore.Close()
+
+	server := NewWebServer(":8099", nil)
+	server.WithSessionOptions(sessionStore, time.Minute)
+	provider := server.AddFormLogin("/login", "/logout", CredentialVerifierFunc(func(userName, password string) (string, string, error) {
+		if userName == "alice" && password == "secret" {
+			return "test", "1001", nil
+		}
+
+		return "", "", ErrInvalidCredential
+	}), func(domain, id string) *UserPrincipal {
+		return &UserPrincipal{ID: id, Domain: domain}
+	}).WithRememberMe(store, time.Hour)
+
+	server.HandleFunc("/me", func(writer http.ResponseWriter, request *http.Request) {
+		if user := provider.Authenticate(request); user != nil {
+			writer.Write([]byte(user.Domain + "/" + user.ID))
+		}
+	})
+
+	front := httptest.NewServer(server.pipeline(true))
+	defer front.Close()
+
+	client := &http.Client{CheckRedirect: func(request *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	// call sends the request with only the remember-me cookie, as the session is gone,
+	// and returns the response body and the new remember-me cookie
+	call := func(method, path, rememberMe string, values url.Values) (string, string) {
+		request, _ := http.NewRequest(method, front.URL+path, strings.NewReader(values.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if rememberMe != "" {
+			request.AddCookie(&http.Cookie{Name: RememberMeCookieName, Value: rememberMe})
+		}
+
+		resp, err := client.Do(request)
+		if err != nil {
+			return "", ""
+		}
+
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		for _, cookie := range resp.Cookies() {
+			if cookie.Name == RememberMeCookieName {
+				rememberMe = cookie.Value
+			}
+		}
+
+		return string(body), rememberMe
+	}
+
+	_, token := call(http.MethodPost, "/login", "", url.Values{"username": []string{"alice"}, "password": []string{"secret"}})
+	if token != "" {
+		t.Error("expecting no remember-me token without rememberMe field for", name)
+		return false
+	}
+
+	_, token = call(http.MethodPost, "/login", "", url.Values{"username": []string{"alice"}, "password": []string{"secret"}, "rememberMe": []string{"on"}})
+	if token == "" || !strings.Contains(token, ".") {
+		t.Error("expecting a remember-me token for", name)
+		return false
+	}
+
+	stored, err := store.Get(strings.Split(token, ".")[0])
+	if err != nil || stored.UserID != "1001" || strings.Contains(token, stored.Hash) {
+		t.Error("unexpected stored token for", name, stored, err)
+		return false
+	}
+
+	body, rotated := call(http.MethodGet, "/me", token, nil)
+	if body != "test/1001" || rotated == token || rotated == "" {
+		t.Error("expecting session to be restored and token rotated for", name, body)
+		return false
+	}
+
+	body, again := call(http.MethodGet, "/me", rotated, nil)
+	if body != "test/1001" || again == rotated {
+		t.Error("expecting rotated token to be accepted for", name, body)
+		return false
+	}
+
+	// the stolen token is reused, the whole series must be revoked
+	if body, _ = call(http.MethodGet, "/me", token, nil); body != "" {
+		t.Error("expecting old token to be rejected for", name, body)
+		return false
+	}
+
+	if body, _ = call(http.MethodGet, "/me", again, nil); body != "" {
+		t.Error("expecting the series to be revoked after theft for", name, body)
+		return false
+	}
+
+	_, token = call(http.MethodPost, "/login", "", url.Values{"username": []string{"alice"}, "password": []string{"secret"}, "rememberMe": []string{"on"}})
+	if _, cleared := call(http.MethodPost, "/logout", token, nil); cleared != "" {
+		t.Error("expecting remember-me cookie to be cleared by logout for", name)
+		return false
+	}
+
+	if _, err = store.Get(strings.Split(token, ".")[0]); err != ErrRememberMeTokenNotFound {
+		t.Error("expecting token to be revoked by logout for", name, err)
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,473 @@
+package cypress
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/gofrs/uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	redisJobLockKeyPrefix = "cypress$job$"
+)
+
+var (
+	// ErrBadCronExpression the cron expression is malformed
+	ErrBadCronExpression = errors.New("bad cron expression")
+
+	cronDescriptors = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+)
+
+// JobFunc a scheduled job, the context carries a trace activity id and is cancelled
+// when the scheduler is stopped
+type JobFunc func(ctx context.Context) error
+
+// Schedule decides when a job runs
+type Schedule interface {
+	// Next returns the next time after t that the job should run, a zero
+	// time means the job should not run anymore
+	Next(t time.Time) time.Time
+}
+
+// JobLock a lock shared by the instances of the web server, so that a job is
+// executed by only one of the instances for each scheduled time
+type JobLock interface {
+	// TryLock tries to acquire the lock with the key for ttl, returns false if
+	// the lock is held by others
+	TryLock(key string, ttl time.Duration) (bool, error)
+}
+
+type intervalSchedule struct {
+	interval time.Duration
+}
+
+type cronSchedule struct {
+	minutes  uint64
+	hours    uint64
+	days     uint64
+	months   uint64
+	weekdays uint64
+	anyDay   bool
+	anyDow   bool
+}
+
+type redisJobLock struct {
+	redisDb *redis.Client
+}
+
+type sqlJobLock struct {
+	db    *sql.DB
+	table string
+}
+
+// Job a job registered to the scheduler
+type Job struct {
+	name         string
+	schedule     Schedule
+	fn           JobFunc
+	jitter       time.Duration
+	allowOverlap bool
+	lock         JobLock
+	running      int32
+}
+
+// Scheduler runs the jobs by their schedules
+type Scheduler struct {
+	lock    *sync.Mutex
+	jobs    []*Job
+	ctx     context.Context
+	cancel  context.CancelFunc
+	waiter  *sync.WaitGroup
+	started bool
+}
+
+// Every creates a schedule that runs at the multiples of the interval, the times are
+// aligned to the unix epoch, so that all instances of the web server agree on them
+func Every(interval time.Duration) Schedule {
+	return &intervalSchedule{interval}
+}
+
+// Next implements Schedule
+func (schedule *intervalSchedule) Next(t time.Time) time.Time {
+	if schedule.interval <= 0 {
+		return time.Time{}
+	}
+
+	return t.Truncate(schedule.interval).Add(schedule.interval)
+}
+
+// ParseCron parses a standard five fields cron expression, "minute hour day-of-month
+// month day-of-week", each field supports *, lists, ranges and steps, e.g. "*/15 9-17 * * 1-5",
+// the descriptors like @daily and @hourly are also supported, times are in local time zone
+func ParseCron(expr string) (Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if value, ok := cronDescriptors[expr]; ok {
+		expr = value
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, ErrBadCronExpression
+	}
+
+	bounds := [][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	values := make([]uint64, 5)
+	for i, field := range fields {
+		value, err := parseCronField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, err
+		}
+
+		values[i] = value
+	}
+
+	// both 0 and 7 are Sunday
+	if values[4]&(1<<7) != 0 {
+		values[4] |= 1
+	}
+
+	return &cronSchedule{
+		minutes:  values[0],
+		hours:    values[1],
+		days:     values[2],
+		months:   values[3],
+		weekdays: values[4],
+		anyDay:   fields[2] == "*",
+		anyDow:   fields[4] == "*",
+	}, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var result uint64
+	for _, item := range strings.Split(field, ",") {
+		step := 1
+		if index := strings.Index(item, "/"); index >= 0 {
+			value, err := strconv.Atoi(item[index+1:])
+			if err != nil || value <= 0 {
+				return 0, ErrBadCronExpression
+			}
+
+			step = value
+			item = item[:index]
+		}
+
+		start, end := min, max
+		if item != "*" {
+			parts := strings.SplitN(item, "-", 2)
+			value, err := strconv.Atoi(parts[0])
+			if err != nil {
+				return 0, ErrBadCronExpression
+			}
+
+			start, end = value, value
+			if len(parts) == 2 {
+				end, err = strconv.Atoi(parts[1])
+				if err != nil {
+					return 0, ErrBadCronExpression
+				}
+			} else if step > 1 {
+				end = max
+			}
+		}
+
+		if start < min || end > max || start > end {
+			return 0, ErrBadCronExpression
+		}
+
+		for i := start; i <= end; i += step {
+			result |= 1 << uint(i)
+		}
+	}
+
+	return result, nil
+}
+
+func (schedule *cronSchedule) matchDay(t time.Time) bool {
+	dayMatched := schedule.days&(1<<uint(t.Day())) != 0
+	dowMatched := schedule.weekdays&(1<<uint(t.Weekday())) != 0
+	if schedule.anyDay || schedule.anyDow {
+		return dayMatched && dowMatched
+	}
+
+	// the job runs when either field matches if both are restricted
+	return dayMatched || dowMatched
+}
+
+// Next implements Schedule
+func (schedule *cronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if schedule.months&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !schedule.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if schedule.hours&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if schedule.minutes&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
+
+// NewRedisJobLock creates a redis based job lock
+func NewRedisJobLock(cli *redis.Client) JobLock {
+	return &redisJobLock{cli}
+}
+
+// TryLock implements JobLock
+func (lock *redisJobLock) TryLock(key string, ttl time.Duration) (bool, error) {
+	return lock.redisDb.SetNX(redisJobLockKeyPrefix+key, 1, ttl).Result()
+}
+
+// NewSQLJobLock creates a database based job lock, the table must have a
+// "name" column of varchar(255) as the primary key and an "expires_at" column
+// of bigint, the placeholders in the statements are "?"
+func NewSQLJobLock(db *sql.DB, table string) JobLock {
+	return &sqlJobLock{db, table}
+}
+
+// TryLock implements JobLock
+func (lock *sqlJobLock) TryLock(key string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	_, err := lock.db.Exec("DELETE FROM "+lock.table+" WHERE expires_at < ?", now.Unix())
+	if err != nil {
+		return false, err
+	}
+
+	_, err = lock.db.Exec("INSERT INTO "+lock.table+" (name, expires_at) VALUES (?, ?)", key, now.Add(ttl).Unix())
+	if err == nil {
+		return true, nil
+	}
+
+	// the insert fails by the primary key if the lock is held by others
+	var count int
+	if lock.db.QueryRow("SELECT COUNT(*) FROM "+lock.table+" WHERE name = ?", key).Scan(&count) == nil && count > 0 {
+		return false, nil
+	}
+
+	return false, err
+}
+
+// NewScheduler creates a scheduler without any jobs
+func NewScheduler() *Scheduler {
+	return &Scheduler{
+		lock:   &sync.Mutex{},
+		jobs:   make([]*Job, 0, 4),
+		waiter: &sync.WaitGroup{},
+	}
+}
+
+// AddJob adds a job to the scheduler, the job starts to be scheduled immediately if
+// the scheduler has been started
+func (scheduler *Scheduler) AddJob(name string, schedule Schedule, fn JobFunc) *Job {
+	job := &Job{name: name, schedule: schedule, fn: fn}
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+	scheduler.jobs = append(scheduler.jobs, job)
+	if scheduler.started {
+		scheduler.schedule(job)
+	}
+
+	return job
+}
+
+// AddCronJob adds a job that runs by the cron expression, see ParseCron
+func (scheduler *Scheduler) AddCronJob(name, expr string, fn JobFunc) (*Job, error) {
+	schedule, err := ParseCron(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	return scheduler.AddJob(name, schedule, fn), nil
+}
+
+// Start starts to schedule the jobs
+func (scheduler *Scheduler) Start() {
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+	if scheduler.started {
+		return
+	}
+
+	scheduler.ctx, scheduler.cancel = context.WithCancel(context.Background())
+	scheduler.started = true
+	for _, job := range scheduler.jobs {
+		scheduler.schedule(job)
+	}
+}
+
+// Stop stops scheduling the jobs, cancels the contexts of running jobs and
+// waits for them to complete
+func (scheduler *Scheduler) Stop() {
+	scheduler.lock.Lock()
+	if !scheduler.started {
+		scheduler.lock.Unlock()
+		return
+	}
+
+	scheduler.started = false
+	scheduler.cancel()
+	scheduler.lock.Unlock()
+	scheduler.waiter.Wait()
+}
+
+func (scheduler *Scheduler) schedule(job *Job) {
+	ctx := scheduler.ctx
+	scheduler.waiter.Add(1)
+	go func() {
+		defer scheduler.waiter.Done()
+		for {
+			next := job.schedule.Next(time.Now())
+			if next.IsZero() {
+				return
+			}
+
+			delay := time.Until(next)
+			if job.jitter > 0 {
+				delay += time.Duration(rand.Int63n(int64(job.jitter)))
+			}
+
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+				scheduler.waiter.Add(1)
+				go func() {
+					defer scheduler.waiter.Done()
+					job.run(ctx, next)
+				}()
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			}
+		}
+	}()
+}
+
+// WithJitter delays each run by a random duration up to jitter, to avoid the
+// jobs of all instances hitting the shared resources at the same time
+func (job *Job) WithJitter(jitter time.Duration) *Job {
+	job.jitter = jitter
+	return job
+}
+
+// AllowOverlap allows a run to start while the previous one is still running,
+// by default the run is skipped
+func (job *Job) AllowOverlap() *Job {
+	job.allowOverlap = true
+	return job
+}
+
+// WithLock runs the job on only one of the instances of the web server for each
+// scheduled time, by acquiring the lock for the job and the scheduled time
+func (job *Job) WithLock(lock JobLock) *Job {
+	job.lock = lock
+	return job
+}
+
+func (job *Job) run(ctx context.Context, scheduled time.Time) {
+	activityID := "no-activity-id"
+	if id, err := uuid.NewV4(); err == nil {
+		activityID = id.String()
+	}
+
+	if !job.allowOverlap {
+		if !atomic.CompareAndSwapInt32(&job.running, 0, 1) {
+			zap.L().Warn("jobOverlapped", zap.String("job", job.name), zap.String("activityId", activityID))
+			return
+		}
+
+		defer atomic.StoreInt32(&job.running, 0)
+	}
+
+	// the timer could fire at the same time the scheduler is stopped, and the
+	// previous run may just have returned for the cancellation
+	if ctx.Err() != nil {
+		return
+	}
+
+	if job.lock != nil {
+		ttl := job.schedule.Next(scheduled).Sub(scheduled)
+		if ttl < time.Second {
+			ttl = time.Second
+		}
+
+		locked, err := job.lock.TryLock(fmt.Sprintf("%s$%d", job.name, scheduled.UnixNano()), ttl)
+		if err != nil {
+			zap.L().Error("failedToLockJob", zap.String("job", job.name), zap.Error(err), zap.String("activityId", activityID))
+			return
+		}
+
+		if !locked {
+			zap.L().Debug("jobLockedByOthers", zap.String("job", job.name), zap.String("activityId", activityID))
+			return
+		}
+	}
+
+	start := time.Now()
+	defer func() {
+		if err := recover(); err != nil {
+			defer zap.L().Sync()
+			zap.L().Error(fmt.Sprint(err),
+				zap.String("job", job.name),
+				zap.Stack("source"),
+				zap.String("activityId", activityID))
+		}
+	}()
+
+	err := job.fn(extentContext(ctx).withValue(TraceActivityIDKey, activityID))
+	if err != nil {
+		zap.L().Error("jobFailed", zap.String("job", job.name), zap.Error(err), zap.String("activityId", activityID))
+	}
+
+	zap.L().Info("jobExecuted",
+		zap.String("type", "job"),
+		zap.String("job", job.name),
+		zap.Bool("success", err == nil),
+		zap.Int("latency", int(time.Since(start).Seconds()*1000)),
+		zap.String("activityId", activityID))
+}
+
+// AddJob adds a job to the scheduler of the web server, the scheduler is
+// started and stopped with the web server
+func (server *WebServer) AddJob(name string, schedule Schedule, fn JobFunc) *Job {
+	return server.scheduler.AddJob(name, schedule, fn)
+}
+
+// AddCronJob adds a job that runs by the cron expression to the scheduler of
+// the web server, see ParseCron
+func (server *WebServer) AddCronJob(name, expr string, fn JobFunc) (*Job, error) {
+	return server.scheduler.AddCronJob(name, expr, fn)
+}
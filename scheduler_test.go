@@ -0,0 +1,144 @@
+package cypress
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestParseCron(t *testing.T) {
+	base := time.Date(2019, time.May, 17, 10, 7, 30, 0, time.Local) // Friday
+	cases := []struct {
+		expr     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2019, time.May, 17, 10, 8, 0, 0, time.Local)},
+		{"*/15 * * * *", time.Date(2019, time.May, 17, 10, 15, 0, 0, time.Local)},
+		{"0 9-17 * * 1-5", time.Date(2019, time.May, 17, 11, 0, 0, 0, time.Local)},
+		{"30 2 * * 0", time.Date(2019, time.May, 19, 2, 30, 0, 0, time.Local)},
+		{"0 0 1,15 * *", time.Date(2019, time.June, 1, 0, 0, 0, 0, time.Local)},
+		{"0 0 29 2 *", time.Date(2020, time.February, 29, 0, 0, 0, 0, time.Local)},
+		{"0 0 13 * 5", time.Date(2019, time.May, 24, 0, 0, 0, 0, time.Local)},
+		{"@daily", time.Date(2019, time.May, 18, 0, 0, 0, 0, time.Local)},
+	}
+
+	for _, c := range cases {
+		schedule, err := ParseCron(c.expr)
+		if err != nil {
+			t.Error("failed to parse", c.expr, err)
+			return
+		}
+
+		if next := schedule.Next(base); !next.Equal(c.expected) {
+			t.Error("expecting", c.expected, "for", c.expr, "but got", next)
+			return
+		}
+	}
+
+	for _, expr := range []string{"* * * *", "60 * * * *", "*/0 * * * *", "a * * * *", "5-1 * * * *"} {
+		if _, err := ParseCron(expr); err != ErrBadCronExpression {
+			t.Error("expecting ErrBadCronExpression for", expr)
+			return
+		}
+	}
+
+	if next := Every(time.Hour).Next(base); !next.Equal(base.Truncate(time.Hour).Add(time.Hour)) {
+		t.Error("interval schedule must be aligned but got", next)
+		return
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	testDbFile, err := ioutil.TempFile(os.TempDir(), "cyjobtest*.db")
+	if err != nil {
+		t.Error("failed to create test db file", err)
+		return
+	}
+
+	defer os.Remove(testDbFile.Name())
+	db, err := sql.Open("sqlite3", testDbFile.Name())
+	if err != nil {
+		t.Error("failed to open the database file", err)
+		return
+	}
+
+	defer db.Close()
+	_, err = db.Exec("create table job_lock(name varchar(255) PRIMARY KEY, expires_at bigint)")
+	if err != nil {
+		t.Error("failed to create test table", err)
+		return
+	}
+
+	var counter, slow, locked, panics int32
+	lock := NewSQLJobLock(db, "job_lock")
+	schedulers := []*Scheduler{NewScheduler(), NewScheduler()}
+	for _, scheduler := range schedulers {
+		scheduler.AddJob("locked", Every(100*time.Millisecond), func(ctx context.Context) error {
+			atomic.AddInt32(&locked, 1)
+			return nil
+		}).WithLock(lock)
+	}
+
+	scheduler := schedulers[0]
+	scheduler.AddJob("counter", Every(50*time.Millisecond), func(ctx context.Context) error {
+		if GetTraceID(ctx) == "" {
+			t.Error("jobs must have trace ids")
+		}
+
+		atomic.AddInt32(&counter, 1)
+		return nil
+	})
+
+	scheduler.AddJob("slow", Every(50*time.Millisecond), func(ctx context.Context) error {
+		atomic.AddInt32(&slow, 1)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	scheduler.AddJob("panic", Every(50*time.Millisecond), func(ctx context.Context) error {
+		atomic.AddInt32(&panics, 1)
+		panic("job panic")
+	})
+
+	for _, item := range schedulers {
+		item.Start()
+	}
+
+	time.Sleep(520 * time.Millisecond)
+	for _, item := range schedulers {
+		item.Stop()
+	}
+
+	if c := atomic.LoadInt32(&counter); c < 8 || c > 11 {
+		t.Error("expecting about 10 runs but got", c)
+		return
+	}
+
+	if s := atomic.LoadInt32(&slow); s != 1 {
+		t.Error("slow job must not overlap but got", s)
+		return
+	}
+
+	if p := atomic.LoadInt32(&panics); p < 8 {
+		t.Error("panics must not stop the job but got", p)
+		return
+	}
+
+	if l := atomic.LoadInt32(&locked); l < 4 || l > 6 {
+		t.Error("locked job must run once per tick across schedulers but got", l)
+		return
+	}
+
+	c := atomic.LoadInt32(&counter)
+	time.Sleep(100 * time.Millisecond)
+	if atomic.LoadInt32(&counter) != c {
+		t.Error("jobs must not run after the scheduler is stopped")
+		return
+	}
+}
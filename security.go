@@ -45,6 +45,14 @@ type UserProvider interface {
 	Load(domain, id string) *UserPrincipal
 }
 
+// Challenger a UserProvider that challenges the client for credentials, e.g. by
+// a WWW-Authenticate header, when an anonymous request is denied, instead of
+// redirecting to the login URL
+type Challenger interface {
+	// Challenge writes the challenge response to the client
+	Challenge(writer http.ResponseWriter, request *http.Request)
+}
+
 // AuthorizationManager an interface used by the security handler to check
 // if the given user has permission to use the specified method and access
 // the given path
@@ -113,32 +121,89 @@ func GetUser(request *http.Request) *UserPrincipal {
 	return nil
 }
 
-// ServeHTTP implements the http.Handler interface
-func (handler *SecurityHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+// Authenticate resolves the UserPrincipal of the request by the user providers in
+// the order they are added, returns nil if none of the providers could resolve it
+func (handler *SecurityHandler) Authenticate(request *http.Request) *UserPrincipal {
+	for _, provider := range handler.userProviders {
+		userPrincipal := provider.Authenticate(request)
+		if userPrincipal != nil {
+			userPrincipal.Provider = provider.GetName()
+			return userPrincipal
+		}
+	}
+
+	return nil
+}
+
+// resolveUser gets the user of the request, the user providers are only called if the
+// user is not resolved yet, the resolved user is saved to the request context so that
+// it's reused by the handlers later in the pipeline
+func (handler *SecurityHandler) resolveUser(request *http.Request) *UserPrincipal {
+	if user := GetUser(request); user != nil {
+		return user
+	}
+
+	user := handler.Authenticate(request)
+	if ctx, ok := getMultiValueCtx(request); ok && user != nil {
+		ctx.withValue(UserPrincipalKey, user)
+	}
+
+	return user
+}
+
+// CheckAccess checks if the user could access the path with the given http method by
+// the AuthorizationManager, user could be nil for anonymous user
+func (handler *SecurityHandler) CheckAccess(user *UserPrincipal, method, path string) bool {
 	if handler.authzMgr == nil ||
-		handler.authzMgr.CheckAnonymousAccessible(request.Method, request.URL.Path) {
-		handler.pipeline.ServeHTTP(writer, request)
-		return
+		handler.authzMgr.CheckAnonymousAccessible(method, path) {
+		return true
 	}
 
-	var userPrincipal *UserPrincipal
+	return user != nil && handler.authzMgr.CheckAccess(user, method, path)
+}
+
+// browserRedirector a Challenger that lets browsers be redirected to the login URL
+// instead of being challenged
+type browserRedirector interface {
+	redirectsBrowser(request *http.Request) bool
+}
+
+// challenge challenges the client by the first Challenger provider for an anonymous
+// request, returns false if the client is not challenged, which is also the case if
+// the provider opts in to redirect the browsers and a login URL is configured
+func (handler *SecurityHandler) challenge(writer http.ResponseWriter, request *http.Request) bool {
 	for _, provider := range handler.userProviders {
-		userPrincipal = provider.Authenticate(request)
-		if userPrincipal != nil {
-			userPrincipal.Provider = provider.GetName()
-			break
+		if challenger, ok := provider.(Challenger); ok {
+			if redirector, ok := provider.(browserRedirector); ok && handler.loginURL != "" && redirector.redirectsBrowser(request) {
+				return false
+			}
+
+			challenger.Challenge(writer, request)
+			return true
 		}
 	}
 
-	if userPrincipal != nil {
-		request.Context().(*multiValueCtx).withValue(UserPrincipalKey, userPrincipal)
+	return false
+}
+
+// ServeHTTP implements the http.Handler interface
+func (handler *SecurityHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if handler.authzMgr == nil ||
+		handler.authzMgr.CheckAnonymousAccessible(request.Method, request.URL.Path) {
+		handler.pipeline.ServeHTTP(writer, request)
+		return
 	}
 
-	if userPrincipal != nil && handler.authzMgr.CheckAccess(userPrincipal, request.Method, request.URL.Path) {
+	userPrincipal := handler.resolveUser(request)
+	if handler.CheckAccess(userPrincipal, request.Method, request.URL.Path) {
 		handler.pipeline.ServeHTTP(writer, request)
 	} else {
+		if userPrincipal == nil && handler.challenge(writer, request) {
+			return
+		}
+
 		if handler.loginURL == "" {
-			SendError(writer, http.StatusForbidden, "Access denied")
+			SendError(writer, http.StatusForbidden, Localize(request, AccessDeniedMsgKey, "Access denied"))
 		} else {
 			http.Redirect(writer, request, handler.loginURL, http.StatusTemporaryRedirect)
 		}
@@ -0,0 +1,347 @@
+package cypress
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// CSPNoncePlaceholder the placeholder in the content security policy that is
+	// replaced by the nonce generated for each request
+	CSPNoncePlaceholder = "{nonce}"
+)
+
+var (
+	// MaxCSPReportSize the max size of a CSP violation report
+	MaxCSPReportSize int64 = 64 * 1024
+)
+
+// SecurityHeaders the security related headers to be added to the responses,
+// an empty value means the header is not sent
+type SecurityHeaders struct {
+	// HSTSMaxAge the max age of Strict-Transport-Security, which is only sent
+	// for https requests
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubDomains bool
+	HSTSPreload           bool
+
+	// FrameOptions the value of X-Frame-Options, e.g. DENY or SAMEORIGIN
+	FrameOptions string
+
+	// NoSniff sends X-Content-Type-Options: nosniff
+	NoSniff bool
+
+	// ReferrerPolicy the value of Referrer-Policy
+	ReferrerPolicy string
+
+	// ContentSecurityPolicy the value of Content-Security-Policy, each
+	// CSPNoncePlaceholder is replaced by the nonce of the request, which
+	// is available to templates by {{cspNonce}}
+	ContentSecurityPolicy string
+
+	// CSPReportOnly sends the policy by Content-Security-Policy-Report-Only
+	CSPReportOnly bool
+
+	// CSPReportURI the uri that the violations are reported to, see
+	// WebServer.WithCSPReportCollector
+	CSPReportURI string
+}
+
+// CSPReport a content security policy violation report
+type CSPReport struct {
+	DocumentURI        string `json:"document-uri"`
+	Referrer           string `json:"referrer"`
+	BlockedURI         string `json:"blocked-uri"`
+	ViolatedDirective  string `json:"violated-directive"`
+	EffectiveDirective string `json:"effective-directive"`
+	OriginalPolicy     string `json:"original-policy"`
+	Disposition        string `json:"disposition"`
+	SourceFile         string `json:"source-file"`
+	LineNumber         int    `json:"line-number"`
+	ColumnNumber       int    `json:"column-number"`
+	StatusCode         int    `json:"status-code"`
+	ScriptSample       string `json:"script-sample"`
+}
+
+// CSPReportCollector handles the CSP violation reports sent by the browsers
+type CSPReportCollector func(request *http.Request, report *CSPReport)
+
+// reportingAPIReport a report sent by the Reporting API in application/reports+json
+type reportingAPIReport struct {
+	Type string `json:"type"`
+	Body struct {
+		DocumentURL        string `json:"documentURL"`
+		Referrer           string `json:"referrer"`
+		BlockedURL         string `json:"blockedURL"`
+		EffectiveDirective string `json:"effectiveDirective"`
+		OriginalPolicy     string `json:"originalPolicy"`
+		Disposition        string `json:"disposition"`
+		SourceFile         string `json:"sourceFile"`
+		LineNumber         int    `json:"lineNumber"`
+		ColumnNumber       int    `json:"columnNumber"`
+		StatusCode         int    `json:"statusCode"`
+		Sample             string `json:"sample"`
+	} `json:"body"`
+}
+
+type securityHeadersHandler struct {
+	defaults  *SecurityHeaders
+	overrides map[string]*SecurityHeaders
+	pipeline  http.Handler
+}
+
+// DefaultSecurityHeaders creates the SecurityHeaders with the recommended values, the
+// policy only allows the resources from the same origin and the inline scripts and
+// styles with the nonce of the request
+func DefaultSecurityHeaders() *SecurityHeaders {
+	return &SecurityHeaders{
+		HSTSMaxAge:            365 * 24 * time.Hour,
+		HSTSIncludeSubDomains: true,
+		FrameOptions:          "SAMEORIGIN",
+		NoSniff:               true,
+		ReferrerPolicy:        "strict-origin-when-cross-origin",
+		ContentSecurityPolicy: "default-src 'self'; script-src 'self' 'nonce-" + CSPNoncePlaceholder + "'; style-src 'self' 'nonce-" + CSPNoncePlaceholder + "'; object-src 'none'; base-uri 'self'; frame-ancestors 'self'",
+	}
+}
+
+// GetCSPNonce gets the CSP nonce of the request, returns empty string if the content
+// security policy of the request does not use a nonce
+func GetCSPNonce(request *http.Request) string {
+	if nonce, ok := request.Context().Value(CSPNonceKey).(string); ok {
+		return nonce
+	}
+
+	return ""
+}
+
+func newCSPNonce() string {
+	nonce := make([]byte, 16)
+	io.ReadFull(rand.Reader, nonce)
+	return base64.RawURLEncoding.EncodeToString(nonce)
+}
+
+// apply writes the headers for the request
+func (headers *SecurityHeaders) apply(writer http.ResponseWriter, request *http.Request) {
+	header := writer.Header()
+	if headers.HSTSMaxAge > 0 && (request.TLS != nil || request.URL.Scheme == "https") {
+		value := "max-age=" + strconv.FormatInt(int64(headers.HSTSMaxAge/time.Second), 10)
+		if headers.HSTSIncludeSubDomains {
+			value += "; includeSubDomains"
+		}
+
+		if headers.HSTSPreload {
+			value += "; preload"
+		}
+
+		header.Set("Strict-Transport-Security", value)
+	}
+
+	if headers.FrameOptions != "" {
+		header.Set("X-Frame-Options", headers.FrameOptions)
+	}
+
+	if headers.NoSniff {
+		header.Set("X-Content-Type-Options", "nosniff")
+	}
+
+	if headers.ReferrerPolicy != "" {
+		header.Set("Referrer-Policy", headers.ReferrerPolicy)
+	}
+
+	if headers.ContentSecurityPolicy != "" {
+		policy := headers.ContentSecurityPolicy
+		if strings.Contains(policy, CSPNoncePlaceholder) {
+			nonce := newCSPNonce()
+			policy = strings.Replace(policy, CSPNoncePlaceholder, nonce, -1)
+			if ctx, ok := getMultiValueCtx(request); ok {
+				ctx.withValue(CSPNonceKey, nonce)
+			}
+		}
+
+		if headers.CSPReportURI != "" {
+			policy += "; report-uri " + headers.CSPReportURI
+		}
+
+		if headers.CSPReportOnly {
+			header.Set("Content-Security-Policy-Report-Only", policy)
+		} else {
+			header.Set("Content-Security-Policy", policy)
+		}
+	}
+}
+
+// ServeHTTP serves incoming http request
+func (handler *securityHeadersHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	headers := handler.defaults
+	matched := ""
+	for prefix, item := range handler.overrides {
+		if strings.HasPrefix(request.URL.Path, prefix) && len(prefix) >= len(matched) {
+			headers = item
+			matched = prefix
+		}
+	}
+
+	if headers != nil {
+		headers.apply(writer, request)
+	}
+
+	handler.pipeline.ServeHTTP(writer, request)
+}
+
+// NewSecurityHeadersHandler creates a handler that adds the security headers to the
+// responses, the overrides are applied to the paths with the given prefixes instead
+// of the defaults, the one with the longest prefix wins, a nil value disables the
+// headers for the prefix
+func NewSecurityHeadersHandler(pipeline http.Handler, defaults *SecurityHeaders, overrides map[string]*SecurityHeaders) http.Handler {
+	return &securityHeadersHandler{defaults, overrides, pipeline}
+}
+
+// WithSecurityHeaders sets the security headers for all responses, see
+// DefaultSecurityHeaders for the recommended values
+func (server *WebServer) WithSecurityHeaders(headers *SecurityHeaders) *WebServer {
+	server.securityHeaders = headers
+	return server
+}
+
+// WithSecurityHeadersFor overrides the security headers for the paths with the
+// given prefix, a nil headers disables the security headers for the paths
+func (server *WebServer) WithSecurityHeadersFor(prefix string, headers *SecurityHeaders) *WebServer {
+	if server.headerOverrides == nil {
+		server.headerOverrides = make(map[string]*SecurityHeaders)
+	}
+
+	server.headerOverrides[prefix] = headers
+	return server
+}
+
+// cspReportRouter serves the CSP report endpoints before the session and security
+// handlers, as the browsers send the reports without the credentials of the page
+type cspReportRouter struct {
+	collectors map[string]http.Handler
+	pipeline   http.Handler
+}
+
+// ServeHTTP serves incoming http request
+func (router *cspReportRouter) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if collector, ok := router.collectors[request.URL.Path]; ok {
+		collector.ServeHTTP(writer, request)
+		return
+	}
+
+	router.pipeline.ServeHTTP(writer, request)
+}
+
+// WithCSPReportCollector sets up an endpoint at path to collect the CSP violation reports,
+// both report-uri and Reporting API formats are accepted, the reports are logged if the
+// collector is nil, set SecurityHeaders.CSPReportURI to the path to receive the reports.
+// The endpoint is served like a webhook, without session or user providers, so that the
+// reports from anonymous browsers are accepted
+func (server *WebServer) WithCSPReportCollector(path string, collector CSPReportCollector) *WebServer {
+	if collector == nil {
+		collector = logCSPReport
+	}
+
+	if server.cspCollectors == nil {
+		server.cspCollectors = make(map[string]http.Handler)
+	}
+
+	server.cspCollectors[path] = http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost {
+			writer.Header().Set("Allow", http.MethodPost)
+			SendError(writer, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		reports, err := readCSPReports(http.MaxBytesReader(writer, request.Body, MaxCSPReportSize))
+		if err != nil {
+			zap.L().Warn("badCSPReport", zap.Error(err), zap.String("activityId", GetTraceID(request.Context())))
+			SendError(writer, http.StatusBadRequest, "Bad request")
+			return
+		}
+
+		for _, report := range reports {
+			collector(request, report)
+		}
+
+		writer.WriteHeader(http.StatusNoContent)
+	})
+
+	return server
+}
+
+// readCSPReports reads the reports from the body, which is either a report-uri
+// report or an array of Reporting API reports
+func readCSPReports(body io.Reader) ([]*CSPReport, error) {
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var items []*reportingAPIReport
+		if err = json.Unmarshal(data, &items); err != nil {
+			return nil, err
+		}
+
+		reports := make([]*CSPReport, 0, len(items))
+		for _, item := range items {
+			if item.Type != "csp-violation" {
+				continue
+			}
+
+			reports = append(reports, &CSPReport{
+				DocumentURI:        item.Body.DocumentURL,
+				Referrer:           item.Body.Referrer,
+				BlockedURI:         item.Body.BlockedURL,
+				ViolatedDirective:  item.Body.EffectiveDirective,
+				EffectiveDirective: item.Body.EffectiveDirective,
+				OriginalPolicy:     item.Body.OriginalPolicy,
+				Disposition:        item.Body.Disposition,
+				SourceFile:         item.Body.SourceFile,
+				LineNumber:         item.Body.LineNumber,
+				ColumnNumber:       item.Body.ColumnNumber,
+				StatusCode:         item.Body.StatusCode,
+				ScriptSample:       item.Body.Sample,
+			})
+		}
+
+		return reports, nil
+	}
+
+	var legacy struct {
+		Report *CSPReport `json:"csp-report"`
+	}
+
+	if err = json.Unmarshal(data, &legacy); err != nil {
+		return nil, err
+	}
+
+	if legacy.Report == nil {
+		return nil, nil
+	}
+
+	return []*CSPReport{legacy.Report}, nil
+}
+
+func logCSPReport(request *http.Request, report *CSPReport) {
+	zap.L().Warn("cspViolation",
+		zap.String("documentUri", report.DocumentURI),
+		zap.String("blockedUri", report.BlockedURI),
+		zap.String("directive", report.EffectiveDirective),
+		zap.String("sourceFile", report.SourceFile),
+		zap.Int("lineNumber", report.LineNumber),
+		zap.String("disposition", report.Disposition),
+		zap.String("clientIp", GetClientIP(request)),
+		zap.String("activityId", GetTraceID(request.Context())))
+}
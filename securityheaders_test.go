@@ -0,0 +1,128 @@
+package cypress
+
+import (
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+type TestScriptController struct{}
+
+func (c *TestScriptController) Page(request *http.Request, response *Response) {
+	response.DoneWithTemplate(http.StatusOK, "script", nil)
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "cyheaderstest")
+	if err != nil {
+		t.Error("failed to create test dir", err)
+		return
+	}
+
+	defer os.RemoveAll(testDir)
+	err = ioutil.WriteFile(path.Join(testDir, "script.tmpl"), []byte(`{{define "script"}}<script nonce="{{cspNonce}}"></script>{{end}}`), os.ModePerm)
+	if err != nil {
+		t.Error("failed to setup script.tmpl")
+		return
+	}
+
+	tmplMgr := NewTemplateManager(testDir, ".tmpl", time.Minute, func(root *template.Template) {}, nil)
+	defer tmplMgr.Close()
+
+	reports := make([]*CSPReport, 0, 2)
+	headers := DefaultSecurityHeaders()
+	headers.CSPReportURI = "/csp-report"
+	server := NewWebServer(":8099", NewSkinManager(tmplMgr))
+	server.WithStandardRouting("/web")
+	server.RegisterController("test", AsController(&TestScriptController{}))
+	server.WithSecurityHeaders(headers)
+	server.WithSecurityHeadersFor("/web/embed", &SecurityHeaders{FrameOptions: "DENY"})
+	server.WithSecurityHeadersFor("/raw", nil)
+	server.WithCSPReportCollector("/csp-report", func(request *http.Request, report *CSPReport) {
+		reports = append(reports, report)
+	})
+	server.HandleFunc("/raw", func(writer http.ResponseWriter, request *http.Request) {})
+	authz := NewRoleBasedAuthz()
+	authz.AddRule(&AccessRule{Path: "/web/**", Anonymous: true})
+	authz.AddRule(&AccessRule{Path: "/raw", Anonymous: true})
+	server.WithAuthz(authz)
+
+	front := httptest.NewServer(server.pipeline(true))
+	defer front.Close()
+
+	resp, err := http.Get(front.URL + "/web/test/page")
+	if err != nil {
+		t.Error("failed to get page", err)
+		return
+	}
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	policy := resp.Header.Get("Content-Security-Policy")
+	start := strings.Index(string(body), `nonce="`)
+	if start < 0 || resp.Header.Get("X-Frame-Options") != "SAMEORIGIN" || resp.Header.Get("X-Content-Type-Options") != "nosniff" || resp.Header.Get("Strict-Transport-Security") != "" {
+		t.Error("unexpected headers", resp.Header, string(body))
+		return
+	}
+
+	nonce := string(body)[start+7 : start+7+strings.Index(string(body)[start+7:], `"`)]
+	if nonce == "" || !strings.Contains(policy, "'nonce-"+nonce+"'") || !strings.HasSuffix(policy, "; report-uri /csp-report") {
+		t.Error("expecting the nonce in the policy", nonce, policy)
+		return
+	}
+
+	resp, _ = http.Get(front.URL + "/web/test/page")
+	resp.Body.Close()
+	if resp.Header.Get("Content-Security-Policy") == policy {
+		t.Error("expecting a new nonce for each request")
+		return
+	}
+
+	resp, _ = http.Get(front.URL + "/web/embed/page")
+	resp.Body.Close()
+	if resp.Header.Get("X-Frame-Options") != "DENY" || resp.Header.Get("Content-Security-Policy") != "" {
+		t.Error("expecting the override to be applied", resp.Header)
+		return
+	}
+
+	resp, _ = http.Get(front.URL + "/raw")
+	resp.Body.Close()
+	if resp.Header.Get("X-Frame-Options") != "" {
+		t.Error("expecting no security headers", resp.Header)
+		return
+	}
+
+	resp, _ = http.Post(front.URL+"/private", "application/csp-report", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Error("expecting anonymous users to be denied but got", resp.StatusCode)
+		return
+	}
+
+	// reports are sent by browsers without session or credentials
+	resp, _ = http.Post(front.URL+"/csp-report", "application/csp-report", strings.NewReader(`{"csp-report":{"document-uri":"http://localhost/","blocked-uri":"inline","effective-directive":"script-src-elem","line-number":3}}`))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Error("expecting 204 but got", resp.StatusCode)
+		return
+	}
+
+	resp, _ = http.Post(front.URL+"/csp-report", "application/reports+json", strings.NewReader(`[{"type":"csp-violation","body":{"documentURL":"http://localhost/","blockedURL":"eval","effectiveDirective":"script-src"}},{"type":"deprecation","body":{}}]`))
+	resp.Body.Close()
+	if len(reports) != 2 || reports[0].BlockedURI != "inline" || reports[0].LineNumber != 3 || reports[1].BlockedURI != "eval" || reports[1].EffectiveDirective != "script-src" {
+		t.Error("unexpected reports", reports)
+		return
+	}
+
+	resp, _ = http.Post(front.URL+"/csp-report", "application/csp-report", strings.NewReader(`not json`))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Error("expecting 400 but got", resp.StatusCode)
+	}
+}
@@ -3,11 +3,14 @@ package cypress
 import (
 	"bytes"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/gob"
 	"errors"
 	"io"
 	"net/http"
+	"net/url"
+	"strings"
 	"sync"
 	"time"
 
@@ -118,6 +121,13 @@ func (session *Session) NeedSave() bool {
 	return session.isDirty
 }
 
+// Len returns the number of values in the session
+func (session *Session) Len() int {
+	session.lock.RLock()
+	defer session.lock.RUnlock()
+	return len(session.data)
+}
+
 // GetSession helper function for getting session from request
 func GetSession(request *http.Request) *Session {
 	value := request.Context().Value(SessionKey)
@@ -147,6 +157,21 @@ type SessionStore interface {
 	Close()
 }
 
+// SessionInfo summary of a session in the session store
+type SessionInfo struct {
+	ID         string    `json:"id"`
+	Expiration time.Time `json:"expiration"`
+	Values     int       `json:"values"`
+}
+
+// EnumerableSessionStore a session store that could list the sessions it has
+type EnumerableSessionStore interface {
+	SessionStore
+
+	// List lists the sessions that are not expired
+	List() ([]*SessionInfo, error)
+}
+
 type sessionHandler struct {
 	store    SessionStore
 	pipeline http.Handler
@@ -180,6 +205,11 @@ func (handler *sessionHandler) ServeHTTP(writer http.ResponseWriter, request *ht
 
 	request.Context().(*multiValueCtx).withValue(SessionKey, session)
 	defer func() {
+		// the session could be replaced during the request, e.g. renewed by login
+		if current := GetSession(request); current != nil {
+			session = current
+		}
+
 		if session.NeedSave() {
 			saveError := handler.store.Save(session, handler.timeout)
 			if saveError != nil {
@@ -202,3 +232,67 @@ func NewSessionHandler(pipeline http.Handler, store SessionStore, timeout time.D
 
 	return &sessionHandler{store, pipeline, timeout}
 }
+
+// sessionCSRFToken gets the CSRF token saved in the session with the key, a new
+// token is created if the session does not have one, returns empty string if
+// there is no session
+func sessionCSRFToken(request *http.Request, key string) string {
+	session := GetSession(request)
+	if session == nil {
+		return ""
+	}
+
+	if value, ok := session.GetValue(key); ok {
+		if token, ok := value.(string); ok {
+			return token
+		}
+	}
+
+	token := NewSessionID()
+	session.SetValue(key, token)
+	return token
+}
+
+// checkSessionCSRFToken checks the token sent by the header or the form field
+// matches the CSRF token saved in the session with the key
+func checkSessionCSRFToken(request *http.Request, key, header, field string) bool {
+	session := GetSession(request)
+	if session == nil {
+		return false
+	}
+
+	expected, ok := session.GetValue(key)
+	if !ok {
+		return false
+	}
+
+	token := request.Header.Get(header)
+	if token == "" {
+		token = request.FormValue(field)
+	}
+
+	value, _ := expected.(string)
+	return value != "" && subtle.ConstantTimeCompare([]byte(value), []byte(token)) == 1
+}
+
+// checkSameOrigin checks the Origin, or the Referer if there is no Origin, of the
+// request matches the host of the request, sent tells if either header is sent
+func checkSameOrigin(request *http.Request) (sent, same bool) {
+	origin := request.Header.Get("Origin")
+	if origin == "" {
+		origin = request.Header.Get("Referer")
+	}
+
+	if origin == "" {
+		return false, false
+	}
+
+	u, err := url.Parse(origin)
+	return true, err == nil && strings.EqualFold(u.Host, request.Host)
+}
+
+// hasSessionCookie checks if the client sends the cookie of an existing session
+func hasSessionCookie(request *http.Request) bool {
+	_, err := request.Cookie(sessionIDCookieKey)
+	return err == nil && GetSession(request) != nil
+}
@@ -158,6 +158,27 @@ func (store *fileSessionStore) readSession(id string) (*fileSessionItem, error)
 	return &sessionItem, nil
 }
 
+// List implements EnumerableSessionStore's List api
+func (store *fileSessionStore) List() ([]*SessionInfo, error) {
+	files, err := ioutil.ReadDir(store.path)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	result := make([]*SessionInfo, 0, len(files))
+	for _, file := range files {
+		item, _ := store.readSession(file.Name())
+		if item != nil && item.Expiration.After(now) {
+			session := NewSession(file.Name())
+			session.Deserialize(item.Data)
+			result = append(result, &SessionInfo{file.Name(), item.Expiration, session.Len()})
+		}
+	}
+
+	return result, nil
+}
+
 func (store *fileSessionStore) doGC() {
 	files, err := ioutil.ReadDir(store.path)
 	if err != nil {
@@ -84,6 +84,21 @@ func (store *inMemorySessionStore) Get(id string) (*Session, error) {
 	return item.session, nil
 }
 
+// List implements EnumerableSessionStore's List api
+func (store *inMemorySessionStore) List() ([]*SessionInfo, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	now := time.Now()
+	result := make([]*SessionInfo, 0, len(store.sessions))
+	for id, item := range store.sessions {
+		if item.expiration.After(now) {
+			result = append(result, &SessionInfo{id, item.expiration, item.session.Len()})
+		}
+	}
+
+	return result, nil
+}
+
 func (store *inMemorySessionStore) doGC() {
 	keysToRemove := make([]string, 0)
 	func() {
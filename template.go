@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -18,10 +19,33 @@ var (
 	//ErrNoFile no file given for Creating a template
 	ErrNoFile = errors.New("No template file")
 
+	//ErrTemplateNotFound no template with the given name
+	ErrTemplateNotFound = errors.New("template not found")
+
 	//SkinDefault default skin name
 	SkinDefault = "default"
+
+	// defaultTemplateFuncs functions available to all templates, which
+	// could be overridden for each execution, see Response.DoneWithTemplate
+	defaultTemplateFuncs = template.FuncMap{
+		"feature": func(name string) bool {
+			return false
+		},
+		"T": func(key string, args ...interface{}) string {
+			return key
+		},
+		"cspNonce": func() string {
+			return ""
+		},
+	}
 )
 
+// TemplateInfo the name of a template and the time it's loaded
+type TemplateInfo struct {
+	Name     string    `json:"name"`
+	LoadedAt time.Time `json:"loadedAt"`
+}
+
 type templateFileInfo struct {
 	file        string
 	lastModifed time.Time
@@ -41,6 +65,9 @@ type TemplateManager struct {
 	lock                   *sync.RWMutex
 	shared                 *template.Template
 	templates              map[string]*template.Template
+	executables            map[string]*template.Template
+	loadedAt               map[string]time.Time
+	refreshLock            *sync.Mutex
 	fileLock               *sync.RWMutex
 	files                  map[string]time.Time
 	sharedFiles            []string
@@ -112,7 +139,7 @@ func NewTemplateManager(dir, suffix string, refreshInterval time.Duration, confi
 		}
 	}
 
-	shared := template.New("cypress$shared$root")
+	shared := template.New("cypress$shared$root").Funcs(defaultTemplateFuncs)
 	if configFunc != nil {
 		configFunc(shared)
 	}
@@ -127,6 +154,7 @@ func NewTemplateManager(dir, suffix string, refreshInterval time.Duration, confi
 	}
 
 	templates := make(map[string]*template.Template)
+	loadedAt := make(map[string]time.Time)
 	for _, file := range tmplFiles {
 		key := strings.Trim(strings.TrimPrefix(strings.TrimSuffix(file, filepath.Ext(file)), dir), "/\\")
 		tmpl, err := shared.Clone()
@@ -138,6 +166,7 @@ func NewTemplateManager(dir, suffix string, refreshInterval time.Duration, confi
 				zap.L().Error("failed to parse template file", zap.Error(err), zap.String("file", file))
 			} else {
 				templates[key] = tmpl
+				loadedAt[key] = time.Now()
 			}
 		}
 	}
@@ -147,6 +176,9 @@ func NewTemplateManager(dir, suffix string, refreshInterval time.Duration, confi
 		lock:                   &sync.RWMutex{},
 		shared:                 shared,
 		templates:              templates,
+		executables:            make(map[string]*template.Template),
+		loadedAt:               loadedAt,
+		refreshLock:            &sync.Mutex{},
 		fileLock:               &sync.RWMutex{},
 		files:                  filesTime,
 		sharedFiles:            sharedFiles,
@@ -187,7 +219,74 @@ func (manager *TemplateManager) GetTemplate(name string) (*template.Template, bo
 	return result, ok
 }
 
+// getExecutable returns a clone of the template that is reserved for being executed
+// without request bound functions, the clone is created once per template set, so that
+// the template returned by GetTemplate stays clonable
+func (manager *TemplateManager) getExecutable(name string) (*template.Template, error) {
+	manager.lock.RLock()
+	result, ok := manager.executables[name]
+	tmpl, found := manager.templates[name]
+	manager.lock.RUnlock()
+	if ok {
+		return result, nil
+	}
+
+	if !found {
+		return nil, ErrTemplateNotFound
+	}
+
+	result, err := tmpl.Clone()
+	if err != nil {
+		return nil, err
+	}
+
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	if manager.templates[name] != tmpl {
+		// the template is reparsed while cloning, don't cache the stale clone
+		return result, nil
+	}
+
+	if existing, ok := manager.executables[name]; ok {
+		return existing, nil
+	}
+
+	manager.executables[name] = result
+	return result, nil
+}
+
+// Templates returns the names of the templates and the time they are loaded
+func (manager *TemplateManager) Templates() []*TemplateInfo {
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+	result := make([]*TemplateInfo, 0, len(manager.templates))
+	for name := range manager.templates {
+		result = append(result, &TemplateInfo{name, manager.loadedAt[name]})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result
+}
+
+// Reload reloads all templates regardless of whether the files are changed
+func (manager *TemplateManager) Reload() {
+	func() {
+		manager.fileLock.Lock()
+		defer manager.fileLock.Unlock()
+		for file := range manager.files {
+			manager.files[file] = time.Time{}
+		}
+	}()
+
+	manager.refreshTemplates()
+}
+
 func (manager *TemplateManager) refreshTemplates() {
+	manager.refreshLock.Lock()
+	defer manager.refreshLock.Unlock()
 	files := make([]string, 0, len(manager.files))
 	func() {
 		manager.fileLock.RLock()
@@ -240,7 +339,7 @@ func (manager *TemplateManager) refreshTemplates() {
 	}
 
 	if reparseAll && len(manager.sharedFiles) > 0 {
-		shared := template.New("cypress$shared$root")
+		shared := template.New("cypress$shared$root").Funcs(defaultTemplateFuncs)
 		if manager.configFunc != nil {
 			manager.configFunc(shared)
 		}
@@ -271,6 +370,8 @@ func (manager *TemplateManager) refreshTemplates() {
 						manager.lock.Lock()
 						defer manager.lock.Unlock()
 						manager.templates[name] = tmpl
+						delete(manager.executables, name)
+						manager.loadedAt[name] = time.Now()
 					}()
 					zap.L().Info("template file reparsed", zap.String("file", file))
 				}
@@ -284,6 +385,23 @@ func NewSkinManager(defaultSkin *TemplateManager) *SkinManager {
 	return &SkinManager{defaultSkin, make(map[string]*TemplateManager), &sync.RWMutex{}, nil}
 }
 
+// Skins returns the skins by names, the default skin is included as SkinDefault
+// unless there is a skin with that name
+func (skinMgr *SkinManager) Skins() map[string]*TemplateManager {
+	skinMgr.lock.RLock()
+	defer skinMgr.lock.RUnlock()
+	result := make(map[string]*TemplateManager)
+	if skinMgr.defaultSkin != nil {
+		result[SkinDefault] = skinMgr.defaultSkin
+	}
+
+	for name, skin := range skinMgr.skins {
+		result[name] = skin
+	}
+
+	return result
+}
+
 // AddSkin adds a skin
 func (skinMgr *SkinManager) AddSkin(name string, tmplMgr *TemplateManager) {
 	skinMgr.lock.Lock()
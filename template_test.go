@@ -299,3 +299,52 @@ func TestSkinManager(t *testing.T) {
 		return
 	}
 }
+
+func TestTemplateExecutable(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "cytplexectest")
+	if err != nil {
+		t.Error("failed to create test dir", err)
+		return
+	}
+
+	defer os.RemoveAll(testDir)
+	err = ioutil.WriteFile(path.Join(testDir, "page.tmpl"), []byte(`{{define "page"}}{{.}}{{end}}`), os.ModePerm)
+	if err != nil {
+		t.Error("failed to setup page.tmpl")
+		return
+	}
+
+	tmplMgr := NewTemplateManager(testDir, ".tmpl", time.Minute, func(root *template.Template) {}, nil)
+	defer tmplMgr.Close()
+	executable, err := tmplMgr.getExecutable("page")
+	if err != nil {
+		t.Error("failed to get the executable template", err)
+		return
+	}
+
+	if err = executable.ExecuteTemplate(ioutil.Discard, "page", "content"); err != nil {
+		t.Error("failed to execute the template", err)
+		return
+	}
+
+	if cached, _ := tmplMgr.getExecutable("page"); cached != executable {
+		t.Error("expecting the executable template to be cached")
+		return
+	}
+
+	tmpl, _ := tmplMgr.GetTemplate("page")
+	if _, err = tmpl.Clone(); err != nil {
+		t.Error("expecting the template to stay clonable", err)
+		return
+	}
+
+	tmplMgr.Reload()
+	if reloaded, _ := tmplMgr.getExecutable("page"); reloaded == executable {
+		t.Error("expecting the executable template to be dropped on reload")
+		return
+	}
+
+	if _, err = tmplMgr.getExecutable("missing"); err != ErrTemplateNotFound {
+		t.Error("expecting template not found but got", err)
+	}
+}
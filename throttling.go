@@ -0,0 +1,142 @@
+package cypress
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	// TimeoutMsg message to be shown when an action is timed out
+	TimeoutMsg = "Sorry, the server is not able to complete your request in time"
+
+	// ServerBusyMsg message to be shown when a request is rejected due to too many concurrent requests
+	ServerBusyMsg = "Sorry, the server is too busy to handle your request, please try again later"
+)
+
+type concurrencyLimitHandler struct {
+	semaphore  chan struct{}
+	retryAfter time.Duration
+	pipeline   http.Handler
+}
+
+// ServeHTTP serves incoming http request
+func (handler *concurrencyLimitHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	select {
+	case handler.semaphore <- struct{}{}:
+		defer func() {
+			<-handler.semaphore
+		}()
+
+		handler.pipeline.ServeHTTP(writer, request)
+	default:
+		zap.L().Warn("requestRejected", zap.String("path", request.URL.Path), zap.String("activityId", GetTraceID(request.Context())))
+		sendServerBusy(writer, handler.retryAfter)
+	}
+}
+
+// NewConcurrencyLimitHandler creates a handler that allows at most limit requests to be
+// served concurrently by the pipeline, excess requests are rejected with a 503 status and
+// a Retry-After header instead of being queued
+func NewConcurrencyLimitHandler(pipeline http.Handler, limit int, retryAfter time.Duration) http.Handler {
+	return &concurrencyLimitHandler{make(chan struct{}, limit), retryAfter, pipeline}
+}
+
+func sendServerBusy(writer http.ResponseWriter, retryAfter time.Duration) {
+	setRetryAfter(writer, retryAfter)
+	SendError(writer, http.StatusServiceUnavailable, ServerBusyMsg)
+}
+
+func setRetryAfter(writer http.ResponseWriter, retryAfter time.Duration) int {
+	seconds := int(retryAfter.Seconds())
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	writer.Header().Set("Retry-After", strconv.Itoa(seconds))
+	return seconds
+}
+
+// WithConcurrencyLimit creates a ControllerOption that allows at most limit requests to be
+// handled concurrently by each of the given actions, or all actions of the controller if no
+// action is given, excess requests are rejected with a 503 status and a Retry-After header
+func WithConcurrencyLimit(limit int, retryAfter time.Duration, actions ...string) ControllerOption {
+	return ControllerOption(func(controller string, action Action) Action {
+		if !containsAction(actions, action.Name) {
+			return action
+		}
+
+		semaphore := make(chan struct{}, limit)
+		handler := action.Handler
+		action.Handler = ActionHandler(func(request *http.Request, response *Response) {
+			select {
+			case semaphore <- struct{}{}:
+				defer func() {
+					<-semaphore
+				}()
+
+				handler(request, response)
+			default:
+				zap.L().Warn("actionRejected", zap.String("controller", controller), zap.String("action", action.Name), zap.String("activityId", response.traceID))
+				sendServerBusy(response.writer, retryAfter)
+			}
+		})
+
+		return action
+	})
+}
+
+// WithTimeout creates a ControllerOption that limits the execution time of the given actions,
+// or all actions of the controller if no action is given. The request context is cancelled
+// after timeout and a 504 status is sent to the client, while the output of the action, if
+// any, is discarded. The action should watch the request context to stop its work.
+func WithTimeout(timeout time.Duration, actions ...string) ControllerOption {
+	return ControllerOption(func(controller string, action Action) Action {
+		if !containsAction(actions, action.Name) {
+			return action
+		}
+
+		handler := action.Handler
+		action.Handler = ActionHandler(func(request *http.Request, response *Response) {
+			ctx, cancel := context.WithTimeout(request.Context(), timeout)
+			defer cancel()
+
+			recorder := newOutputRecorder()
+			timedRequest := request.WithContext(extentContext(ctx))
+			timedResponse := *response
+			timedResponse.writer = recorder
+			timedResponse.request = timedRequest
+			done := make(chan interface{}, 1)
+			go func() {
+				defer func() {
+					done <- recover()
+				}()
+
+				handler(timedRequest, &timedResponse)
+				timedResponse.flush()
+			}()
+
+			select {
+			case err := <-done:
+				if err != nil {
+					// let the logging handler to log the panic
+					panic(err)
+				}
+
+				recorder.output().WriteTo(response.writer)
+			case <-ctx.Done():
+				zap.L().Warn("actionTimeout", zap.String("controller", controller), zap.String("action", action.Name), zap.Error(ctx.Err()), zap.String("activityId", response.traceID))
+				if ctx.Err() == context.DeadlineExceeded {
+					SendError(response.writer, http.StatusGatewayTimeout, Localize(request, TimeoutMsgKey, TimeoutMsg))
+				} else {
+					SendError(response.writer, http.StatusServiceUnavailable, Localize(request, TimeoutMsgKey, TimeoutMsg))
+				}
+			}
+		})
+
+		return action
+	})
+}
@@ -0,0 +1,84 @@
+package cypress
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestActionTimeout(t *testing.T) {
+	option := WithTimeout(time.Millisecond*50, "slow")
+	slow := option("test", Action{
+		Name: "slow",
+		Handler: ActionHandler(func(request *http.Request, response *Response) {
+			<-request.Context().Done()
+			response.DoneWithContent(http.StatusOK, "text/plain", []byte("slow"))
+		}),
+	})
+	fast := option("test", Action{
+		Name: "fast",
+		Handler: ActionHandler(func(request *http.Request, response *Response) {
+			response.DoneWithContent(http.StatusOK, "text/plain", []byte("fast"))
+		}),
+	})
+
+	request := httptest.NewRequest(http.MethodGet, "/web/test/slow", nil)
+	request = request.WithContext(extentContext(request.Context()))
+	writer := httptest.NewRecorder()
+	slow.Handler(request, &Response{writer: writer})
+	if writer.Code != http.StatusGatewayTimeout {
+		t.Error("expecting 504 but got", writer.Code)
+		return
+	}
+
+	writer = httptest.NewRecorder()
+	fast.Handler(request, &Response{writer: writer})
+	if writer.Code != http.StatusOK || writer.Body.String() != "fast" {
+		t.Error("expecting fast to be served but got", writer.Code, writer.Body.String())
+		return
+	}
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	started := make(chan bool)
+	release := make(chan bool)
+	action := WithConcurrencyLimit(1, time.Second*5)("test", Action{
+		Name: "index",
+		Handler: ActionHandler(func(request *http.Request, response *Response) {
+			started <- true
+			<-release
+			response.DoneWithContent(http.StatusOK, "text/plain", []byte("index"))
+		}),
+	})
+
+	request := httptest.NewRequest(http.MethodGet, "/web/test/index", nil)
+	finished := make(chan bool)
+	go func() {
+		action.Handler(request, &Response{writer: httptest.NewRecorder()})
+		finished <- true
+	}()
+
+	<-started
+
+	writer := httptest.NewRecorder()
+	action.Handler(request, &Response{writer: writer})
+	if writer.Code != http.StatusServiceUnavailable || writer.Header().Get("Retry-After") != "5" {
+		t.Error("expecting 503 with Retry-After but got", writer.Code, writer.Header().Get("Retry-After"))
+		return
+	}
+
+	release <- true
+	<-finished
+	go func() {
+		<-started
+		release <- true
+	}()
+
+	writer = httptest.NewRecorder()
+	action.Handler(request, &Response{writer: writer})
+	if writer.Code != http.StatusOK {
+		t.Error("expecting 200 but got", writer.Code)
+		return
+	}
+}
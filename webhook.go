@@ -0,0 +1,353 @@
+package cypress
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"go.uber.org/zap"
+)
+
+const (
+	redisNonceKeyPrefix = "cypress$nonce$"
+)
+
+var (
+	// WebhookMaxPayloadSize the max size of webhook payloads in bytes
+	WebhookMaxPayloadSize int64 = 1 << 20
+
+	// WebhookNonceTTL how long the nonces are remembered if the signature scheme
+	// has no timestamp tolerance
+	WebhookNonceTTL = 24 * time.Hour
+)
+
+// WebhookHandler handles the payload of a webhook request after it's verified,
+// a non-nil error is converted to an HTTPError and sent to the caller as json
+type WebhookHandler func(request *http.Request, payload []byte) error
+
+// NonceCache remembers the nonces that have been seen for replay protection
+type NonceCache interface {
+	// Add adds the nonce to the cache for ttl, returns false if the nonce is
+	// already in the cache
+	Add(nonce string, ttl time.Duration) (bool, error)
+
+	// Remove removes the nonce from the cache
+	Remove(nonce string) error
+}
+
+// SignatureScheme describes how a partner signs the webhook requests
+type SignatureScheme struct {
+	// SignatureHeader the header that carries the signature
+	SignatureHeader string
+
+	// Prefix the prefix to be removed from the signature header value, e.g. sha256=
+	Prefix string
+
+	// Hmac the HMAC function, e.g. HmacSha256
+	Hmac func(key, data []byte) []byte
+
+	// Base64 the signature is base64 encoded instead of hex encoded
+	Base64 bool
+
+	// TimestampHeader the optional header that carries the unix time, in seconds,
+	// when the request is signed
+	TimestampHeader string
+
+	// Tolerance the max difference between the timestamp and now
+	Tolerance time.Duration
+
+	// NonceHeader the optional header that carries the unique id of the request,
+	// the id is remembered in addition to the signature, since the header is not
+	// signed, a replayed request with a new id is still rejected by its signature
+	NonceHeader string
+
+	// SignedPayload builds the data to be signed from the timestamp and body, the
+	// body is signed if not set, or timestamp.body if the scheme has a TimestampHeader
+	SignedPayload func(timestamp string, body []byte) []byte
+}
+
+// Webhook an endpoint that receives signed requests from a partner
+type Webhook struct {
+	path    string
+	scheme  *SignatureScheme
+	secrets [][]byte
+	nonces  NonceCache
+	handler WebhookHandler
+}
+
+type inMemoryNonceCache struct {
+	lock      *sync.Mutex
+	nonces    map[string]time.Time
+	lastSweep time.Time
+}
+
+type redisNonceCache struct {
+	redisDb *redis.Client
+}
+
+type webhookRouter struct {
+	webhooks map[string]*Webhook
+	pipeline http.Handler
+}
+
+// HmacSha256Scheme creates a scheme that signs the body with HMAC-SHA256 into a hex encoded
+// signature in the given header with the prefix, e.g. "X-Hub-Signature-256" and "sha256="
+func HmacSha256Scheme(header, prefix string) *SignatureScheme {
+	return &SignatureScheme{SignatureHeader: header, Prefix: prefix, Hmac: HmacSha256}
+}
+
+// HmacSha1Scheme creates a scheme that signs the body with HMAC-SHA1 into a hex encoded
+// signature in the given header with the prefix, e.g. "X-Hub-Signature" and "sha1="
+func HmacSha1Scheme(header, prefix string) *SignatureScheme {
+	return &SignatureScheme{SignatureHeader: header, Prefix: prefix, Hmac: HmacSha1}
+}
+
+// WithTimestamp requires the timestamp in the header to be within the tolerance of now
+func (scheme *SignatureScheme) WithTimestamp(header string, tolerance time.Duration) *SignatureScheme {
+	scheme.TimestampHeader = header
+	scheme.Tolerance = tolerance
+	return scheme
+}
+
+// NewInMemoryNonceCache creates a nonce cache in memory, the expired nonces are swept
+// when new nonces are added
+func NewInMemoryNonceCache() NonceCache {
+	return &inMemoryNonceCache{&sync.Mutex{}, make(map[string]time.Time), time.Now()}
+}
+
+// Add implements NonceCache
+func (cache *inMemoryNonceCache) Add(nonce string, ttl time.Duration) (bool, error) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	now := time.Now()
+	if now.Sub(cache.lastSweep) > time.Minute {
+		for key, expiry := range cache.nonces {
+			if now.After(expiry) {
+				delete(cache.nonces, key)
+			}
+		}
+
+		cache.lastSweep = now
+	}
+
+	if expiry, ok := cache.nonces[nonce]; ok && now.Before(expiry) {
+		return false, nil
+	}
+
+	cache.nonces[nonce] = now.Add(ttl)
+	return true, nil
+}
+
+// Remove implements NonceCache
+func (cache *inMemoryNonceCache) Remove(nonce string) error {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	delete(cache.nonces, nonce)
+	return nil
+}
+
+// NewRedisNonceCache creates a redis based nonce cache, which could be shared by
+// the instances of the web server
+func NewRedisNonceCache(cli *redis.Client) NonceCache {
+	return &redisNonceCache{cli}
+}
+
+// Add implements NonceCache
+func (cache *redisNonceCache) Add(nonce string, ttl time.Duration) (bool, error) {
+	return cache.redisDb.SetNX(redisNonceKeyPrefix+nonce, 1, ttl).Result()
+}
+
+// Remove implements NonceCache
+func (cache *redisNonceCache) Remove(nonce string) error {
+	return cache.redisDb.Del(redisNonceKeyPrefix + nonce).Err()
+}
+
+// AddWebhook adds a webhook endpoint at the path, the requests are verified by the scheme
+// with the secret, replays are rejected with a nonce cache in memory by default. Webhook
+// requests bypass the session, custom and security handlers, as the callers are
+// authenticated by the signatures
+func (server *WebServer) AddWebhook(path string, scheme *SignatureScheme, secret []byte, handler WebhookHandler) *Webhook {
+	webhook := &Webhook{
+		path:    path,
+		scheme:  scheme,
+		secrets: [][]byte{secret},
+		nonces:  NewInMemoryNonceCache(),
+		handler: handler,
+	}
+
+	if server.webhooks == nil {
+		server.webhooks = make(map[string]*Webhook)
+	}
+
+	server.webhooks[path] = webhook
+	return webhook
+}
+
+// WithSecret adds another secret that is accepted, for secret rotation
+func (webhook *Webhook) WithSecret(secret []byte) *Webhook {
+	webhook.secrets = append(webhook.secrets, secret)
+	return webhook
+}
+
+// WithNonceCache replaces the nonce cache of the webhook
+func (webhook *Webhook) WithNonceCache(cache NonceCache) *Webhook {
+	webhook.nonces = cache
+	return webhook
+}
+
+// ServeHTTP serves incoming http request
+func (webhook *Webhook) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	response := &Response{
+		traceID: GetTraceID(request.Context()),
+		request: request,
+		writer:  writer,
+	}
+
+	if request.Method != http.MethodPost {
+		writer.Header().Set("Allow", http.MethodPost)
+		response.DoneWithJSON(http.StatusMethodNotAllowed, NewHTTPError(http.StatusMethodNotAllowed, "Method not allowed", nil))
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(writer, request.Body, WebhookMaxPayloadSize))
+	if err != nil {
+		response.DoneWithJSON(http.StatusRequestEntityTooLarge, NewHTTPError(http.StatusRequestEntityTooLarge, "Payload too large", nil))
+		return
+	}
+
+	signature, err := webhook.verify(request, body)
+	if err != nil {
+		zap.L().Warn("webhookRejected", zap.String("path", webhook.path), zap.Error(err), zap.String("activityId", response.traceID))
+		response.doneWithHTTPError(err, true)
+		return
+	}
+
+	nonceKeys := []string{webhook.path + "$" + signature}
+	if webhook.scheme.NonceHeader != "" {
+		nonce := request.Header.Get(webhook.scheme.NonceHeader)
+		if nonce == "" {
+			response.DoneWithJSON(http.StatusBadRequest, NewHTTPError(http.StatusBadRequest, "Missing nonce", nil))
+			return
+		}
+
+		nonceKeys = append(nonceKeys, webhook.path+"$id$"+nonce)
+	}
+
+	ttl := WebhookNonceTTL
+	if webhook.scheme.Tolerance > 0 {
+		// a replay older than the tolerance is rejected by the timestamp check
+		ttl = 2 * webhook.scheme.Tolerance
+	}
+
+	for i, nonceKey := range nonceKeys {
+		added, err := webhook.nonces.Add(nonceKey, ttl)
+		if err != nil || !added {
+			webhook.removeNonces(nonceKeys[:i], response.traceID)
+		}
+
+		if err != nil {
+			zap.L().Error("nonceCacheFailure", zap.Error(err), zap.String("activityId", response.traceID))
+			response.doneWithHTTPError(err, true)
+			return
+		}
+
+		if !added {
+			zap.L().Warn("webhookReplayed", zap.String("path", webhook.path), zap.String("nonce", nonceKey), zap.String("activityId", response.traceID))
+			response.DoneWithJSON(http.StatusConflict, NewHTTPError(http.StatusConflict, "Request already received", nil))
+			return
+		}
+	}
+
+	err = webhook.handler(request, body)
+	if err != nil {
+		// the request is not processed, allow the caller to retry it
+		webhook.removeNonces(nonceKeys, response.traceID)
+		response.doneWithHTTPError(err, true)
+		return
+	}
+
+	writer.WriteHeader(http.StatusOK)
+}
+
+// removeNonces removes the nonces recorded for a request that is not processed
+func (webhook *Webhook) removeNonces(nonceKeys []string, traceID string) {
+	for _, nonceKey := range nonceKeys {
+		if err := webhook.nonces.Remove(nonceKey); err != nil {
+			zap.L().Error("nonceCacheFailure", zap.Error(err), zap.String("activityId", traceID))
+		}
+	}
+}
+
+// verify verifies the timestamp and signature of the request, returns the signature
+// in canonical hex form, so that the same signature in other encodings, e.g. in upper
+// case, could not be replayed as a new request
+func (webhook *Webhook) verify(request *http.Request, body []byte) (string, error) {
+	scheme := webhook.scheme
+	timestamp := ""
+	if scheme.TimestampHeader != "" {
+		timestamp = request.Header.Get(scheme.TimestampHeader)
+		seconds, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return "", NewHTTPError(http.StatusUnauthorized, "Bad timestamp", nil)
+		}
+
+		diff := time.Since(time.Unix(seconds, 0))
+		if diff < 0 {
+			diff = -diff
+		}
+
+		if scheme.Tolerance > 0 && diff > scheme.Tolerance {
+			return "", NewHTTPError(http.StatusUnauthorized, "Timestamp out of tolerance", nil)
+		}
+	}
+
+	value := strings.TrimPrefix(strings.TrimSpace(request.Header.Get(scheme.SignatureHeader)), scheme.Prefix)
+	var signature []byte
+	var err error
+	if scheme.Base64 {
+		signature, err = base64.StdEncoding.DecodeString(value)
+	} else {
+		signature, err = hex.DecodeString(value)
+	}
+
+	if value == "" || err != nil {
+		return "", NewHTTPError(http.StatusUnauthorized, "Bad signature", nil)
+	}
+
+	payload := body
+	if scheme.SignedPayload != nil {
+		payload = scheme.SignedPayload(timestamp, body)
+	} else if timestamp != "" {
+		payload = append([]byte(timestamp+"."), body...)
+	}
+
+	for _, secret := range webhook.secrets {
+		if hmac.Equal(scheme.Hmac(secret, payload), signature) {
+			return hex.EncodeToString(signature), nil
+		}
+	}
+
+	return "", NewHTTPError(http.StatusUnauthorized, "Signature mismatch", nil)
+}
+
+// ServeHTTP serves incoming http request
+func (router *webhookRouter) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if webhook, ok := router.webhooks[request.URL.Path]; ok {
+		webhook.ServeHTTP(writer, request)
+		return
+	}
+
+	router.pipeline.ServeHTTP(writer, request)
+}
+
+// newWebhookRouter creates a handler that serves the webhooks before the pipeline
+func newWebhookRouter(pipeline http.Handler, webhooks map[string]*Webhook) http.Handler {
+	return &webhookRouter{webhooks, pipeline}
+}
@@ -0,0 +1,103 @@
+package cypress
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebhook(t *testing.T) {
+	server := NewWebServer(":8099", nil)
+	received := make([]string, 0, 2)
+	failures := 1
+	handler := func(request *http.Request, payload []byte) error {
+		if GetSession(request) != nil {
+			return errors.New("webhooks must not have sessions")
+		}
+
+		if request.URL.Path == "/hooks/slack" && failures > 0 {
+			failures--
+			return NewHTTPError(http.StatusServiceUnavailable, "Unavailable", nil)
+		}
+
+		received = append(received, string(payload))
+		return nil
+	}
+
+	secret := []byte("secret")
+	server.AddWebhook("/hooks/github", HmacSha256Scheme("X-Hub-Signature-256", "sha256="), secret, handler)
+	server.AddWebhook("/hooks/slack", &SignatureScheme{SignatureHeader: "X-Signature", Hmac: HmacSha256, NonceHeader: "X-Delivery"}, secret, handler)
+	server.AddWebhook("/hooks/stripe", HmacSha1Scheme("X-Signature", "").WithTimestamp("X-Timestamp", time.Minute), []byte("old"), handler).WithSecret(secret)
+	pipeline := newWebhookRouter(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusTeapot)
+	}), server.webhooks)
+
+	serve := func(method, path, body string, header http.Header) int {
+		writer := httptest.NewRecorder()
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		for key, values := range header {
+			request.Header[key] = values
+		}
+
+		pipeline.ServeHTTP(writer, request.WithContext(extentContext(request.Context())))
+		return writer.Code
+	}
+
+	body := `{"event":"push"}`
+	githubHeader := http.Header{"X-Hub-Signature-256": []string{"sha256=" + hex.EncodeToString(HmacSha256(secret, []byte(body)))}}
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	stripeHeader := func(timestamp string) http.Header {
+		return http.Header{
+			"X-Timestamp": []string{timestamp},
+			"X-Signature": []string{hex.EncodeToString(HmacSha1(secret, []byte(timestamp+"."+body)))},
+		}
+	}
+
+	slackHeader := func(delivery string) http.Header {
+		return http.Header{
+			"X-Delivery":  []string{delivery},
+			"X-Signature": []string{hex.EncodeToString(HmacSha256(secret, []byte(body)))},
+		}
+	}
+
+	cases := []struct {
+		method string
+		path   string
+		body   string
+		header http.Header
+		status int
+	}{
+		{http.MethodPost, "/hooks/github", body, githubHeader, http.StatusOK},
+		{http.MethodPost, "/hooks/github", body, githubHeader, http.StatusConflict},
+		{http.MethodPost, "/hooks/github", body, http.Header{"X-Hub-Signature-256": []string{"sha256=" + strings.ToUpper(githubHeader.Get("X-Hub-Signature-256")[7:])}}, http.StatusConflict},
+		{http.MethodPost, "/hooks/slack", body, slackHeader(""), http.StatusBadRequest},
+		{http.MethodPost, "/hooks/slack", body, slackHeader("d1"), http.StatusServiceUnavailable},
+		{http.MethodPost, "/hooks/slack", body, slackHeader("d1"), http.StatusOK},
+		{http.MethodPost, "/hooks/slack", body, slackHeader("d1"), http.StatusConflict},
+		{http.MethodPost, "/hooks/slack", body, slackHeader("d2"), http.StatusConflict},
+		{http.MethodPost, "/hooks/github", `{"event":"forged"}`, githubHeader, http.StatusUnauthorized},
+		{http.MethodPost, "/hooks/github", body, http.Header{}, http.StatusUnauthorized},
+		{http.MethodGet, "/hooks/github", "", githubHeader, http.StatusMethodNotAllowed},
+		{http.MethodPost, "/hooks/stripe", body, stripeHeader(now), http.StatusOK},
+		{http.MethodPost, "/hooks/stripe", body, stripeHeader(stale), http.StatusUnauthorized},
+		{http.MethodPost, "/web/home/index", body, nil, http.StatusTeapot},
+	}
+
+	for i, c := range cases {
+		if status := serve(c.method, c.path, c.body, c.header); status != c.status {
+			t.Error("case", i, "expecting", c.status, "but got", status)
+			return
+		}
+	}
+
+	if len(received) != 3 || received[0] != body || received[1] != body || received[2] != body {
+		t.Error("expecting three verified payloads but got", received)
+		return
+	}
+}
@@ -1,9 +1,12 @@
 package cypress
 
 import (
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"html/template"
+	"net"
 	"net/http"
 	"path/filepath"
 	"reflect"
@@ -12,7 +15,6 @@ import (
 
 	"github.com/dchest/captcha"
 
-	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"go.uber.org/zap"
 )
@@ -38,6 +40,7 @@ var (
 
 	requestType  = reflect.TypeOf(http.Request{})
 	responseType = reflect.TypeOf(Response{})
+	errorType    = reflect.TypeOf((*error)(nil)).Elem()
 
 	errorTemplate, _ = template.New("errorTemplate").Parse(`<!DOCTYPE html>
 	<html>
@@ -63,9 +66,17 @@ var (
 
 // Response web response
 type Response struct {
-	traceID string
-	tmplMgr *TemplateManager
-	writer  http.ResponseWriter
+	traceID      string
+	skin         string
+	tmplMgr      *TemplateManager
+	features     *FeatureFlags
+	request      *http.Request
+	writer       http.ResponseWriter
+	target       http.ResponseWriter
+	buffer       *outputRecorder
+	etagEnabled  bool
+	weakETag     bool
+	lastModified time.Time
 }
 
 type errorPage struct {
@@ -82,6 +93,30 @@ type ActionHandler func(request *http.Request, response *Response)
 type Action struct {
 	Name    string
 	Handler ActionHandler
+
+	// Methods the http methods that are allowed for the action,
+	// all methods are allowed if it's empty
+	Methods []string
+
+	// Summary the summary of the action for api document
+	Summary string
+
+	// Parameters a struct type whose fields tagged with "query" or
+	// "header" describe the parameters of the action for api document
+	Parameters reflect.Type
+
+	// Body the type of the json request body for api document
+	Body reflect.Type
+
+	// Result the type of the json response for api document
+	Result reflect.Type
+
+	// Authenticated requires the user to be authenticated to access the action
+	Authenticated bool
+
+	// Roles the roles required to access the action, the user must have any
+	// of them, implies Authenticated if it's not empty
+	Roles []string
 }
 
 // Controller a request controller that could provide a set of
@@ -90,6 +125,11 @@ type Controller interface {
 	ListActions() []Action
 }
 
+// ControllerOption an option that is applied to each action of a controller
+// while the controller is registered, the option could decorate the action's
+// handler and returns the action to be registered
+type ControllerOption func(controller string, action Action) Action
+
 // ControllerFunc a function that implements Controller interface
 type ControllerFunc func() []Action
 
@@ -116,17 +156,32 @@ func (h CustomHandlerFunc) PipelineWith(handler http.Handler) http.Handler {
 // WebServer a web server that supports auth & authz, logging,
 // session and web sockets
 type WebServer struct {
-	server             *http.Server
-	router             *mux.Router
-	securityHandler    *SecurityHandler
-	skinManager        *SkinManager
-	sessionStore       SessionStore
-	sessionTimeout     time.Duration
-	registeredHandlers map[string]map[string]ActionHandler
-	customHandler      CustomHandler
-	captchaDigits      int
-	captchaWidth       int
-	captchaHeight      int
+	server            *http.Server
+	router            *mux.Router
+	securityHandler   *SecurityHandler
+	skinManager       *SkinManager
+	sessionStore      SessionStore
+	sessionTimeout    time.Duration
+	registeredActions map[string]map[string]Action
+	routingPrefix     string
+	customHandler     CustomHandler
+	captchaDigits     int
+	captchaWidth      int
+	captchaHeight     int
+	maxConcurrency    int
+	retryAfter        time.Duration
+	services          map[string]*serviceEntry
+	features          *FeatureFlags
+	trustedProxies    []*net.IPNet
+	ipRules           []*IPRule
+	webhooks          map[string]*Webhook
+	scheduler         *Scheduler
+	recorder          *Recorder
+	wsHandlers        map[string]*WebSocketHandler
+	securityHeaders   *SecurityHeaders
+	headerOverrides   map[string]*SecurityHeaders
+	cspCollectors     map[string]http.Handler
+	i18n              *I18n
 }
 
 // SendError complete the request by sending an error message to the client
@@ -138,40 +193,87 @@ func SendError(writer http.ResponseWriter, statusCode int, errorMsg string) {
 
 // AsController enumerates all accessible member functions of c
 // which has two parameters and *http.Request as the first one
-// while *Response as the second one as Actions
+// while *Response as the second one as Actions, the member functions
+// could return nothing, an error or a result and an error, a non-nil
+// error is sent to the client by Response.DoneWithHTTPError, while a
+// non-nil result is sent to the client as json
 func AsController(c interface{}) ControllerFunc {
 	return ControllerFunc(func() []Action {
-		t := reflect.TypeOf(c)
-		actions := make([]Action, 0, 8)
-		for i := 0; i < t.NumMethod(); i = i + 1 {
-			m := t.Method(i)
-			t := m.Func.Type()
-			if t.NumIn() != 3 {
-				continue
-			}
+		receiver := reflect.ValueOf(c)
+		return applyRequiredRoles(c, controllerActions(reflect.TypeOf(c), func(request *http.Request, response *Response) (reflect.Value, error) {
+			return receiver, nil
+		}))
+	})
+}
 
-			typeOfParam1 := t.In(1)
-			typeOfParam2 := t.In(2)
-			if typeOfParam1.Kind() != reflect.Ptr || typeOfParam2.Kind() != reflect.Ptr {
-				continue
-			}
+// controllerActions enumerates the action methods of type t as AsController does,
+// the receiver function provides the object to call the methods on for each request
+func controllerActions(t reflect.Type, receiver func(request *http.Request, response *Response) (reflect.Value, error)) []Action {
+	actions := make([]Action, 0, 8)
+	for i := 0; i < t.NumMethod(); i = i + 1 {
+		m := t.Method(i)
+		t := m.Func.Type()
+		if t.NumIn() != 3 {
+			continue
+		}
 
-			typeOfParam1 = typeOfParam1.Elem()
-			typeOfParam2 = typeOfParam2.Elem()
-			if requestType.AssignableTo(typeOfParam1) &&
-				responseType.AssignableTo(typeOfParam2) {
-				actions = append(actions, Action{
-					Name: strings.ToLower(m.Name[0:1]) + m.Name[1:],
-					Handler: ActionHandler(func(request *http.Request, response *Response) {
-						args := []reflect.Value{reflect.ValueOf(c), reflect.ValueOf(request), reflect.ValueOf(response)}
-						m.Func.Call(args[:])
-					}),
-				})
-			}
+		typeOfParam1 := t.In(1)
+		typeOfParam2 := t.In(2)
+		if typeOfParam1.Kind() != reflect.Ptr || typeOfParam2.Kind() != reflect.Ptr {
+			continue
 		}
 
-		return actions
-	})
+		typeOfParam1 = typeOfParam1.Elem()
+		typeOfParam2 = typeOfParam2.Elem()
+		if !requestType.AssignableTo(typeOfParam1) ||
+			!responseType.AssignableTo(typeOfParam2) {
+			continue
+		}
+
+		numOut := t.NumOut()
+		if numOut > 2 || (numOut > 0 && t.Out(numOut-1) != errorType) {
+			continue
+		}
+
+		var resultType reflect.Type
+		if numOut == 2 {
+			resultType = t.Out(0)
+		}
+
+		actions = append(actions, Action{
+			Name:   strings.ToLower(m.Name[0:1]) + m.Name[1:],
+			Result: resultType,
+			Handler: ActionHandler(func(request *http.Request, response *Response) {
+				c, err := receiver(request, response)
+				if err != nil {
+					response.DoneWithHTTPError(err)
+					return
+				}
+
+				args := []reflect.Value{c, reflect.ValueOf(request), reflect.ValueOf(response)}
+				results := m.Func.Call(args[:])
+				if len(results) == 0 {
+					return
+				}
+
+				if err, ok := results[len(results)-1].Interface().(error); ok && err != nil {
+					if len(results) == 2 {
+						response.doneWithHTTPError(err, true)
+					} else {
+						response.DoneWithHTTPError(err)
+					}
+
+					return
+				}
+
+				if len(results) == 2 && !isNilValue(results[0]) {
+					response.DoneWithJSON(http.StatusOK, results[0].Interface())
+				}
+			}),
+		})
+	}
+
+	return actions
 }
 
 // SetHeader sets a header value for response
@@ -201,6 +303,113 @@ func (r *Response) SetNoCache() {
 	r.SetHeader("Pragma", "no-cache")
 }
 
+// EnableETag buffers the response and computes an ETag from the content
+// when the response is done, the ETag is weak if weak is true. If the
+// ETag matches the If-None-Match header of the request, a 304 status
+// is sent to the client instead of the content
+func (r *Response) EnableETag(weak bool) {
+	r.etagEnabled = true
+	r.weakETag = weak
+	r.enableBuffering()
+}
+
+// SetLastModified sets the Last-Modified header of the response and buffers
+// the response, if the request's If-Modified-Since header is not earlier
+// than t, a 304 status is sent to the client instead of the content
+func (r *Response) SetLastModified(t time.Time) {
+	r.lastModified = t.UTC().Truncate(time.Second)
+	r.enableBuffering()
+	r.writer.Header().Set("Last-Modified", r.lastModified.Format(http.TimeFormat))
+}
+
+func (r *Response) enableBuffering() {
+	if r.buffer == nil {
+		r.buffer = newOutputRecorder()
+		r.target = r.writer
+		r.writer = r.buffer
+	}
+}
+
+// flush writes the buffered content to the client, or a 304 status if the
+// content is not modified based on the conditional headers of the request
+func (r *Response) flush() {
+	if r.buffer == nil {
+		return
+	}
+
+	output := r.buffer.output()
+	r.writer = r.target
+	r.buffer = nil
+	r.target = nil
+	if r.etagEnabled && output.StatusCode == http.StatusOK && output.Header.Get("ETag") == "" {
+		etag := "\"" + hex.EncodeToString(Sha1(output.Body)) + "\""
+		if r.weakETag {
+			etag = "W/" + etag
+		}
+
+		output.Header.Set("ETag", etag)
+	}
+
+	if output.StatusCode == http.StatusOK && r.request != nil && isNotModified(r.request, output.Header) {
+		writeNotModified(r.writer, output.Header)
+		return
+	}
+
+	output.WriteTo(r.writer)
+}
+
+// isNotModified checks the conditional headers of the request against
+// the ETag and Last-Modified headers of the response
+func isNotModified(request *http.Request, header http.Header) bool {
+	if request.Method != http.MethodGet && request.Method != http.MethodHead {
+		return false
+	}
+
+	if ifNoneMatch := request.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		etag := strings.TrimPrefix(header.Get("ETag"), "W/")
+		if etag == "" {
+			return false
+		}
+
+		for _, item := range strings.Split(ifNoneMatch, ",") {
+			item = strings.TrimSpace(item)
+			if item == "*" || strings.TrimPrefix(item, "W/") == etag {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	ifModifiedSince := request.Header.Get("If-Modified-Since")
+	lastModified := header.Get("Last-Modified")
+	if ifModifiedSince == "" || lastModified == "" {
+		return false
+	}
+
+	since, err := http.ParseTime(ifModifiedSince)
+	if err != nil {
+		return false
+	}
+
+	modified, err := http.ParseTime(lastModified)
+	if err != nil {
+		return false
+	}
+
+	return !modified.After(since)
+}
+
+func writeNotModified(writer http.ResponseWriter, header http.Header) {
+	for _, name := range []string{"Cache-Control", "Content-Location", "Date", "ETag", "Expires", "Last-Modified", "Vary"} {
+		if values, ok := header[name]; ok {
+			writer.Header()[name] = values
+		}
+	}
+
+	writer.WriteHeader(http.StatusNotModified)
+}
+
 // DoneWithRedirect redirects to the specified url
 func (r *Response) DoneWithRedirect(req *http.Request, url string, status int) {
 	http.Redirect(r.writer, req, url, status)
@@ -231,9 +440,49 @@ func (r *Response) DoneWithTemplate(statusCode int, name string, model interface
 		return
 	}
 
+	funcs := template.FuncMap{}
+	if r.request != nil {
+		if r.features != nil {
+			for key, value := range r.features.templateFuncs(r.request) {
+				funcs[key] = value
+			}
+		}
+
+		if i18n := getI18n(r.request); i18n != nil {
+			for key, value := range i18n.templateFuncs(GetLocale(r.request)) {
+				funcs[key] = value
+			}
+		}
+
+		if nonce := GetCSPNonce(r.request); nonce != "" {
+			funcs["cspNonce"] = func() string {
+				return nonce
+			}
+		}
+	}
+
+	// once the shared template is executed, it could never be cloned again, so the
+	// functions bound to the request are set on a clone for this request only, and
+	// the templates without such functions are executed by a clone cached per set
+	var err error
+	if len(funcs) > 0 {
+		tmpl, err = tmpl.Clone()
+		if err == nil {
+			tmpl = tmpl.Funcs(funcs)
+		}
+	} else {
+		tmpl, err = r.tmplMgr.getExecutable(name)
+	}
+
+	if err != nil {
+		zap.L().Error("failedToCloneTemplate", zap.Error(err), zap.String("name", name), zap.String("activityId", r.traceID))
+		SendError(r.writer, 500, "service configuration error")
+		return
+	}
+
 	r.SetStatus(statusCode)
 	r.SetHeader("Content-Type", "text/html; charset=UTF-8")
-	err := tmpl.ExecuteTemplate(r.writer, filepath.Base(name), model)
+	err = tmpl.ExecuteTemplate(r.writer, filepath.Base(name), model)
 	if err != nil {
 		zap.L().Error("failedToExecuteTemplate", zap.Error(err), zap.String("name", name), zap.String("activityId", r.traceID))
 		errorTemplate.Execute(r.writer, &errorPage{statusCode, "template error", ServerName, ServerVersion})
@@ -256,20 +505,26 @@ func (r *Response) DoneWithJSON(statusCode int, obj interface{}) {
 // NewWebServer creates a web server instance to listen on the
 // specified address
 func NewWebServer(listenAddr string, skinMgr *SkinManager) *WebServer {
-	return &WebServer{
+	server := &WebServer{
 		server: &http.Server{
 			Addr: listenAddr,
 		},
-		router:             mux.NewRouter(),
-		securityHandler:    NewSecurityHandler(),
-		skinManager:        skinMgr,
-		sessionTimeout:     time.Minute * 30,
-		registeredHandlers: make(map[string]map[string]ActionHandler),
-		customHandler:      nil,
-		captchaDigits:      6,
-		captchaWidth:       captcha.StdWidth,
-		captchaHeight:      captcha.StdHeight,
+		router:            mux.NewRouter(),
+		securityHandler:   NewSecurityHandler(),
+		skinManager:       skinMgr,
+		sessionTimeout:    time.Minute * 30,
+		registeredActions: make(map[string]map[string]Action),
+		customHandler:     nil,
+		captchaDigits:     6,
+		captchaWidth:      captcha.StdWidth,
+		captchaHeight:     captcha.StdHeight,
+		services:          make(map[string]*serviceEntry),
+		scheduler:         NewScheduler(),
+		wsHandlers:        make(map[string]*WebSocketHandler),
 	}
+
+	server.registerBuiltinServices()
+	return server
 }
 
 // HandleFunc register a handle function for a path pattern
@@ -281,6 +536,7 @@ func (server *WebServer) HandleFunc(path string, f func(w http.ResponseWriter, r
 // WithStandardRouting setup a routing as "prefix" + "/{controller:[_a-zA-Z][_a-zA-Z0-9]*}/{action:[_a-zA-Z][_a-zA-Z0-9]*}"
 // and the web server will route the requests based on the registered controllers.
 func (server *WebServer) WithStandardRouting(prefix string) *WebServer {
+	server.routingPrefix = prefix
 	server.router.HandleFunc(prefix+"/{controller:[_a-zA-Z][_a-zA-Z0-9]*}/{action:[_a-zA-Z][_a-zA-Z0-9]*}", server.routeRequest)
 	return server
 }
@@ -300,21 +556,26 @@ func (server *WebServer) WithCaptcha(path string) *WebServer {
 	return server
 }
 
-// RegisterController register a controller for the standard routing
-func (server *WebServer) RegisterController(name string, controller Controller) error {
-	actions, ok := server.registeredHandlers[name]
+// RegisterController register a controller for the standard routing, the options
+// will be applied to all actions of the controller in the given order
+func (server *WebServer) RegisterController(name string, controller Controller, options ...ControllerOption) error {
+	actions, ok := server.registeredActions[name]
 	if !ok {
-		actions = make(map[string]ActionHandler)
-		server.registeredHandlers[name] = actions
+		actions = make(map[string]Action)
+		server.registeredActions[name] = actions
 	}
 
-	for _, item := range controller.ListActions() {
+	for _, item := range applyRequiredRoles(controller, controller.ListActions()) {
+		for _, option := range options {
+			item = option(name, item)
+		}
+
 		_, ok = actions[item.Name]
 		if ok {
 			return ErrDupActionName
 		}
 
-		actions[item.Name] = item.Handler
+		actions[item.Name] = item
 	}
 
 	return nil
@@ -359,6 +620,7 @@ func (server *WebServer) AddWsEndoint(endpoint string, listener WebSocketListene
 	wsHandler := &WebSocketHandler{
 		Listener: listener,
 	}
+	server.wsHandlers[endpoint] = wsHandler
 	server.router.HandleFunc(endpoint, wsHandler.Handle)
 	return server
 }
@@ -377,15 +639,38 @@ func (server *WebServer) WithSessionOptions(store SessionStore, timeout time.Dur
 	return server
 }
 
+// WithMaxConcurrentRequests limits the number of requests that could be served concurrently,
+// excess requests are rejected early with a 503 status and a Retry-After header
+func (server *WebServer) WithMaxConcurrentRequests(limit int, retryAfter time.Duration) *WebServer {
+	server.maxConcurrency = limit
+	server.retryAfter = retryAfter
+	return server
+}
+
 // Shutdown shutdown the web server
 func (server *WebServer) Shutdown() {
+	server.scheduler.Stop()
 	server.server.Shutdown(nil)
 }
 
-// Start starts the web server
+// Start starts the web server, the scheduled jobs are stopped if the server fails
+// to listen or serve
 func (server *WebServer) Start() error {
+	http.Handle("/", server.pipeline(true))
+	server.scheduler.Start()
+	err := server.server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		server.scheduler.Stop()
+	}
+
+	return err
+}
+
+// pipeline builds the handlers chain for serving the requests, the recorder
+// is skipped for replaying the recorded requests
+func (server *WebServer) pipeline(recording bool) http.Handler {
 	server.router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		SendError(w, 404, NotFoundMsg)
+		SendError(w, 404, Localize(req, NotFoundMsgKey, NotFoundMsg))
 	})
 	handler := http.Handler(server.securityHandler.WithPipeline(server.router))
 	if server.customHandler != nil {
@@ -393,20 +678,56 @@ func (server *WebServer) Start() error {
 	}
 
 	handler = NewSessionHandler(handler, server.sessionStore, server.sessionTimeout)
+	if len(server.webhooks) > 0 {
+		handler = newWebhookRouter(handler, server.webhooks)
+	}
+
+	if len(server.cspCollectors) > 0 {
+		handler = &cspReportRouter{server.cspCollectors, handler}
+	}
+
+	if len(server.ipRules) > 0 {
+		handler = NewIPFilterHandler(handler, server.ipRules)
+	}
+
+	if server.maxConcurrency > 0 {
+		handler = NewConcurrencyLimitHandler(handler, server.maxConcurrency, server.retryAfter)
+	}
+
+	if recording && server.recorder != nil {
+		handler = NewRecordingHandler(handler, server.recorder)
+	}
+
+	if server.securityHeaders != nil || len(server.headerOverrides) > 0 {
+		handler = NewSecurityHeadersHandler(handler, server.securityHeaders, server.headerOverrides)
+	}
+
+	if server.i18n != nil {
+		handler = server.i18n.contextHandler(handler)
+	}
+
 	handler = LoggingHandler(handler)
-	handler = handlers.ProxyHeaders(handler)
-	http.Handle("/", handler)
-	return server.server.ListenAndServe()
+	return NewProxyHeadersHandler(handler, server.trustedProxies)
 }
 
 func (server *WebServer) routeRequest(writer http.ResponseWriter, request *http.Request) {
 	routeVars := mux.Vars(request)
 	zap.L().Debug("routeRequest", zap.String("controller", routeVars["controller"]), zap.String("action", routeVars["action"]), zap.String("activityId", GetTraceID(request.Context())))
 	if routeVars != nil {
-		actions, ok := server.registeredHandlers[routeVars["controller"]]
+		actions, ok := server.registeredActions[routeVars["controller"]]
 		if ok {
-			handler, ok := actions[routeVars["action"]]
+			action, ok := actions[routeVars["action"]]
 			if ok {
+				if !isMethodAllowed(action.Methods, request.Method) {
+					writer.Header().Set("Allow", strings.Join(action.Methods, ", "))
+					SendError(writer, http.StatusMethodNotAllowed, "Method not allowed")
+					return
+				}
+
+				if !server.checkActionRoles(writer, request, &action) {
+					return
+				}
+
 				tmplMgr, name := server.skinManager.ApplySelector(request)
 				if tmplMgr == nil {
 					zap.L().Error("skinNotFound", zap.String("skin", name), zap.String("activityId", GetTraceID(request.Context())))
@@ -415,17 +736,35 @@ func (server *WebServer) routeRequest(writer http.ResponseWriter, request *http.
 				}
 
 				response := &Response{
-					traceID: GetTraceID(request.Context()),
-					tmplMgr: tmplMgr,
-					writer:  writer,
+					traceID:  GetTraceID(request.Context()),
+					skin:     name,
+					tmplMgr:  tmplMgr,
+					features: server.features,
+					request:  request,
+					writer:   writer,
 				}
-				handler(request, response)
+				action.Handler(request, response)
+				response.flush()
 				return
 			}
 		}
 	}
 
-	SendError(writer, http.StatusNotFound, NotFoundMsg)
+	SendError(writer, http.StatusNotFound, Localize(request, NotFoundMsgKey, NotFoundMsg))
+}
+
+func isMethodAllowed(methods []string, method string) bool {
+	if len(methods) == 0 {
+		return true
+	}
+
+	for _, item := range methods {
+		if strings.EqualFold(item, method) {
+			return true
+		}
+	}
+
+	return false
 }
 
 func (server *WebServer) createCaptcha(writer http.ResponseWriter, request *http.Request) {
@@ -485,3 +824,16 @@ func (server *WebServer) createCaptcha(writer http.ResponseWriter, request *http
 	writer.Header().Add("Content-Type", "image/png")
 	image.WriteTo(writer)
 }
+
+// VerifyCaptcha checks the answer against the captcha generated for the session of the
+// request, the captcha is removed from the session so that it could only be used once
+func VerifyCaptcha(request *http.Request, answer string) bool {
+	session := GetSession(request)
+	if session == nil {
+		return false
+	}
+
+	value, ok := session.GetAsFlashValue(CaptchaKey)
+	expected, _ := value.(string)
+	return ok && expected != "" && subtle.ConstantTimeCompare([]byte(expected), []byte(answer)) == 1
+}
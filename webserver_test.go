@@ -6,6 +6,7 @@ import (
 	"html/template"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
 	"strings"
@@ -392,3 +393,60 @@ func TestWebServer(t *testing.T) {
 		t.Error("failed to read back the message")
 	}
 }
+
+func TestConditionalResponse(t *testing.T) {
+	lastModified := time.Date(2019, 5, 1, 10, 0, 0, 0, time.UTC)
+	serve := func(header http.Header, done func(response *Response)) *httptest.ResponseRecorder {
+		writer := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "/web/test/index", nil)
+		for name, values := range header {
+			request.Header[name] = values
+		}
+
+		response := &Response{request: request, writer: writer}
+		done(response)
+		response.flush()
+		return writer
+	}
+
+	withETag := func(response *Response) {
+		response.EnableETag(false)
+		response.DoneWithJSON(http.StatusOK, &TestObj{1, "abc"})
+	}
+
+	result := serve(nil, withETag)
+	etag := result.Header().Get("ETag")
+	if result.Code != http.StatusOK || etag == "" || result.Body.Len() == 0 {
+		t.Error("expecting content with ETag but got", result.Code, etag)
+		return
+	}
+
+	result = serve(http.Header{"If-None-Match": []string{etag}}, withETag)
+	if result.Code != http.StatusNotModified || result.Body.Len() != 0 {
+		t.Error("expecting 304 but got", result.Code)
+		return
+	}
+
+	result = serve(http.Header{"If-None-Match": []string{"\"abc\""}}, withETag)
+	if result.Code != http.StatusOK {
+		t.Error("expecting 200 but got", result.Code)
+		return
+	}
+
+	withLastModified := func(response *Response) {
+		response.SetLastModified(lastModified)
+		response.DoneWithContent(http.StatusOK, "text/plain", []byte("content"))
+	}
+
+	result = serve(http.Header{"If-Modified-Since": []string{lastModified.Format(http.TimeFormat)}}, withLastModified)
+	if result.Code != http.StatusNotModified {
+		t.Error("expecting 304 but got", result.Code)
+		return
+	}
+
+	result = serve(http.Header{"If-Modified-Since": []string{lastModified.Add(-time.Hour).Format(http.TimeFormat)}}, withLastModified)
+	if result.Code != http.StatusOK || result.Body.String() != "content" {
+		t.Error("expecting content but got", result.Code, result.Body.String())
+		return
+	}
+}
@@ -2,6 +2,7 @@ package cypress
 
 import (
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -10,9 +11,12 @@ import (
 
 // WebSocketSession a connected web socket session
 type WebSocketSession struct {
+	ID           string
 	User         *UserPrincipal
 	Session      *Session
 	Context      map[string]interface{}
+	RemoteAddr   string
+	ConnectedAt  time.Time
 	connection   *websocket.Conn
 	writeTimeout time.Duration
 }
@@ -65,6 +69,28 @@ type WebSocketHandler struct {
 	WriteTimeout     time.Duration
 	Listener         WebSocketListener
 	WriteCompression bool
+	sessions         sync.Map
+}
+
+// Sessions returns the web socket sessions that are connected to the handler
+func (handler *WebSocketHandler) Sessions() []*WebSocketSession {
+	result := make([]*WebSocketSession, 0, 16)
+	handler.sessions.Range(func(key, value interface{}) bool {
+		result = append(result, value.(*WebSocketSession))
+		return true
+	})
+
+	return result
+}
+
+// GetSession gets the connected web socket session by id
+func (handler *WebSocketHandler) GetSession(id string) (*WebSocketSession, bool) {
+	value, ok := handler.sessions.Load(id)
+	if !ok {
+		return nil, false
+	}
+
+	return value.(*WebSocketSession), true
 }
 
 // Handle handles the incomping web requests and try to upgrade the request into a websocket connection
@@ -111,12 +137,23 @@ func (handler *WebSocketHandler) Handle(writer http.ResponseWriter, request *htt
 		conn.EnableWriteCompression(true)
 	}
 
-	webSocketSession := &WebSocketSession{userPrincipal, session, make(map[string]interface{}), conn, handler.WriteTimeout}
+	webSocketSession := &WebSocketSession{
+		ID:           NewSessionID(),
+		User:         userPrincipal,
+		Session:      session,
+		Context:      make(map[string]interface{}),
+		RemoteAddr:   request.RemoteAddr,
+		ConnectedAt:  time.Now(),
+		connection:   conn,
+		writeTimeout: handler.WriteTimeout,
+	}
+	handler.sessions.Store(webSocketSession.ID, webSocketSession)
 	handler.Listener.OnConnect(webSocketSession)
 	go handler.connectionLoop(webSocketSession)
 }
 
 func (handler *WebSocketHandler) connectionLoop(session *WebSocketSession) {
+	defer handler.sessions.Delete(session.ID)
 	for {
 		if handler.ReadTimeout > time.Duration(0) {
 			session.connection.SetReadDeadline(time.Now().Add(handler.ReadTimeout))